@@ -12,6 +12,16 @@ type ServerConfig struct {
 	TimeSubtractionMS int64  // Время в миллисекундах для операций вычитания.
 	TimeMultiplyMS    int64  // Время в миллисекундах для операций умножения.
 	TimeDivisionMS    int64  // Время в миллисекундах для операций деления.
+	TimePowerMS       int64  // Время в миллисекундах для операций возведения в степень.
+	TimeModuloMS      int64  // Время в миллисекундах для операций взятия остатка.
+	TimeBitwiseMS     int64  // Время в миллисекундах для побитовых операций и сдвигов.
+	TimeComparisonMS  int64  // Время в миллисекундах для сравнений и логических операций.
+	TimeFunctionMS    int64  // Базовое время в миллисекундах для вызова функции, умножается на её стоимость.
+	FoldConstants     string // Какие операции с известными операндами оркестратор вычисляет сам: none, cheap или all.
+	LeaseTimeoutMS    int64  // Срок аренды задачи в миллисекундах: не вернувший результат агент теряет задачу.
+	ReaperIntervalMS  int64  // Период в миллисекундах, с которым оркестратор возвращает в очередь задачи с истёкшей арендой.
+	MaxTaskAttempts   int    // Сколько раз задача выдаётся агентам, прежде чем попасть в список недоставленных.
+	RetryBackoffMS    int64  // Пауза в миллисекундах перед второй попыткой; перед каждой следующей она удваивается.
 }
 
 func NewServerConfig() (*ServerConfig, error) {
@@ -35,6 +45,70 @@ func NewServerConfig() (*ServerConfig, error) {
 		return nil, fmt.Errorf("invalid TIME_DIVISIONS_MS: %w", err)
 	}
 
+	timePow, err := getEnvInt64("TIME_POWER_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_POWER_MS: %w", err)
+	}
+
+	timeMod, err := getEnvInt64("TIME_MODULO_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_MODULO_MS: %w", err)
+	}
+
+	timeBit, err := getEnvInt64("TIME_BITWISE_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_BITWISE_MS: %w", err)
+	}
+
+	timeCmp, err := getEnvInt64("TIME_COMPARISON_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_COMPARISON_MS: %w", err)
+	}
+
+	timeFunc, err := getEnvInt64("TIME_FUNCTION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_FUNCTION_MS: %w", err)
+	}
+
+	foldConstants := getEnvString("FOLD_CONSTANTS", "cheap")
+	switch foldConstants {
+	case "none", "cheap", "all":
+	default:
+		return nil, fmt.Errorf("invalid FOLD_CONSTANTS: expected none, cheap or all, got %q", foldConstants)
+	}
+
+	leaseTimeout, err := getEnvInt64("LEASE_TIMEOUT_MS", 30000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LEASE_TIMEOUT_MS: %w", err)
+	}
+	if leaseTimeout <= 0 {
+		return nil, fmt.Errorf("invalid LEASE_TIMEOUT_MS: must be positive, got %d", leaseTimeout)
+	}
+
+	reaperInterval, err := getEnvInt64("REAPER_INTERVAL_MS", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REAPER_INTERVAL_MS: %w", err)
+	}
+	if reaperInterval <= 0 {
+		return nil, fmt.Errorf("invalid REAPER_INTERVAL_MS: must be positive, got %d", reaperInterval)
+	}
+
+	maxAttempts, err := getEnvInt64("MAX_TASK_ATTEMPTS", 3)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_TASK_ATTEMPTS: %w", err)
+	}
+	if maxAttempts <= 0 {
+		return nil, fmt.Errorf("invalid MAX_TASK_ATTEMPTS: must be positive, got %d", maxAttempts)
+	}
+
+	retryBackoff, err := getEnvInt64("RETRY_BACKOFF_MS", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RETRY_BACKOFF_MS: %w", err)
+	}
+	if retryBackoff < 0 {
+		return nil, fmt.Errorf("invalid RETRY_BACKOFF_MS: must not be negative, got %d", retryBackoff)
+	}
+
 	port := getEnvString("PORT", "8080")
 
 	return &ServerConfig{
@@ -43,6 +117,16 @@ func NewServerConfig() (*ServerConfig, error) {
 		TimeSubtractionMS: timeSub,
 		TimeMultiplyMS:    timeMul,
 		TimeDivisionMS:    timeDiv,
+		TimePowerMS:       timePow,
+		TimeModuloMS:      timeMod,
+		TimeBitwiseMS:     timeBit,
+		TimeComparisonMS:  timeCmp,
+		TimeFunctionMS:    timeFunc,
+		FoldConstants:     foldConstants,
+		LeaseTimeoutMS:    leaseTimeout,
+		ReaperIntervalMS:  reaperInterval,
+		MaxTaskAttempts:   int(maxAttempts),
+		RetryBackoffMS:    retryBackoff,
 	}, nil
 }
 
@@ -13,6 +13,12 @@ type WorkerConfig struct {
 	SubtractionTimeMS int64  // Время в миллисекундах для операций вычитания.
 	MultiplyTimeMS    int64  // Время в миллисекундах для операций умножения.
 	DivisionTimeMS    int64  // Время в миллисекундах для операций деления.
+	PowerTimeMS       int64  // Время в миллисекундах для операций возведения в степень.
+	ModuloTimeMS      int64  // Время в миллисекундах для операций взятия остатка.
+	BitwiseTimeMS     int64  // Время в миллисекундах для побитовых операций и сдвигов.
+	ComparisonTimeMS  int64  // Время в миллисекундах для сравнений и логических операций.
+	FunctionTimeMS    int64  // Базовое время в миллисекундах для вызова функции, умножается на её стоимость.
+	CancelCheckMS     int64  // Период в миллисекундах, с которым агент во время вычисления проверяет, не отменена ли задача; 0 — не проверять.
 }
 
 func NewWorkerConfig() (*WorkerConfig, error) {
@@ -41,6 +47,36 @@ func NewWorkerConfig() (*WorkerConfig, error) {
 		return nil, fmt.Errorf("invalid TIME_DIVISIONS_MS: %w", err)
 	}
 
+	timePow, err := getWorkerEnvInt64("TIME_POWER_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_POWER_MS: %w", err)
+	}
+
+	timeMod, err := getWorkerEnvInt64("TIME_MODULO_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_MODULO_MS: %w", err)
+	}
+
+	timeBit, err := getWorkerEnvInt64("TIME_BITWISE_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_BITWISE_MS: %w", err)
+	}
+
+	timeCmp, err := getWorkerEnvInt64("TIME_COMPARISON_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_COMPARISON_MS: %w", err)
+	}
+
+	timeFunc, err := getWorkerEnvInt64("TIME_FUNCTION_MS", 100)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_FUNCTION_MS: %w", err)
+	}
+
+	cancelCheck, err := getWorkerEnvInt64("CANCEL_CHECK_MS", 200)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CANCEL_CHECK_MS: %w", err)
+	}
+
 	return &WorkerConfig{
 		ComputingPower:    power,
 		OrchestratorURL:   getWorkerEnvString("ORCHESTRATOR_URL", "http://localhost:8080"),
@@ -48,6 +84,12 @@ func NewWorkerConfig() (*WorkerConfig, error) {
 		SubtractionTimeMS: timeSub,
 		MultiplyTimeMS:    timeMul,
 		DivisionTimeMS:    timeDiv,
+		PowerTimeMS:       timePow,
+		ModuloTimeMS:      timeMod,
+		BitwiseTimeMS:     timeBit,
+		ComparisonTimeMS:  timeCmp,
+		FunctionTimeMS:    timeFunc,
+		CancelCheckMS:     cancelCheck,
 	}, nil
 }
 
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// handleListDeadLetters перечисляет задачи, исчерпавшие попытки выполнения.
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, _ *http.Request) {
+	tasks := s.storage.ListDeadLetters()
+	s.logger.Debug("Listing dead-letter tasks",
+		zap.Int(constants.FieldCount, len(tasks)))
+	s.writeJSON(w, http.StatusOK, models.DeadLetterResponse{Tasks: tasks})
+}
+
+// handleRequeueDeadLetter возвращает задачу из списка недоставленных в очередь агентов.
+func (s *Server) handleRequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.storage.RequeueDeadLetter(id); err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrDeadLetterNotFound)
+		return
+	}
+	s.logger.Info("Dead-letter task requeued by operator",
+		zap.String(constants.FieldTaskID, id))
+	w.WriteHeader(http.StatusNoContent)
+}
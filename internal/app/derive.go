@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
+
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleDerive(w http.ResponseWriter, r *http.Request) {
+	var req models.DeriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode request body",
+			zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if req.Expression == "" || req.Var == "" {
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+
+	functions, err := s.userFunctions()
+	if err != nil {
+		s.logger.Error("Failed to load user functions", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedProcessExpression)
+		return
+	}
+
+	root, err := calculation.ParseWithFunctions(req.Expression, functions)
+	if err != nil {
+		s.logger.Warn(constants.LogFailedParseExpression,
+			zap.String(constants.FieldExpression, req.Expression),
+			zap.Error(err))
+		var parseErr *calculation.ParseError
+		if errors.As(err, &parseErr) {
+			s.writeParseError(w, parseErr)
+			return
+		}
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	derivative, err := calculation.Derive(root, req.Var, functions)
+	if err != nil {
+		s.logger.Warn("Failed to derive expression",
+			zap.String(constants.FieldExpression, req.Expression),
+			zap.String("var", req.Var),
+			zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	resp := models.DeriveResponse{
+		Derivative: calculation.Format(derivative),
+		AST:        calculation.NewTree(derivative),
+	}
+	if req.At == nil {
+		s.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	// Производную без операций, например 2 или y, агентам распределять нечего: она вычисляется сразу.
+	if !hasOperations(derivative) {
+		value, err := calculation.Arithmetic{}.Evaluate(derivative, req.At)
+		if err != nil {
+			s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		complexValue := calculation.ToComplex(value)
+		resp.Value = &models.Value{Re: real(complexValue), Im: imag(complexValue)}
+		s.writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	expr, ok := s.submitExpression(w, models.CalculateRequest{Expression: resp.Derivative, Variables: req.At})
+	if !ok {
+		return
+	}
+	resp.ID = expr.ID
+	s.writeJSON(w, http.StatusCreated, resp)
+}
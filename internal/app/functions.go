@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func (s *Server) handleCreateFunction(w http.ResponseWriter, r *http.Request) {
+	var fn models.Function
+	if err := json.NewDecoder(r.Body).Decode(&fn); err != nil {
+		s.logger.Error("Failed to decode function",
+			zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, constants.ErrInvalidRequestBody)
+		return
+	}
+	if fn.Params == nil {
+		fn.Params = []string{}
+	}
+
+	s.functionsMu.Lock()
+	defer s.functionsMu.Unlock()
+
+	// Новое определение проверяется вместе со всеми остальными: замена функции может сделать вызовы рекурсивными
+	// или нарушить число аргументов в телах других функций.
+	defs := []calculation.Definition{{Name: fn.Name, Params: fn.Params, Body: fn.Body}}
+	for _, stored := range s.storage.ListFunctions() {
+		if stored.Name != fn.Name {
+			defs = append(defs, definition(stored))
+		}
+	}
+	if _, err := calculation.CompileFunctions(defs); err != nil {
+		s.logger.Warn("Invalid function definition",
+			zap.String("name", fn.Name),
+			zap.String("body", fn.Body),
+			zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if err := s.storage.SaveFunction(&fn); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, models.FunctionResponse{Function: fn})
+}
+
+func (s *Server) handleListFunctions(w http.ResponseWriter, _ *http.Request) {
+	stored := s.storage.ListFunctions()
+	functions := make([]models.Function, len(stored))
+	for i, fn := range stored {
+		functions[i] = *fn
+	}
+	s.writeJSON(w, http.StatusOK, models.FunctionsResponse{Functions: functions})
+}
+
+func (s *Server) handleGetFunction(w http.ResponseWriter, r *http.Request) {
+	fn, err := s.storage.GetFunction(mux.Vars(r)["name"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrFunctionNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, models.FunctionResponse{Function: *fn})
+}
+
+func (s *Server) handleDeleteFunction(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.functionsMu.Lock()
+	defer s.functionsMu.Unlock()
+
+	if _, err := s.storage.GetFunction(name); err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrFunctionNotFound)
+		return
+	}
+	functions, err := s.userFunctions()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if callers := functions.Callers(name); len(callers) > 0 {
+		s.writeError(w, http.StatusConflict,
+			fmt.Sprintf("%s: %s", constants.ErrFunctionInUse, strings.Join(callers, ", ")))
+		return
+	}
+
+	if err := s.storage.DeleteFunction(name); err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrFunctionNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFunctions компилирует все сохранённые пользовательские функции.
+func (s *Server) userFunctions() (calculation.Functions, error) {
+	stored := s.storage.ListFunctions()
+	defs := make([]calculation.Definition, len(stored))
+	for i, fn := range stored {
+		defs[i] = definition(fn)
+	}
+	return calculation.CompileFunctions(defs)
+}
+
+// definition преобразует сохранённую функцию в определение для разбора.
+func definition(fn *models.Function) calculation.Definition {
+	return calculation.Definition{Name: fn.Name, Params: fn.Params, Body: fn.Body}
+}
@@ -2,11 +2,14 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"distributed_calculator/internal/constants"
 	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/app/storage"
+	"distributed_calculator/pkg/calculation"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -28,19 +31,54 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := s.parseExpression(req.Expression)
+	expr, ok := s.submitExpression(w, req)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, models.CalculateResponse{ID: expr.ID})
+}
+
+// submitExpression проверяет выражение, сохраняет его и запускает вычисление агентами.
+// При ошибке ответ уже записан в w и возвращается false.
+func (s *Server) submitExpression(w http.ResponseWriter, req models.CalculateRequest) (*models.Expression, bool) {
+	arith := calculation.Arithmetic{Mode: calculation.Mode(req.Mode), Precision: req.Precision}
+	if err := arith.Validate(); err != nil {
+		s.logger.Warn(constants.LogFailedParseExpression,
+			zap.String(constants.FieldExpression, req.Expression),
+			zap.Error(err))
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return nil, false
+	}
+
+	functions, err := s.userFunctions()
+	if err != nil {
+		s.logger.Error("Failed to load user functions", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedProcessExpression)
+		return nil, false
+	}
+
+	root, err := s.parseExpression(req.Expression, req.Variables, arith, functions)
 	if err != nil {
 		s.logger.Error(constants.LogFailedParseExpression,
 			zap.String(constants.FieldExpression, req.Expression),
 			zap.Error(err))
 
+		var parseErr *calculation.ParseError
+		if errors.As(err, &parseErr) {
+			s.writeParseError(w, parseErr)
+			return nil, false
+		}
 		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
-		return
+		return nil, false
 	}
 
 	expr := &models.Expression{
 		ID:         uuid.New().String(),
 		Expression: req.Expression,
+		Canonical:  calculation.Format(root),
+		Variables:  req.Variables,
+		Mode:       req.Mode,
+		Precision:  req.Precision,
 		Status:     models.StatusPending,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
@@ -51,7 +89,7 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 			zap.String(constants.FieldExpression, req.Expression),
 			zap.Error(err))
 		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedProcessExpression)
-		return
+		return nil, false
 	}
 
 	s.logger.Info("Expression received for calculation",
@@ -73,7 +111,7 @@ func (s *Server) handleCalculate(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	s.writeJSON(w, http.StatusCreated, models.CalculateResponse{ID: expr.ID})
+	return expr, true
 }
 
 func (s *Server) handleListExpressions(w http.ResponseWriter, _ *http.Request) {
@@ -102,6 +140,56 @@ func (s *Server) handleGetExpression(w http.ResponseWriter, r *http.Request) {
 	s.logger.Debug(constants.LogExpressionRetrieved,
 		zap.String("id", id),
 		zap.String(constants.FieldStatus, string(expr.Status)))
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		s.writeJSON(w, http.StatusOK, models.ExpressionResponse{Expression: *expr})
+		return
+	}
+	style, err := calculation.ParseStyle(format)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	functions, err := s.userFunctions()
+	if err != nil {
+		s.logger.Error("Failed to load user functions", zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedProcessExpression)
+		return
+	}
+	// Выражение разбирается заново: пользовательскую функцию из него могли удалить после вычисления.
+	root, err := calculation.ParseWithFunctions(expr.Expression, functions)
+	if err != nil {
+		s.writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, models.ExpressionResponse{Expression: *expr, Formatted: calculation.Print(root, style)})
+}
+
+// handleCancelExpression отменяет вычисление выражения. Агенты узнают об отмене, когда проверяют задачу
+// или отправляют её результат.
+func (s *Server) handleCancelExpression(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if _, err := s.storage.GetExpression(id); err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrExpressionNotFound)
+		return
+	}
+	if err := s.storage.CancelExpression(id); err != nil {
+		s.logger.Warn("Failed to cancel expression",
+			zap.String("id", id),
+			zap.Error(err))
+		s.writeError(w, http.StatusConflict, constants.ErrExpressionFinished)
+		return
+	}
+
+	expr, err := s.storage.GetExpression(id)
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrExpressionNotFound)
+		return
+	}
+	s.logger.Info("Expression cancelled",
+		zap.String("id", id))
 	s.writeJSON(w, http.StatusOK, models.ExpressionResponse{Expression: *expr})
 }
 
@@ -115,7 +203,22 @@ func (s *Server) handleGetTask(w http.ResponseWriter, _ *http.Request) {
 
 	s.logger.Debug(constants.LogTaskRetrieved,
 		zap.String(constants.FieldTaskID, task.ID),
-		zap.String(constants.FieldOperation, task.Operation))
+		zap.String(constants.FieldOperation, task.Operation),
+		zap.String(constants.FieldLeaseID, task.LeaseID))
+	s.writeJSON(w, http.StatusOK, models.TaskResponse{Task: *task})
+}
+
+// handleGetTaskState сообщает агенту, выполняющему задачу, не отменена ли она: отменённая задача отвечает 410.
+func (s *Server) handleGetTaskState(w http.ResponseWriter, r *http.Request) {
+	task, err := s.storage.GetTask(mux.Vars(r)["id"])
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, constants.ErrTaskNotFound)
+		return
+	}
+	if task.Cancelled {
+		s.writeError(w, http.StatusGone, constants.ErrTaskCancelled)
+		return
+	}
 	s.writeJSON(w, http.StatusOK, models.TaskResponse{Task: *task})
 }
 
@@ -127,9 +230,14 @@ func (s *Server) handleSubmitTaskResult(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.storage.UpdateTaskResult(result.ID, result.Result); err != nil {
+	if result.Error != "" {
+		s.handleTaskFailure(w, result)
+		return
+	}
+
+	if err := s.storage.SubmitTaskResult(result.ID, result.LeaseID, result.Result); err != nil {
 		s.logger.Error(constants.LogFailedUpdateTask, zap.String(constants.FieldTaskID, result.ID), zap.Error(err))
-		s.writeError(w, http.StatusNotFound, constants.ErrTaskNotFound)
+		s.writeSubmitError(w, err)
 		return
 	}
 
@@ -140,58 +248,51 @@ func (s *Server) handleSubmitTaskResult(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	dependentTasks := s.storage.GetTasksByDependency(result.ID)
-	for _, depTask := range dependentTasks {
-		depTaskCopy := *depTask
-		allDepsMet := true
-		for _, depID := range depTask.DependsOnTaskIDs {
-			depResult, err := s.storage.GetTaskResult(depID)
-			if err != nil {
-				allDepsMet = false
-				break
-			}
-			if depTask.Arg1 == 0 {
-				depTaskCopy.Arg1 = depResult
-			} else if depTask.Arg2 == 0 {
-				depTaskCopy.Arg2 = depResult
-			}
-		}
-		if allDepsMet && depTaskCopy.Arg1 != 0 && depTaskCopy.Arg2 != 0 {
-			if err := s.storage.SaveTask(&depTaskCopy); err != nil {
-				s.logger.Error("Failed to update dependent task",
-					zap.String(constants.FieldTaskID, depTaskCopy.ID),
-					zap.String(constants.FieldExpressionID, depTaskCopy.ExpressionID),
-					zap.Error(err))
-
-				// Optionally update the parent expression with an error status
-				if updateErr := s.storage.UpdateExpressionError(task.ExpressionID,
-					"Failed to update dependent task: "+err.Error()); updateErr != nil {
-					s.logger.Error("Failed to update expression error status",
-						zap.String(constants.FieldExpressionID, task.ExpressionID),
-						zap.Error(updateErr))
-				}
-			}
-		}
-	}
+	s.finishTask(task)
 
-	allTasks := s.storage.GetTasksByExpressionID(task.ExpressionID)
-	allCompleted := true
-	for _, t := range allTasks {
-		if _, err := s.storage.GetTaskResult(t.ID); err != nil {
-			allCompleted = false
-			break
-		}
-	}
-	if allCompleted {
-		if err := s.storage.UpdateExpressionResult(task.ExpressionID, result.Result); err != nil {
-			s.logger.Error(constants.LogFailedUpdateExpr, zap.String(constants.FieldExpressionID, task.ExpressionID), zap.Error(err))
-		}
+	s.logger.Info(constants.LogTaskProcessed,
+		zap.String(constants.FieldTaskID, task.ID),
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.String(constants.FieldResult, result.Result))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTaskFailure завершает выражение с ошибкой, которую агент сообщил вместо результата задачи,
+// и отменяет остальные задачи выражения.
+func (s *Server) handleTaskFailure(w http.ResponseWriter, result models.TaskResult) {
+	if err := s.storage.FailTask(result.ID, result.LeaseID, result.Error); err != nil {
+		s.logger.Error(constants.LogFailedUpdateTask, zap.String(constants.FieldTaskID, result.ID), zap.Error(err))
+		s.writeSubmitError(w, err)
+		return
 	}
 
-	s.logger.Info(constants.LogTaskProcessed,
+	task, err := s.storage.GetTask(result.ID)
+	if err != nil {
+		s.logger.Error(constants.LogFailedGetTaskResult, zap.String(constants.FieldTaskID, result.ID), zap.Error(err))
+		s.writeError(w, http.StatusInternalServerError, constants.ErrFailedProcessResult)
+		return
+	}
+	s.logger.Warn("Agent reported task failure",
 		zap.String(constants.FieldTaskID, task.ID),
 		zap.String(constants.FieldExpressionID, task.ExpressionID),
-		zap.Float64(constants.FieldResult, result.Result))
+		zap.String("code", result.Code),
+		zap.String("error", result.Error))
+	s.failExpression(task, result.Error)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeSubmitError отвечает агенту, результат или ошибку которого хранилище не приняло.
+func (s *Server) writeSubmitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrTaskCancelled) {
+		s.writeError(w, http.StatusGone, constants.ErrTaskCancelled)
+		return
+	}
+	// Задачу с истёкшей арендой уже могли выдать другому агенту: результат отклоняется.
+	if errors.Is(err, storage.ErrLeaseExpired) {
+		s.writeError(w, http.StatusConflict, constants.ErrLeaseExpired)
+		return
+	}
+	s.writeError(w, http.StatusNotFound, constants.ErrTaskNotFound)
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"distributed_calculator/internal/constants"
+
+	"go.uber.org/zap"
+)
+
+// ExpireLeases возвращает в очередь задачи, агенты которых не прислали результат до конца аренды,
+// чтобы их получили другие агенты. Это один проход сборщика, который Start запускает в фоне.
+func (s *Server) ExpireLeases() int {
+	expired := s.storage.ExpireLeases(time.Now())
+	if expired > 0 {
+		s.logger.Warn(constants.LogLeasesExpired, zap.Int(constants.FieldCount, expired))
+	}
+	return expired
+}
+
+// runReaper с периодом ReaperIntervalMS возвращает в очередь задачи с истёкшей арендой, пока не отменён ctx.
+func (s *Server) runReaper(ctx context.Context) {
+	interval := time.Duration(s.config.ReaperIntervalMS) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ExpireLeases()
+		}
+	}
+}
@@ -1,54 +1,313 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+
+	"distributed_calculator/pkg/calculation"
 )
 
 type ExpressionStatus string
 
 const (
-	StatusPending ExpressionStatus = "PENDING"
-	StatusProgress ExpressionStatus = "IN_PROGRESS"
-	StatusComplete ExpressionStatus = "COMPLETE"
-	StatusError ExpressionStatus = "ERROR"
+	StatusPending   ExpressionStatus = "PENDING"
+	StatusProgress  ExpressionStatus = "IN_PROGRESS"
+	StatusComplete  ExpressionStatus = "COMPLETE"
+	StatusError     ExpressionStatus = "ERROR"
+	StatusCancelled ExpressionStatus = "CANCELLED" // Вычисление отменено пользователем.
 )
 
 type Expression struct {
-	ID         string           `json:"id"`
-	Expression string           `json:"expression,omitempty"`
-	Status     ExpressionStatus `json:"status"`
-	Result     *float64         `json:"result,omitempty"`
-	CreatedAt  time.Time        `json:"-"`
-	UpdatedAt  time.Time        `json:"-"`
-	Error      string           `json:"error,omitempty"`
+	ID          string             `json:"id"`
+	Expression  string             `json:"expression,omitempty"`
+	Canonical   string             `json:"canonical,omitempty"` // Каноническая запись: одна для выражений, различающихся только пробелами, скобками и записью чисел.
+	Status      ExpressionStatus   `json:"status"`
+	Variables   map[string]float64 `json:"variables,omitempty"` // Значения переменных, с которыми вычислялось выражение.
+	Mode        string             `json:"mode,omitempty"`      // Числовой режим вычисления.
+	Precision   int                `json:"precision,omitempty"` // Число знаков после запятой в режиме decimal.
+	Result      *Value             `json:"result,omitempty"`
+	ResultArray *Array             `json:"result_array,omitempty"` // Результат-массив: вектор или матрица; тогда result пуст.
+	CreatedAt   time.Time          `json:"-"`
+	UpdatedAt   time.Time          `json:"-"`
+	Error       string             `json:"error,omitempty"`
+	Eliminated  []EliminatedTask   `json:"eliminated,omitempty"` // Операции, для которых при планировании не понадобились задачи.
+	Bindings    []Binding          `json:"bindings,omitempty"`   // Именованные значения программы в порядке инструкций.
+	RootTaskID  string             `json:"-"`                    // Задача, значение которой — результат выражения.
+
+	ExactResult // Точный результат; заполняется только в режимах decimal и rational.
+}
+
+// HasResult сообщает, что результат выражения уже записан: число или массив, все элементы которого вычислены.
+func (e *Expression) HasResult() bool {
+	return e.Result != nil || e.ResultArray != nil && e.ResultArray.Resolved()
+}
+
+// Value — результат выражения: действительное число сериализуется числом,
+// комплексное — объектом {"re": …, "im": …}.
+type Value struct {
+	Re float64 `json:"re"` // Действительная часть.
+	Im float64 `json:"im"` // Мнимая часть.
+}
+
+// MarshalJSON записывает действительное значение числом, а комплексное — объектом.
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Im == 0 {
+		return json.Marshal(v.Re)
+	}
+	type complexValue Value
+	return json.Marshal(complexValue(v))
+}
+
+// UnmarshalJSON читает значение в любой из двух форм.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.Re); err == nil {
+		v.Im = 0
+		return nil
+	}
+	type complexValue Value
+	return json.Unmarshal(data, (*complexValue)(v))
+}
+
+// Array — значение-вектор или матрица. Элементы хранятся построчно, а в JSON записываются
+// вложенными списками: {"shape": [2, 2], "values": [[1, 2], [3, 4]]}.
+type Array struct {
+	Shape   []int    // Длина вектора или число строк и столбцов матрицы.
+	Values  []Value  // Элементы построчно.
+	Exact   []string // Точные значения элементов в режимах decimal и rational.
+	TaskIDs []string // Задачи, вычисляющие элементы; пусто для элементов, значение которых уже известно.
+}
+
+// Resolved сообщает, что значения всех элементов известны.
+func (a *Array) Resolved() bool {
+	for _, id := range a.TaskIDs {
+		if id != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayJSON — форма массива в JSON.
+type arrayJSON struct {
+	Shape  []int `json:"shape"`
+	Values any   `json:"values"`
+	Exact  any   `json:"exact,omitempty"`
+}
+
+// MarshalJSON записывает элементы массива вложенными списками по его форме.
+func (a Array) MarshalJSON() ([]byte, error) {
+	out := arrayJSON{Shape: a.Shape, Values: nest(a.Shape, a.Values)}
+	if len(a.Exact) > 0 {
+		out.Exact = nest(a.Shape, a.Exact)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON читает массив, записанный MarshalJSON.
+func (a *Array) UnmarshalJSON(data []byte) error {
+	var in struct {
+		Shape  []int           `json:"shape"`
+		Values json.RawMessage `json:"values"`
+		Exact  json.RawMessage `json:"exact"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	a.Shape, a.Values, a.Exact = in.Shape, nil, nil
+	if err := unnest(in.Values, len(in.Shape), &a.Values); err != nil {
+		return err
+	}
+	if len(in.Exact) > 0 {
+		return unnest(in.Exact, len(in.Shape), &a.Exact)
+	}
+	return nil
+}
+
+// nest раскладывает элементы по строкам матрицы; вектор остаётся плоским списком.
+func nest[T any](shape []int, elements []T) any {
+	if len(shape) < 2 {
+		return elements
+	}
+	rows := make([][]T, shape[0])
+	for i := range rows {
+		rows[i] = elements[i*shape[1] : (i+1)*shape[1]]
+	}
+	return rows
+}
+
+// unnest читает элементы вектора или построчно элементы матрицы.
+func unnest[T any](data json.RawMessage, dimensions int, elements *[]T) error {
+	if dimensions < 2 {
+		return json.Unmarshal(data, elements)
+	}
+	var rows [][]T
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		*elements = append(*elements, row...)
+	}
+	return nil
+}
+
+// ExactResult — точная запись результата в режимах decimal и rational.
+type ExactResult struct {
+	ResultExact   string `json:"result_exact,omitempty"`   // Точный результат, в режиме rational — несократимая дробь, например 1/2.
+	ResultMixed   string `json:"result_mixed,omitempty"`   // Смешанное число, например 1 1/2.
+	ResultDecimal string `json:"result_decimal,omitempty"` // Десятичная запись с периодом в скобках, например 0.1(6).
+}
+
+// Binding — именованное значение программы, например a в a = 2+3; a*4.
+type Binding struct {
+	Name   string `json:"name"`
+	Value  *Value `json:"value,omitempty"` // Значение; пусто, пока выражение не вычислено.
+	Exact  string `json:"exact,omitempty"` // Точное значение в режимах decimal и rational.
+	Array  *Array `json:"array,omitempty"` // Значение-массив; тогда value пусто.
+	TaskID string `json:"-"`               // Задача, вычисляющая значение; пусто, если оно известно при планировании.
+}
+
+// ParseErrorResponse — ответ на выражение, которое не удалось разобрать.
+type ParseErrorResponse struct {
+	Error ParseErrorDetails `json:"error"`
+}
+
+// ParseErrorDetails описывает ошибку разбора и её место в выражении.
+type ParseErrorDetails struct {
+	Code     string   `json:"code"`               // Код ошибки, например UNEXPECTED_TOKEN.
+	Message  string   `json:"message"`            // Текст ошибки.
+	Position int      `json:"position"`           // Смещение ошибки в байтах от начала выражения.
+	Column   int      `json:"column"`             // Номер столбца в строке выражения, начиная с 1.
+	Token    string   `json:"token,omitempty"`    // Ошибочный токен; пуст, если выражение оборвалось.
+	Expected []string `json:"expected,omitempty"` // Токены, которые допустимы в этом месте.
+	Caret    string   `json:"caret"`              // Строка выражения и указатель ^ под местом ошибки.
+}
+
+// Причины, по которым операция выражения не стала задачей для агента.
+const (
+	EliminatedFolded   = "folded"   // Операнды известны, операцию вычислил оркестратор.
+	EliminatedIdentity = "identity" // Операция упрощена по тождеству, например x*1 = x.
+	EliminatedUnused   = "unused"   // Результат операции не нужен, например множитель x в x*0.
+	EliminatedShared   = "shared"   // Такая же операция над теми же операндами уже есть, её результат используется повторно.
+)
+
+// EliminatedTask — операция выражения, которая не была отправлена агентам.
+type EliminatedTask struct {
+	Operation string `json:"operation"`        // Оператор или имя функции.
+	Position  int    `json:"position"`         // Смещение операции в байтах от начала выражения.
+	Reason    string `json:"reason"`           // Причина: folded, identity, unused или shared.
+	Result    string `json:"result,omitempty"` // Значение, вычисленное при планировании.
 }
 
+// OperationCondition — операция условной задачи. Её выполняет оркестратор, а не агент:
+// получив условие (аргумент 0), он запускает задачи выбранной ветви и отменяет задачи другой,
+// а результатом становится значение выбранной ветви (аргумент 1 или 2).
+const OperationCondition = "?"
+
 type Task struct {
 	ID               string
 	ExpressionID     string
 	Operation        string
-	Arg1             float64
-	Arg2             float64
-	Result           *float64 // nil
+	Mode             string // Числовой режим: float64 (по умолчанию), decimal или rational.
+	Precision        int    // Число знаков после запятой в режиме decimal.
+	Arg1             string // Значения передаются строками, чтобы не терять точность вне режима float64.
+	Arg2             string
+	Args             []string // Аргументы вызова функции; у операторов используются Arg1 и Arg2.
+	Result           *string  // nil
 	CreatedAt        time.Time
 	DependsOnTaskIDs []string
+	DependencySlots  []int     // Номер аргумента (0 — Arg1, 1 — Arg2, для функций — индекс в Args), который заполняет результат DependsOnTaskIDs[i].
+	ConditionID      string    // Условная задача, от исхода которой зависит запуск задачи; пусто, если задача безусловная.
+	Branch           int       // Ветвь условной задачи ConditionID: 1 — условие истинно, 2 — ложно.
+	Skipped          bool      // Задача принадлежит невыбранной ветви и не будет выполнена.
+	Cancelled        bool      // Выражение задачи завершилось ошибкой или отменено, задача не будет выполнена.
+	LeaseID          string    // Аренда, под которой задачу выполняет агент; пусто, пока задача не выдана агенту или уже вычислена.
+	LeaseDeadline    time.Time // Срок аренды: если результат не пришёл до него, задача возвращается в очередь.
+	Attempts         int       // Сколько раз задача выдавалась агентам.
+	RetryAt          time.Time // Задача, вернувшаяся в очередь после неудачной попытки, не выдаётся агентам раньше этого времени.
+	LastError        string    // Причина последней неудачной попытки.
+}
+
+// SetArg записывает значение в аргумент задачи по его номеру.
+func (t *Task) SetArg(slot int, value string) {
+	if t.Args != nil {
+		t.Args[slot] = value
+		return
+	}
+	switch slot {
+	case 0:
+		t.Arg1 = value
+	case 1:
+		t.Arg2 = value
+	}
 }
 
 type CalculateRequest struct {
-	Expression string `json:"expression"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Mode       string             `json:"mode,omitempty"`      // float64 (по умолчанию), decimal или rational.
+	Precision  int                `json:"precision,omitempty"` // Число знаков после запятой в режиме decimal.
+}
+
+// Function — пользовательская функция, заданная выражением от своих параметров.
+type Function struct {
+	Name   string   `json:"name"`   // Имя, по которому функция вызывается в выражениях.
+	Params []string `json:"params"` // Имена параметров в порядке аргументов.
+	Body   string   `json:"body"`   // Тело функции, например sqrt(a^2+b^2).
+}
+
+type FunctionResponse struct {
+	Function Function `json:"function"`
+}
+
+type FunctionsResponse struct {
+	Functions []Function `json:"functions"`
 }
 
 type CalculateResponse struct {
 	ID string `json:"id"`
 }
 
+// DeriveRequest — запрос производной выражения по переменной.
+type DeriveRequest struct {
+	Expression string             `json:"expression"`
+	Var        string             `json:"var"`          // Переменная, по которой берётся производная.
+	At         map[string]float64 `json:"at,omitempty"` // Точка, в которой производная вычисляется агентами.
+}
+
+// DeriveResponse содержит упрощённую производную и, если задана точка, её вычисление в этой точке.
+type DeriveResponse struct {
+	Derivative string            `json:"derivative"`      // Производная в виде выражения.
+	AST        *calculation.Tree `json:"ast"`             // Синтаксическое дерево производной.
+	ID         string            `json:"id,omitempty"`    // Выражение, которое вычисляет производную в точке at.
+	Value      *Value            `json:"value,omitempty"` // Значение в точке, если в производной нет операций для агентов.
+}
+
 type TaskResult struct {
-	ID     string  `json:"id"`
-	Result float64 `json:"result"`
+	ID      string `json:"id"`
+	Result  string `json:"result"`
+	LeaseID string `json:"lease_id"`        // Аренда, под которой агент получил задачу.
+	Error   string `json:"error,omitempty"` // Ошибка вычисления; тогда result пуст, а выражение задачи завершается с этой ошибкой.
+	Code    string `json:"code,omitempty"`  // Код ошибки, например DIVISION_BY_ZERO.
 }
 
 type ExpressionResponse struct {
 	Expression Expression `json:"expression"`
+	Formatted  string     `json:"formatted,omitempty"` // Выражение в записи, запрошенной параметром format.
+}
+
+// DeadLetter — задача, исчерпавшая попытки выполнения. Её выражение завершено с ошибкой,
+// пока оператор не вернёт задачу в очередь.
+type DeadLetter struct {
+	TaskID       string    `json:"task_id"`
+	ExpressionID string    `json:"expression_id"`
+	Operation    string    `json:"operation"`
+	Attempts     int       `json:"attempts"`  // Сколько раз задача выдавалась агентам.
+	Reason       string    `json:"reason"`    // Причина последней неудачной попытки.
+	FailedAt     time.Time `json:"failed_at"` // Когда задача попала в список.
+}
+
+type DeadLetterResponse struct {
+	Tasks []DeadLetter `json:"tasks"`
 }
 
 type ExpressionsResponse struct {
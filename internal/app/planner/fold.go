@@ -0,0 +1,186 @@
+package planner
+
+import (
+	"fmt"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/pkg/calculation"
+)
+
+// Folding selects the operations that the orchestrator computes itself while planning
+// instead of sending them to agents.
+type Folding string
+
+const (
+	FoldNone  Folding = "none"  // Every operation becomes a task; the zero value means the same.
+	FoldCheap Folding = "cheap" // Operations in cheapOps are folded; division, modulo, powers and calls go to agents.
+	FoldAll   Folding = "all"   // Every operation with known operands is folded.
+)
+
+// cheapOps lists the operations folded in FoldCheap mode.
+var cheapOps = map[string]bool{
+	"+": true, "-": true, "*": true,
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "and": true, "or": true,
+	"&": true, "|": true, "xor": true, "<<": true, ">>": true,
+}
+
+// ParseFolding converts a folding mode name into a Folding; an empty name means FoldNone.
+func ParseFolding(name string) (Folding, error) {
+	switch Folding(name) {
+	case "", FoldNone:
+		return FoldNone, nil
+	case FoldCheap, FoldAll:
+		return Folding(name), nil
+	default:
+		return "", fmt.Errorf("invalid folding mode '%s': expected none, cheap or all", name)
+	}
+}
+
+// folds reports whether an operation with known operands is computed while planning.
+func (p *planner) folds(op string) bool {
+	switch p.opts.Folding {
+	case FoldAll:
+		return true
+	case FoldCheap:
+		return cheapOps[op]
+	default:
+		return false
+	}
+}
+
+// fold computes an operation whose operands are all known if the folding mode allows it.
+// An operation that fails, e.g. division by zero, is left to agents: it may belong to a branch that is never taken.
+func (p *planner) fold(op string, position int, args []operand) (operand, bool) {
+	if !p.folds(op) {
+		return operand{}, false
+	}
+	values := make([]calculation.Number, len(args))
+	for i, arg := range args {
+		if arg.taskID != "" {
+			return operand{}, false
+		}
+		values[i] = arg.value
+	}
+
+	var (
+		value calculation.Number
+		err   error
+	)
+	if IsOperator(op) {
+		value, err = p.opts.Arithmetic.Apply(op, values[0], values[1])
+	} else {
+		value, err = p.opts.Arithmetic.Call(op, values)
+	}
+	if err != nil {
+		return operand{}, false
+	}
+	p.eliminate(op, position, models.EliminatedFolded, value)
+	return operand{value: value}, true
+}
+
+// simplify applies algebraic identities to a binary operation with one known operand:
+// x+0, 0+x, x-0, x*1, 1*x, x/1 and x^1 become x, and x*0 and 0*x become 0 if computing x
+// can neither fail nor overflow, so dropping it does not hide an error.
+func (p *planner) simplify(op string, position int, left, right operand) (operand, bool) {
+	if p.opts.Folding == "" || p.opts.Folding == FoldNone || (left.taskID == "") == (right.taskID == "") {
+		return operand{}, false
+	}
+	task, constant := left, right
+	if left.taskID == "" {
+		task, constant = right, left
+	}
+	swapped := left.taskID == ""
+
+	switch {
+	case p.equals(constant.value, 0) && (op == "+" || op == "-" && !swapped):
+	case p.equals(constant.value, 1) && (op == "*" || (op == "/" || op == "^") && !swapped):
+	case p.equals(constant.value, 0) && op == "*" && task.total:
+		p.eliminate(op, position, models.EliminatedIdentity, constant.value)
+		return constant, true
+	default:
+		return operand{}, false
+	}
+	p.eliminate(op, position, models.EliminatedIdentity, nil)
+	return task, true
+}
+
+// equals reports whether a known value is equal to a small integer.
+func (p *planner) equals(value calculation.Number, target float64) bool {
+	constant, err := p.opts.Arithmetic.FromFloat(target)
+	if err != nil {
+		return false
+	}
+	equal, err := p.opts.Arithmetic.Apply("==", value, constant)
+	return err == nil && p.opts.Arithmetic.IsTrue(equal)
+}
+
+// isTotal reports whether a task can neither fail nor overflow, given its operation and operands.
+// Equality, logic and conditionals of total operands always succeed; ordering fails only for complex numbers,
+// which exist only in float64 mode; addition, subtraction, multiplication, abs, min and max are total
+// only in decimal and rational modes, where values are unbounded.
+func (p *planner) isTotal(op string, args []operand) bool {
+	for _, arg := range args {
+		if arg.taskID != "" && !arg.total {
+			return false
+		}
+	}
+	mode := p.opts.Arithmetic.Mode
+	switch op {
+	case "==", "!=", "and", "or", models.OperationCondition:
+		return true
+	case "<", "<=", ">", ">=":
+		return p.opts.Arithmetic.IsExact()
+	case "+", "-", "*", "abs", "min", "max":
+		return mode == calculation.ModeDecimal || mode == calculation.ModeRational
+	default:
+		return false
+	}
+}
+
+// eliminate records an operation that did not become a task.
+func (p *planner) eliminate(op string, position int, reason string, value calculation.Number) {
+	record := models.EliminatedTask{Operation: op, Position: position, Reason: reason}
+	if value != nil {
+		record.Result = value.String()
+	}
+	p.eliminated = append(p.eliminated, record)
+}
+
+// prune removes the tasks whose results reach neither the result of the expression, nor an element
+// of an array result, nor a binding of the program, e.g. the tasks of x in x*0, and records them as unused.
+func (p *planner) prune(result operand) {
+	reachable := make(map[string]bool)
+	for _, root := range result.flatten() {
+		if root.taskID != "" {
+			reachable[root.taskID] = true
+		}
+	}
+	for _, binding := range p.bindings {
+		if binding.TaskID != "" {
+			reachable[binding.TaskID] = true
+		}
+		for _, element := range binding.Elements {
+			if element.TaskID != "" {
+				reachable[element.TaskID] = true
+			}
+		}
+	}
+	// Задачи идут в порядке зависимостей, поэтому достаточно одного прохода от конца.
+	for i := len(p.tasks) - 1; i >= 0; i-- {
+		if reachable[p.tasks[i].ID] {
+			for _, dep := range p.tasks[i].DependsOnTaskIDs {
+				reachable[dep] = true
+			}
+		}
+	}
+
+	kept := p.tasks[:0]
+	for _, task := range p.tasks {
+		if reachable[task.ID] {
+			kept = append(kept, task)
+			continue
+		}
+		p.eliminate(task.Operation, p.positions[task.ID], models.EliminatedUnused, nil)
+	}
+	p.tasks = kept
+}
@@ -0,0 +1,629 @@
+// Package planner compiles parsed expressions into a graph of tasks for agents.
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
+
+	"github.com/google/uuid"
+)
+
+// operand is an argument of a task: either a value known at planning time
+// or a reference to the task that will produce it. A vector or a matrix is an operand
+// whose scalar elements are operands themselves, so every element becomes a task of its own.
+type operand struct {
+	value  calculation.Number
+	taskID string
+	total  bool      // The task can neither fail nor overflow, so its result may be dropped, e.g. in x*0.
+	shape  []int     // Shape of an array; nil for a scalar.
+	elems  []operand // Elements of an array row by row.
+}
+
+// maxTasks limits the number of tasks of a single expression; calls of user-defined functions
+// are expanded at every call site, so nested calls can multiply the size of the plan.
+const maxTasks = 10000
+
+// Options configures how an expression is compiled into tasks.
+type Options struct {
+	Variables  map[string]float64     // Values of the variables referenced by the expression.
+	Arithmetic calculation.Arithmetic // Number system of the tasks; the zero value is float64.
+	Functions  calculation.Functions  // User-defined functions the expression may call.
+	Folding    Folding                // Operations computed while planning; the zero value folds nothing.
+}
+
+// Result is an expression compiled for agents.
+type Result struct {
+	Tasks      []*models.Task          // Tasks in dependency order; empty if the value was computed while planning.
+	Value      calculation.Number      // Value of the expression if it was computed while planning, otherwise nil.
+	RootID     string                  // Task that produces the value of the expression; empty if Value is set.
+	Shape      []int                   // Shape of an array result; nil for a scalar.
+	Elements   []Element               // Elements of an array result row by row; Value and RootID are empty then.
+	Bindings   []Binding               // Named values of a program in the order of the statements.
+	Eliminated []models.EliminatedTask // Operations that were folded, simplified or dropped instead of becoming tasks.
+}
+
+// Binding is a named value of a program: either computed while planning or produced by a task.
+type Binding struct {
+	Name     string             // Bound name.
+	Value    calculation.Number // Value computed while planning, otherwise nil.
+	TaskID   string             // Task that produces the value; empty if Value is set.
+	Shape    []int              // Shape of an array value; nil for a scalar.
+	Elements []Element          // Elements of an array value row by row.
+}
+
+// Element is a scalar element of an array value: either computed while planning or produced by a task.
+type Element struct {
+	Value  calculation.Number // Value computed while planning, otherwise nil.
+	TaskID string             // Task that produces the element; empty if Value is set.
+}
+
+// unaryOp describes how agents execute a prefix operator: as a binary operation with a constant left operand.
+type unaryOp struct {
+	op       string
+	constant float64
+}
+
+// unaryOps maps a prefix operator to the binary operation that applies it.
+var unaryOps = map[string]unaryOp{
+	"-":   {op: "*", constant: -1},
+	"~":   {op: "xor", constant: -1},
+	"not": {op: "==", constant: 0},
+}
+
+// guard identifies the branch of a conditional task that the tasks being planned belong to.
+type guard struct {
+	conditionID string
+	branch      int
+}
+
+// planner accumulates the tasks of a single expression.
+type planner struct {
+	exprID     string
+	opts       Options
+	tasks      []*models.Task
+	guard      guard              // Branch of the innermost enclosing conditional; empty outside conditionals.
+	scope      map[string]operand // Arguments of the user-defined function being expanded; nil at the top level.
+	positions  map[string]int     // Source offset of the operation of each task.
+	eliminated []models.EliminatedTask
+	enclosing  []guard                      // Guards of the conditionals enclosing the current branch, outermost first.
+	shared     map[guard]map[string]operand // Tasks by their computation, for every branch; see addTask.
+	bound      map[string]operand           // Values of the names bound so far by a program.
+	bindings   []Binding
+}
+
+// commutativeOps lists the binary operations whose operands may be swapped without changing the result,
+// so a+b and b+a share a task.
+var commutativeOps = map[string]bool{
+	"+": true, "*": true, "==": true, "!=": true, "and": true, "or": true, "&": true, "|": true, "xor": true,
+}
+
+// Plan compiles the syntax tree into tasks.
+// Variables are substituted with their bound values at planning time.
+// Tasks are returned in dependency order: every task follows the tasks it depends on,
+// so the last task produces the result of the whole expression; for a program see Result.RootID.
+func Plan(exprID string, root calculation.Node, opts Options) ([]*models.Task, error) {
+	result, err := Compile(exprID, root, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Tasks) == 0 {
+		return nil, errors.New(constants.ErrTooFewTokens)
+	}
+	return result.Tasks, nil
+}
+
+// Compile compiles the syntax tree like Plan, folding operations as selected by opts.Folding.
+// If every operation is folded, the result carries the value of the expression and no tasks.
+func Compile(exprID string, root calculation.Node, opts Options) (*Result, error) {
+	if err := opts.Arithmetic.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := ParseFolding(string(opts.Folding)); err != nil {
+		return nil, err
+	}
+	p := &planner{exprID: exprID, opts: opts, positions: make(map[string]int), shared: make(map[guard]map[string]operand)}
+
+	result, err := p.compile(root)
+	if err != nil {
+		return nil, err
+	}
+	p.prune(result)
+
+	return &Result{Tasks: p.tasks, Value: result.value, RootID: result.taskID, Shape: result.shape,
+		Elements: elements(result), Bindings: p.bindings, Eliminated: p.eliminated}, nil
+}
+
+// elements converts the elements of an array operand; a scalar has none.
+func elements(array operand) []Element {
+	if array.shape == nil {
+		return nil
+	}
+	result := make([]Element, len(array.elems))
+	for i, elem := range array.elems {
+		result[i] = Element{Value: elem.value, TaskID: elem.taskID}
+	}
+	return result
+}
+
+// compile walks the tree in post-order, so operands are planned before the operations that use them.
+// Precedence and associativity are already encoded in the shape of the tree.
+func (p *planner) compile(node calculation.Node) (operand, error) {
+	switch n := node.(type) {
+	case *calculation.NumberNode:
+		value, err := p.opts.Arithmetic.Evaluate(n, nil)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{value: value}, nil
+	case *calculation.IdentNode:
+		if p.scope != nil {
+			arg, ok := p.scope[n.Name]
+			if !ok {
+				return p.constant(n.Name)
+			}
+			return arg, nil
+		}
+		if bound, ok := p.bound[n.Name]; ok {
+			return bound, nil
+		}
+		value, ok := p.opts.Variables[n.Name]
+		if !ok {
+			return p.constant(n.Name)
+		}
+		number, err := p.opts.Arithmetic.FromFloat(value)
+		if err != nil {
+			return operand{}, fmt.Errorf("variable '%s': %w", n.Name, err)
+		}
+		return operand{value: number}, nil
+	case *calculation.GroupNode:
+		return p.compile(n.Inner)
+	case *calculation.UnaryNode:
+		if _, ok := unaryOps[n.Op]; !ok {
+			return operand{}, fmt.Errorf("%s '%s'", constants.ErrUnsupportedOperation, n.Op)
+		}
+		if err := p.opts.Arithmetic.CheckOperation(n.Op); err != nil {
+			return operand{}, err
+		}
+		arg, err := p.compile(n.Operand)
+		if err != nil {
+			return operand{}, err
+		}
+		return p.elementwise([]operand{arg}, func(xs []operand) (operand, error) {
+			return p.unary(n, xs[0])
+		})
+	case *calculation.BinaryNode:
+		if !IsOperator(n.Op) {
+			return operand{}, fmt.Errorf("%s '%s'", constants.ErrUnsupportedOperation, n.Op)
+		}
+		if err := p.opts.Arithmetic.CheckOperation(n.Op); err != nil {
+			return operand{}, err
+		}
+		left, err := p.compile(n.Left)
+		if err != nil {
+			return operand{}, err
+		}
+		right, err := p.compile(n.Right)
+		if err != nil {
+			return operand{}, err
+		}
+		return p.elementwise([]operand{left, right}, func(xs []operand) (operand, error) {
+			return p.binary(n, xs[0], xs[1]), nil
+		})
+	case *calculation.CallNode:
+		fn, builtin := calculation.LookupFunction(n.Name)
+		userFn, user := p.opts.Functions[n.Name]
+		if !builtin && !user {
+			return operand{}, fmt.Errorf("%s '%s'", constants.ErrUnknownFunction, n.Name)
+		}
+		args := make([]operand, len(n.Args))
+		for i, argNode := range n.Args {
+			arg, err := p.compile(argNode)
+			if err != nil {
+				return operand{}, err
+			}
+			args[i] = arg
+		}
+		if !builtin {
+			return p.expandCall(userFn, args)
+		}
+		if calculation.IsArrayFunction(fn.Name) {
+			return p.compileArrayCall(n.Pos(), fn.Name, args)
+		}
+		return p.elementwise(args, func(xs []operand) (operand, error) {
+			return p.call(n.Pos(), fn.Name, xs), nil
+		})
+	case *calculation.ListNode:
+		items := make([]operand, len(n.Elements))
+		shapes := make([][]int, len(n.Elements))
+		var elems []operand
+		for i, element := range n.Elements {
+			item, err := p.compile(element)
+			if err != nil {
+				return operand{}, err
+			}
+			items[i], shapes[i] = item, item.shape
+			elems = append(elems, item.flatten()...)
+		}
+		shape, err := calculation.ListShape(shapes)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{shape: shape, elems: elems}, nil
+	case *calculation.ConditionalNode:
+		cond, err := p.compile(n.Cond)
+		if err != nil {
+			return operand{}, err
+		}
+		if cond.shape != nil {
+			return operand{}, fmt.Errorf("%s: condition", constants.ErrScalarRequired)
+		}
+		if cond.taskID == "" {
+			// Условие известно при планировании: задачи создаются только для выбранной ветви.
+			if p.opts.Arithmetic.IsTrue(cond.value) {
+				return p.compile(n.Then)
+			}
+			return p.compile(n.Else)
+		}
+		return p.addConditional(n.Pos(), cond, n.Then, n.Else)
+	case *calculation.ProgramNode:
+		return p.compileProgram(n)
+	default:
+		return operand{}, fmt.Errorf("unsupported node: %T", node)
+	}
+}
+
+// constant resolves a name that is neither a parameter, a binding nor a variable as a built-in constant.
+func (p *planner) constant(name string) (operand, error) {
+	if !calculation.IsConstant(name) {
+		return operand{}, fmt.Errorf("%s '%s'", constants.ErrUnknownVariable, name)
+	}
+	number, err := p.opts.Arithmetic.Constant(name)
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{value: number}, nil
+}
+
+// compileProgram plans the bindings of a program in order and then its result, all into one graph:
+// a reference to a bound name becomes a dependency on the task of its value, so every binding
+// is computed once however many statements use it.
+func (p *planner) compileProgram(program *calculation.ProgramNode) (operand, error) {
+	p.bound = make(map[string]operand, len(program.Bindings))
+	for _, binding := range program.Bindings {
+		value, err := p.compile(binding.Value)
+		if err != nil {
+			return operand{}, fmt.Errorf("%s: %w", binding.Name, err)
+		}
+		p.bound[binding.Name] = value
+		p.bindings = append(p.bindings, Binding{Name: binding.Name, Value: value.value, TaskID: value.taskID,
+			Shape: value.shape, Elements: elements(value)})
+	}
+	return p.compile(program.Result)
+}
+
+// unary plans a prefix operator applied to a scalar.
+func (p *planner) unary(n *calculation.UnaryNode, arg operand) (operand, error) {
+	if arg.taskID == "" {
+		value, err := p.opts.Arithmetic.Unary(n.Op, arg.value)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{value: value}, nil
+	}
+	// Унарный оператор над подвыражением выполняется агентом как бинарная операция с константой:
+	// минус — умножение на -1, побитовое отрицание — исключающее «или» с -1, not — сравнение с 0.
+	unary := unaryOps[n.Op]
+	constant, err := p.opts.Arithmetic.FromFloat(unary.constant)
+	if err != nil {
+		return operand{}, err
+	}
+	return p.addTask(n.Pos(), unary.op, operand{value: constant}, arg), nil
+}
+
+// binary plans a binary operation over two scalars.
+func (p *planner) binary(n *calculation.BinaryNode, left, right operand) operand {
+	if folded, ok := p.fold(n.Op, n.Pos(), []operand{left, right}); ok {
+		return folded
+	}
+	if simplified, ok := p.simplify(n.Op, n.Pos(), left, right); ok {
+		return simplified
+	}
+	return p.addTask(n.Pos(), n.Op, left, right)
+}
+
+// call plans a call of a built-in function with scalar arguments.
+func (p *planner) call(position int, name string, args []operand) operand {
+	// Без свёртки каждый вызов функции — отдельная задача, даже если все аргументы известны.
+	if folded, ok := p.fold(name, position, args); ok {
+		return folded
+	}
+	return p.addCall(position, name, args)
+}
+
+// elementwise plans a scalar operation for every element of the operands broadcast to a common shape;
+// with scalar operands it is planned once.
+func (p *planner) elementwise(args []operand, plan func([]operand) (operand, error)) (operand, error) {
+	var shape []int
+	for _, arg := range args {
+		var err error
+		if shape, err = calculation.BroadcastShape(shape, arg.shape); err != nil {
+			return operand{}, err
+		}
+	}
+	if err := p.reserve(calculation.Size(shape)); err != nil {
+		return operand{}, err
+	}
+
+	elems := make([]operand, calculation.Size(shape))
+	for i := range elems {
+		xs := make([]operand, len(args))
+		for j, arg := range args {
+			xs[j] = arg.at(shape, i)
+		}
+		elem, err := plan(xs)
+		if err != nil {
+			return operand{}, err
+		}
+		elems[i] = elem
+	}
+	return newArray(shape, elems), nil
+}
+
+// compileArrayCall plans a function that combines whole arrays. Agents compute sum, mean, dot and det
+// as single tasks over the flattened elements; a matrix product becomes a dot task for every cell,
+// so the cells are computed by different agents in parallel; transpose only rearranges the elements.
+func (p *planner) compileArrayCall(position int, name string, args []operand) (operand, error) {
+	switch name {
+	case "sum", "mean":
+		var elems []operand
+		for _, arg := range args {
+			elems = append(elems, arg.flatten()...)
+		}
+		return p.call(position, name, elems), nil
+	case "dot":
+		if len(args) != 2 {
+			return operand{}, fmt.Errorf("%s: dot expects two vectors", constants.ErrArrayRequired)
+		}
+		if err := calculation.DotOperands(args[0].shape, args[1].shape); err != nil {
+			return operand{}, err
+		}
+		return p.call(position, name, append(args[0].flatten(), args[1].flatten()...)), nil
+	case "det":
+		if len(args) != 1 {
+			return operand{}, fmt.Errorf("%s: det expects one matrix", constants.ErrArrayRequired)
+		}
+		if _, err := calculation.SquareSize(args[0].shape); err != nil {
+			return operand{}, err
+		}
+		return p.call(position, name, args[0].elems), nil
+	case "matmul":
+		shape, left, right, err := calculation.MatmulOperands(args[0].shape, args[1].shape)
+		if err != nil {
+			return operand{}, err
+		}
+		if err := p.reserve(len(left)); err != nil {
+			return operand{}, err
+		}
+		cells := make([]operand, len(left))
+		for i := range cells {
+			xs := make([]operand, 0, 2*len(left[i]))
+			for _, index := range left[i] {
+				xs = append(xs, args[0].elems[index])
+			}
+			for _, index := range right[i] {
+				xs = append(xs, args[1].elems[index])
+			}
+			cells[i] = p.call(position, "dot", xs)
+		}
+		return newArray(shape, cells), nil
+	case "transpose":
+		shape, indices, err := calculation.TransposeIndices(args[0].shape)
+		if err != nil {
+			return operand{}, err
+		}
+		elems := make([]operand, len(indices))
+		for i, index := range indices {
+			elems[i] = args[0].elems[index]
+		}
+		return operand{shape: shape, elems: elems}, nil
+	default:
+		return operand{}, fmt.Errorf("%s '%s'", constants.ErrUnknownFunction, name)
+	}
+}
+
+// reserve checks that count more tasks keep the plan within maxTasks.
+func (p *planner) reserve(count int) error {
+	if len(p.tasks)+count > maxTasks {
+		return errors.New(constants.ErrTooManyTasks)
+	}
+	return nil
+}
+
+// newArray returns an array operand of the given shape, or the only element if the shape is that of a scalar.
+func newArray(shape []int, elems []operand) operand {
+	if shape == nil {
+		return elems[0]
+	}
+	return operand{shape: shape, elems: elems}
+}
+
+// at returns the element of the operand used at the index of a broadcast result of the given shape.
+func (o operand) at(shape []int, index int) operand {
+	if o.shape == nil {
+		return o
+	}
+	return o.elems[calculation.BroadcastIndex(shape, o.shape, index)]
+}
+
+// flatten returns the elements of an array, or the scalar itself as the only element.
+func (o operand) flatten() []operand {
+	if o.shape == nil {
+		return []operand{o}
+	}
+	return o.elems
+}
+
+// expandCall plans the body of a user-defined function in place of its call.
+// Each argument is computed once, however many times the body references its parameter.
+func (p *planner) expandCall(fn *calculation.UserFunction, args []operand) (operand, error) {
+	if len(args) != len(fn.Params) {
+		return operand{}, fmt.Errorf("%s: %s expects %d, got %d",
+			constants.ErrWrongArgumentCount, fn.Name, len(fn.Params), len(args))
+	}
+	if len(p.tasks) >= maxTasks {
+		return operand{}, errors.New(constants.ErrTooManyTasks)
+	}
+
+	outer := p.scope
+	p.scope = make(map[string]operand, len(args))
+	for i, param := range fn.Params {
+		p.scope[param] = args[i]
+	}
+	result, err := p.compile(fn.Body)
+	p.scope = outer
+	return result, err
+}
+
+// addTask creates a task for a binary operation and returns a reference to its result.
+// Structurally equal subexpressions share one task: if the same operation over the same operands
+// has already been planned, its result fans out to one more dependent instead.
+func (p *planner) addTask(position int, op string, args ...operand) operand {
+	key := computationKey(op, args)
+	if result, ok := p.lookup(key); ok {
+		p.eliminate(op, position, models.EliminatedShared, nil)
+		return result
+	}
+	task := p.newTask(position, op)
+	p.bind(task, args)
+	return p.remember(key, operand{taskID: task.ID, total: p.isTotal(op, args)})
+}
+
+// addCall creates a task for a function call and returns a reference to its result; equal calls share a task.
+func (p *planner) addCall(position int, name string, args []operand) operand {
+	key := computationKey(name, args)
+	if result, ok := p.lookup(key); ok {
+		p.eliminate(name, position, models.EliminatedShared, nil)
+		return result
+	}
+	task := p.newTask(position, name)
+	task.Args = make([]string, len(args))
+	p.bind(task, args)
+	return p.remember(key, operand{taskID: task.ID, total: p.isTotal(name, args)})
+}
+
+// lookup finds a planned task with the given computation key. Inside a branch of a conditional
+// only tasks of the same branch and of the enclosing ones qualify: they run whenever the branch runs.
+func (p *planner) lookup(key string) (operand, bool) {
+	if result, ok := p.shared[p.guard][key]; ok {
+		return result, true
+	}
+	for i := len(p.enclosing) - 1; i >= 0; i-- {
+		if result, ok := p.shared[p.enclosing[i]][key]; ok {
+			return result, true
+		}
+	}
+	return operand{}, false
+}
+
+// remember registers the result of a task under its computation key in the current branch.
+func (p *planner) remember(key string, result operand) operand {
+	if p.shared[p.guard] == nil {
+		p.shared[p.guard] = make(map[string]operand)
+	}
+	p.shared[p.guard][key] = result
+	return result
+}
+
+// computationKey describes an operation over its operands: known values by their text, others by task ID.
+func computationKey(op string, args []operand) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if arg.taskID != "" {
+			parts[i] = "task:" + arg.taskID
+		} else {
+			parts[i] = "value:" + arg.value.String()
+		}
+	}
+	if commutativeOps[op] {
+		slices.Sort(parts)
+	}
+	return op + "(" + strings.Join(parts, ",") + ")"
+}
+
+// addConditional creates a conditional task whose branches are planned lazily:
+// the tasks of each branch are bound to the conditional and started only if the condition selects that branch.
+// The conditional task follows the tasks of both branches, keeping the plan in dependency order.
+func (p *planner) addConditional(position int, cond operand, branches ...calculation.Node) (operand, error) {
+	id := uuid.New().String()
+	outer := p.guard
+	p.enclosing = append(p.enclosing, outer)
+
+	args := []operand{cond}
+	for i, branch := range branches {
+		p.guard = guard{conditionID: id, branch: i + 1}
+		arg, err := p.compile(branch)
+		if err != nil {
+			return operand{}, err
+		}
+		if arg.shape != nil {
+			// Условная задача выбирает одно значение, поэтому ветви, вычисляемые агентами, должны быть скалярами.
+			return operand{}, fmt.Errorf("%s: branch of a conditional", constants.ErrScalarRequired)
+		}
+		args = append(args, arg)
+	}
+	p.guard = outer
+	p.enclosing = p.enclosing[:len(p.enclosing)-1]
+
+	task := p.newTask(position, models.OperationCondition)
+	delete(p.positions, task.ID)
+	task.ID = id
+	p.positions[id] = position
+	task.Args = make([]string, len(args))
+	p.bind(task, args)
+	return operand{taskID: task.ID, total: p.isTotal(models.OperationCondition, args)}, nil
+}
+
+// newTask registers an empty task of the expression for the operation at the given source offset.
+func (p *planner) newTask(position int, op string) *models.Task {
+	task := &models.Task{
+		ID:           uuid.New().String(),
+		ExpressionID: p.exprID,
+		Operation:    op,
+		Mode:         string(p.opts.Arithmetic.Mode),
+		Precision:    p.opts.Arithmetic.Precision,
+		ConditionID:  p.guard.conditionID,
+		Branch:       p.guard.branch,
+	}
+	p.tasks = append(p.tasks, task)
+	p.positions[task.ID] = position
+	return task
+}
+
+// bind fills the arguments known at planning time and records dependencies for the rest.
+func (p *planner) bind(task *models.Task, args []operand) {
+	for slot, arg := range args {
+		if arg.taskID != "" {
+			task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, arg.taskID)
+			task.DependencySlots = append(task.DependencySlots, slot)
+			continue
+		}
+		task.SetArg(slot, arg.value.String())
+	}
+}
+
+// IsOperator reports whether agents can execute the binary operation.
+func IsOperator(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "//", "^", "%", "&", "|", "xor", "<<", ">>",
+		"==", "!=", "<", "<=", ">", ">=", "and", "or":
+		return true
+	default:
+		return false
+	}
+}
@@ -1,16 +1,25 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+
 	"distributed_calculator/internal/app/models"
-	"github.com/google/uuid"
+	"distributed_calculator/internal/app/planner"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
+
 	"go.uber.org/zap"
-	"strconv"
-	"strings"
 )
 
 func (s *Server) processExpression(expr *models.Expression) error {
-	tokens, err := s.parseExpression(expr.Expression)
+	functions, err := s.userFunctions()
+	if err != nil {
+		return err
+	}
+
+	root, err := s.parseExpression(expr.Expression, expr.Variables, expressionArithmetic(expr), functions)
 	if err != nil {
 		s.logger.Error("Failed to parse expression",
 			zap.String("expression", expr.Expression),
@@ -21,21 +30,61 @@ func (s *Server) processExpression(expr *models.Expression) error {
 		return err
 	}
 
-	if err := s.storage.UpdateExpressionStatus(expr.ID, models.StatusProgress); err != nil {
-		s.logger.Error("Failed to update expression status", zap.Error(err))
+	plan, err := s.createTasks(expr, root, functions)
+	if err != nil {
+		s.logger.Error("Failed to create tasks", zap.Error(err))
+		if updateErr := s.storage.UpdateExpressionError(expr.ID, err.Error()); updateErr != nil {
+			s.logger.Error("Failed to update expression error status", zap.Error(updateErr))
+		}
 		return err
 	}
-
-	tasks, err := s.createTasks(expr.ID, tokens)
+	resultArray, err := planArray(plan.Shape, plan.Elements, expressionArithmetic(expr))
+	var bindings []models.Binding
+	if err == nil {
+		bindings, err = planBindings(plan, expressionArithmetic(expr))
+	}
 	if err != nil {
-		s.logger.Error("Failed to create tasks", zap.Error(err))
+		s.logger.Error("Failed to convert planned values", zap.Error(err))
 		if updateErr := s.storage.UpdateExpressionError(expr.ID, err.Error()); updateErr != nil {
 			s.logger.Error("Failed to update expression error status", zap.Error(updateErr))
 		}
 		return err
 	}
+	if err := s.storage.UpdateExpressionPlan(expr.ID, plan.RootID, resultArray, bindings, plan.Eliminated); err != nil {
+		s.logger.Error("Failed to update expression plan", zap.Error(err))
+		return err
+	}
+
+	if err := s.storage.UpdateExpressionStatus(expr.ID, models.StatusProgress); err != nil {
+		s.logger.Error("Failed to update expression status", zap.Error(err))
+		return err
+	}
+
+	// Выражение, свёрнутое целиком, завершается сразу, без агентов.
+	if plan.Value != nil {
+		return s.completeExpression(expr.ID, expressionArithmetic(expr), plan.Value.String())
+	}
+	if resultArray != nil && len(plan.Tasks) == 0 {
+		return s.completeArray(expr.ID, expressionArithmetic(expr))
+	}
+	tasks := plan.Tasks
 
+	// Условные задачи и задачи их ветвей сохраняются первыми и без постановки в очередь:
+	// они должны быть на месте к моменту, когда агент вычислит условие.
 	for _, task := range tasks {
+		if !waitsForCondition(task) {
+			continue
+		}
+		if err := s.storage.SaveHeldTask(task); err != nil {
+			s.logger.Error("Failed to save task", zap.Error(err))
+			return err
+		}
+	}
+	// Остальные задачи попадают в очередь, только когда вычислены все их зависимости.
+	for _, task := range tasks {
+		if waitsForCondition(task) {
+			continue
+		}
 		if err := s.storage.SaveTask(task); err != nil {
 			s.logger.Error("Failed to save task", zap.Error(err))
 			return err
@@ -44,176 +93,353 @@ func (s *Server) processExpression(expr *models.Expression) error {
 	return nil
 }
 
-func (s *Server) parseExpression(expression string) ([]string, error) {
+// waitsForCondition reports whether the task is started by the orchestrator only after a condition is known:
+// conditional tasks themselves and the tasks of their branches.
+func waitsForCondition(task *models.Task) bool {
+	return task.Operation == models.OperationCondition || task.ConditionID != ""
+}
+
+func (s *Server) parseExpression(expression string, variables map[string]float64, arith calculation.Arithmetic,
+	functions calculation.Functions) (calculation.Node, error) {
 	if len(expression) == 0 {
 		return nil, fmt.Errorf("invalid request body")
 	}
 
-	var (
-		tokens     []string
-		parenStack int
-	)
+	tokens, err := calculation.Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < len(expression); i++ {
-		c := expression[i]
-		if c == ' ' {
-			continue
+	operators := 0
+	for _, token := range tokens {
+		if token.Kind == calculation.TokenOperator {
+			operators++
 		}
+	}
 
-		if c == '(' {
-			tokens = append(tokens, "(")
-			parenStack++
-			continue
+	root, err := calculation.ParseWithFunctions(expression, functions)
+	if err != nil {
+		// Одиночный операнд или оператор — это нехватка токенов, а не ошибка структуры.
+		var parseErr *calculation.ParseError
+		structural := err.Error() == constants.ErrInvalidStructure || err.Error() == constants.ErrTrailingOperator
+		if structural && (operators == 0 || len(tokens) <= 2) && errors.As(err, &parseErr) {
+			tooFew := *parseErr
+			tooFew.Code, tooFew.Message = constants.CodeTooFewTokens, constants.ErrTooFewTokens
+			return nil, &tooFew
 		}
-		if c == ')' {
-			if parenStack == 0 {
-				return nil, fmt.Errorf("invalid expression: unmatched parentheses")
-			}
-			tokens = append(tokens, ")")
-			parenStack--
-			continue
+		return nil, err
+	}
+
+	// Выражение без операций нечего распределять между агентами.
+	if !hasOperations(root) {
+		return nil, &calculation.ParseError{
+			Code:       constants.CodeTooFewTokens,
+			Message:    constants.ErrTooFewTokens,
+			Expression: expression,
+			Position:   len(expression),
+			Expected:   []string{"operator"},
 		}
-		if isDigit(c) || c == '.' {
-			j := i
-			for j < len(expression) && (isDigit(expression[j]) || expression[j] == '.') {
-				j++
-			}
-			tokens = append(tokens, expression[i:j])
-			i = j - 1
-			continue
+	}
+
+	if err := arith.CheckLiterals(expression, root); err != nil {
+		return nil, err
+	}
+
+	if err := validateOperations(root, arith, functions); err != nil {
+		return nil, err
+	}
+
+	for _, name := range calculation.VariableNames(root) {
+		if _, ok := variables[name]; !ok && !calculation.IsConstant(name) {
+			return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownVariable, name)
 		}
-		if isOperator(string(c)) {
-			if i > 0 && isOperator(string(expression[i-1])) && !(expression[i-1] == '(' && c == '-') {
-				if c == '-' && expression[i-1] == '-' {
-					return nil, fmt.Errorf("invalid expression: invalid structure")
+	}
+
+	return root, nil
+}
+
+// validateOperations checks that every operation in the tree, including the bodies of the called
+// user-defined functions, can be executed by agents in the number system of the expression.
+func validateOperations(node calculation.Node, arith calculation.Arithmetic, functions calculation.Functions) error {
+	validated := make(map[string]bool) // Тело каждой функции проверяется один раз, сколько бы раз она ни вызывалась.
+
+	var validate func(node calculation.Node) error
+	validate = func(node calculation.Node) error {
+		switch n := node.(type) {
+		case *calculation.GroupNode:
+			return validate(n.Inner)
+		case *calculation.UnaryNode:
+			if err := arith.CheckOperation(n.Op); err != nil {
+				return err
+			}
+			return validate(n.Operand)
+		case *calculation.BinaryNode:
+			if !planner.IsOperator(n.Op) {
+				return fmt.Errorf("%s '%s'", constants.ErrUnsupportedOperation, n.Op)
+			}
+			if err := arith.CheckOperation(n.Op); err != nil {
+				return err
+			}
+			if err := validate(n.Left); err != nil {
+				return err
+			}
+			return validate(n.Right)
+		case *calculation.CallNode:
+			for _, arg := range n.Args {
+				if err := validate(arg); err != nil {
+					return err
 				}
 			}
-			if c == '-' && (i == 0 || isOperator(string(expression[i-1])) || expression[i-1] == '(') {
-				tokens = append(tokens, "-1", "*")
-				continue
+			if fn, ok := functions[n.Name]; ok && !validated[n.Name] {
+				validated[n.Name] = true
+				return validate(fn.Body)
 			}
-			tokens = append(tokens, string(c))
-			continue
+			return nil
+		case *calculation.ListNode:
+			for _, element := range n.Elements {
+				if err := validate(element); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *calculation.ConditionalNode:
+			for _, child := range []calculation.Node{n.Cond, n.Then, n.Else} {
+				if err := validate(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		case *calculation.ProgramNode:
+			for _, binding := range n.Bindings {
+				if err := validate(binding.Value); err != nil {
+					return err
+				}
+			}
+			return validate(n.Result)
+		default:
+			return nil
 		}
-		return nil, fmt.Errorf("invalid expression: unexpected character '%c'", c)
 	}
+	return validate(node)
+}
 
-	if parenStack != 0 {
-		return nil, fmt.Errorf("invalid expression: unmatched parentheses")
+// hasOperations reports whether the tree contains at least one operation to plan.
+// A unary operator counts even over a literal, so `-5` is accepted just like `-x`.
+func hasOperations(node calculation.Node) bool {
+	switch n := node.(type) {
+	case *calculation.GroupNode:
+		return hasOperations(n.Inner)
+	case *calculation.UnaryNode, *calculation.BinaryNode, *calculation.CallNode:
+		return true
+	case *calculation.ListNode:
+		return slices.ContainsFunc(n.Elements, hasOperations)
+	case *calculation.ConditionalNode:
+		// Ветвь выбирает оркестратор, если условие не записано числом, например зависит от переменной.
+		return !isNumber(n.Cond) || hasOperations(n.Then) || hasOperations(n.Else)
+	case *calculation.ProgramNode:
+		for _, binding := range n.Bindings {
+			if hasOperations(binding.Value) {
+				return true
+			}
+		}
+		return hasOperations(n.Result)
+	default:
+		return false
 	}
+}
 
-	for i := 0; i < len(tokens)-1; i++ {
-		if tokens[i] == "(" && tokens[i+1] == ")" {
-			return nil, fmt.Errorf("invalid expression: empty expression")
-		}
+// isNumber reports whether the node is a numeric literal, possibly in parentheses.
+func isNumber(node calculation.Node) bool {
+	switch n := node.(type) {
+	case *calculation.GroupNode:
+		return isNumber(n.Inner)
+	case *calculation.NumberNode:
+		return true
+	default:
+		return false
 	}
+}
+
+// createTasks compiles the syntax tree into the dependency graph of tasks.
+// Variables are resolved here, so tasks carry only concrete values;
+// operations with known operands are computed right away as configured by FOLD_CONSTANTS.
+func (s *Server) createTasks(expr *models.Expression, root calculation.Node, functions calculation.Functions) (*planner.Result, error) {
+	return planner.Compile(expr.ID, root, planner.Options{
+		Variables:  expr.Variables,
+		Arithmetic: expressionArithmetic(expr),
+		Functions:  functions,
+		Folding:    planner.Folding(s.config.FoldConstants),
+	})
+}
 
-	for i := 0; i < len(tokens)-1; i++ {
-		if isOperator(tokens[i]) && tokens[i+1] == ")" {
-			return nil, fmt.Errorf("invalid expression: invalid structure")
+// expressionArithmetic returns the number system requested for the expression.
+func expressionArithmetic(expr *models.Expression) calculation.Arithmetic {
+	return calculation.Arithmetic{Mode: calculation.Mode(expr.Mode), Precision: expr.Precision}
+}
+
+// planBindings converts the named values of a program; the values computed while planning are filled in right away.
+func planBindings(plan *planner.Result, arith calculation.Arithmetic) ([]models.Binding, error) {
+	var bindings []models.Binding
+	for _, binding := range plan.Bindings {
+		b := models.Binding{Name: binding.Name, TaskID: binding.TaskID}
+		array, err := planArray(binding.Shape, binding.Elements, arith)
+		if err != nil {
+			return nil, err
 		}
-		if tokens[i] == "(" && isOperator(tokens[i+1]) {
-			return nil, fmt.Errorf("invalid expression: invalid structure")
+		b.Array = array
+		if binding.Value != nil {
+			value, exact, err := resultValue(arith, binding.Value.String())
+			if err != nil {
+				return nil, err
+			}
+			b.Value, b.Exact = &value, exact.ResultExact
 		}
+		bindings = append(bindings, b)
 	}
+	return bindings, nil
+}
 
-	operands, operators := 0, 0
-	for _, token := range tokens {
-		if isOperator(token) {
-			operators++
+// planArray converts an array value of the plan; the elements computed while planning are filled in right away
+// and the others keep the tasks that compute them. A scalar value has no array.
+func planArray(shape []int, elements []planner.Element, arith calculation.Arithmetic) (*models.Array, error) {
+	if shape == nil {
+		return nil, nil
+	}
+	array := &models.Array{Shape: shape, Values: make([]models.Value, len(elements)), TaskIDs: make([]string, len(elements))}
+	if arith.IsExact() {
+		array.Exact = make([]string, len(elements))
+	}
+	for i, element := range elements {
+		if element.Value == nil {
+			array.TaskIDs[i] = element.TaskID
 			continue
 		}
-		if token != "(" && token != ")" {
-			operands++
+		if err := setElement(array, i, arith, element.Value.String()); err != nil {
+			return nil, err
 		}
 	}
+	return array, nil
+}
 
-	if operators == 0 {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+// setElement writes the value of an element of an array given by its text.
+func setElement(array *models.Array, i int, arith calculation.Arithmetic, result string) error {
+	value, exact, err := resultValue(arith, result)
+	if err != nil {
+		return err
 	}
-
-	if len(tokens) == 1 && isOperator(tokens[0]) {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+	array.Values[i] = value
+	if array.Exact != nil {
+		array.Exact[i] = exact.ResultExact
 	}
+	return nil
+}
 
-	if len(tokens) > 1 && isOperator(tokens[len(tokens)-1]) {
-		if len(tokens) == 2 {
-			return nil, fmt.Errorf("invalid expression: too few tokens")
+// resolveArray returns a copy of the array with the elements computed by agents filled in.
+func (s *Server) resolveArray(array *models.Array, arith calculation.Arithmetic) (*models.Array, error) {
+	resolved := &models.Array{Shape: array.Shape, Values: slices.Clone(array.Values), Exact: slices.Clone(array.Exact),
+		TaskIDs: make([]string, len(array.TaskIDs))}
+	for i, taskID := range array.TaskIDs {
+		if taskID == "" {
+			continue
+		}
+		result, err := s.storage.GetTaskResult(taskID)
+		if err != nil {
+			return nil, err
+		}
+		if err := setElement(resolved, i, arith, result); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("invalid expression: trailing operator")
 	}
+	return resolved, nil
+}
 
-	if operators > 0 && operands <= 1 {
-		return nil, fmt.Errorf("invalid expression: too few tokens")
+// completeArray stores the elements of an array result, once their tasks are all finished,
+// as the result of the expression together with the values of the bindings of a program.
+func (s *Server) completeArray(exprID string, arith calculation.Arithmetic) error {
+	expr, err := s.storage.GetExpression(exprID)
+	if err != nil {
+		return err
 	}
-
-	if operands <= operators && operators > 0 {
-		return nil, fmt.Errorf("invalid expression: invalid structure")
+	result, err := s.resolveArray(expr.ResultArray, arith)
+	if err == nil {
+		err = s.resolveBindings(exprID, arith)
 	}
-
-	for _, token := range tokens {
-		if token != "(" && token != ")" && !isOperator(token) && strings.Count(token, ".") > 1 {
-			return nil, fmt.Errorf("invalid expression: invalid number format")
+	if err != nil {
+		if updateErr := s.storage.UpdateExpressionError(exprID, err.Error()); updateErr != nil {
+			s.logger.Error("Failed to update expression error status", zap.Error(updateErr))
 		}
+		return err
 	}
-
-	return tokens, nil
+	return s.storage.UpdateExpressionArrayResult(exprID, result)
 }
 
-func (s *Server) createTasks(exprID string, tokens []string) ([]*models.Task, error) {
-	rpnTokens, err := s.toRPN(tokens)
+// completeExpression stores the result of the root task as the result of its expression
+// together with the values of the bindings of a program, whose tasks are all finished by now.
+// In exact modes the textual value is kept alongside the float approximation;
+// rational results are also written as a mixed number and as a repeating decimal.
+func (s *Server) completeExpression(exprID string, arith calculation.Arithmetic, result string) error {
+	value, exact, err := resultValue(arith, result)
+	if err == nil {
+		err = s.resolveBindings(exprID, arith)
+	}
 	if err != nil {
-		return nil, err
+		if updateErr := s.storage.UpdateExpressionError(exprID, err.Error()); updateErr != nil {
+			s.logger.Error("Failed to update expression error status", zap.Error(updateErr))
+		}
+		return err
 	}
+	return s.storage.UpdateExpressionExactResult(exprID, value, exact)
+}
 
-	var tasks []*models.Task
-	var stack []interface{}
-
-	for _, token := range rpnTokens {
-		if isOperator(token) {
-			if len(stack) < 2 {
-				return nil, fmt.Errorf("invalid RPN expression: too few operands")
-			}
-
-			op2 := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-			op1 := stack[len(stack)-1]
-			stack = stack[:len(stack)-1]
-
-			task := &models.Task{
-				ID:           uuid.New().String(),
-				ExpressionID: exprID,
-				Operation:    token,
-			}
-
-			_ = s.getOperationTime(token)
-
-			switch v := op1.(type) {
-			case float64:
-				task.Arg1 = v
-			case string:
-				task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, v)
-			}
-			switch v := op2.(type) {
-			case float64:
-				task.Arg2 = v
-			case string:
-				task.DependsOnTaskIDs = append(task.DependsOnTaskIDs, v)
+// resolveBindings fills in the values of the bindings computed by agents.
+func (s *Server) resolveBindings(exprID string, arith calculation.Arithmetic) error {
+	expr, err := s.storage.GetExpression(exprID)
+	if err != nil || len(expr.Bindings) == 0 {
+		return err
+	}
+	bindings := slices.Clone(expr.Bindings)
+	for i, binding := range bindings {
+		if binding.Array != nil {
+			array, err := s.resolveArray(binding.Array, arith)
+			if err != nil {
+				return err
 			}
-
-			tasks = append(tasks, task)
-			stack = append(stack, task.ID)
-		} else {
-			num, _ := strconv.ParseFloat(token, 64)
-			stack = append(stack, num)
+			bindings[i].Array = array
+			continue
+		}
+		if binding.Value != nil {
+			continue
 		}
+		result, err := s.storage.GetTaskResult(binding.TaskID)
+		if err != nil {
+			return err
+		}
+		value, exact, err := resultValue(arith, result)
+		if err != nil {
+			return err
+		}
+		bindings[i].Value, bindings[i].Exact = &value, exact.ResultExact
 	}
+	return s.storage.UpdateExpressionBindings(exprID, bindings)
+}
 
-	if len(stack) != 1 {
-		return nil, fmt.Errorf("invalid RPN expression: too many operands")
+// resultValue converts the textual result of a task into the value of an expression and its exact forms.
+func resultValue(arith calculation.Arithmetic, result string) (models.Value, models.ExactResult, error) {
+	value, err := arith.Parse(result)
+	if err != nil {
+		return models.Value{}, models.ExactResult{}, err
 	}
 
-	return tasks, nil
+	var exact models.ExactResult
+	if arith.IsExact() {
+		exact.ResultExact = value.String()
+	}
+	if rational, ok := value.(calculation.Rational); ok {
+		exact.ResultMixed = rational.Mixed()
+		exact.ResultDecimal = rational.DecimalExpansion()
+	}
+	complexValue := calculation.ToComplex(value)
+	return models.Value{Re: real(complexValue), Im: imag(complexValue)}, exact, nil
 }
 
 func (s *Server) getOperationTime(op string) int64 {
@@ -224,51 +450,20 @@ func (s *Server) getOperationTime(op string) int64 {
 		return s.config.TimeSubtractionMS
 	case "*":
 		return s.config.TimeMultiplyMS
-	case "/":
+	case "/", "//":
 		return s.config.TimeDivisionMS
+	case "^":
+		return s.config.TimePowerMS
+	case "%":
+		return s.config.TimeModuloMS
+	case "&", "|", "xor", "<<", ">>":
+		return s.config.TimeBitwiseMS
+	case "==", "!=", "<", "<=", ">", ">=", "and", "or":
+		return s.config.TimeComparisonMS
 	default:
-		return 100
-	}
-}
-
-func isOperator(token string) bool {
-	switch token {
-	case "+", "-", "*", "/":
-		return true
-	default:
-		return false
-	}
-}
-
-func isDigit(c byte) bool {
-	return c >= '0' && c <= '9'
-}
-
-func (s *Server) toRPN(tokens []string) ([]string, error) {
-	var stack []string
-	var output []string
-
-	for _, token := range tokens {
-		if isOperator(token) {
-
-			for len(stack) > 0 && isOperator(stack[len(stack)-1]) {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, token)
-		} else {
-
-			if _, err := strconv.ParseFloat(token, 64); err != nil {
-				return nil, fmt.Errorf("invalid number: %s", token)
-			}
-			output = append(output, token)
+		if fn, ok := calculation.LookupFunction(op); ok {
+			return s.config.TimeFunctionMS * fn.Cost
 		}
+		return 100
 	}
-
-	for len(stack) > 0 {
-		output = append(output, stack[len(stack)-1])
-		stack = stack[:len(stack)-1]
-	}
-
-	return output, nil
 }
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"distributed_calculator/internal/app/models"
 	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
 
 	"go.uber.org/zap"
 )
@@ -24,3 +26,16 @@ func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
 		s.logger.Error("Failed to write error response", zap.Error(err))
 	}
 }
+
+// writeParseError описывает ошибку разбора объектом с кодом и местом ошибки.
+func (s *Server) writeParseError(w http.ResponseWriter, err *calculation.ParseError) {
+	s.writeJSON(w, http.StatusUnprocessableEntity, models.ParseErrorResponse{Error: models.ParseErrorDetails{
+		Code:     err.Code,
+		Message:  err.Message,
+		Position: err.Position,
+		Column:   err.Column(),
+		Token:    err.Token,
+		Expected: err.Expected,
+		Caret:    err.Caret(),
+	}})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"distributed_calculator/internal/constants"
@@ -21,14 +22,27 @@ type Server struct {
 	storage *storage.Storage
 	logger  *logger.Logger
 	server  *http.Server
+	ctx     context.Context // Отменяется в Shutdown и останавливает фоновый сборщик задач с истёкшей арендой.
+	cancel  context.CancelFunc
+
+	functionsMu sync.Mutex // Упорядочивает изменения пользовательских функций, чтобы проверка циклов не устарела.
 }
 
 // New creates a new Server instance with the provided configuration and logger.
 func New(cfg *configs.ServerConfig, log *logger.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := &Server{
 		config:  cfg,
 		storage: storage.New(log.Logger),
 		logger:  log,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if cfg.LeaseTimeoutMS > 0 {
+		s.storage.SetLeaseTimeout(time.Duration(cfg.LeaseTimeoutMS) * time.Millisecond)
+	}
+	if cfg.MaxTaskAttempts > 0 {
+		s.storage.SetRetryPolicy(cfg.MaxTaskAttempts, time.Duration(cfg.RetryBackoffMS)*time.Millisecond)
 	}
 
 	router := mux.NewRouter()
@@ -37,10 +51,21 @@ func New(cfg *configs.ServerConfig, log *logger.Logger) *Server {
 	api.HandleFunc("/calculate", s.handleCalculate).Methods(http.MethodPost)
 	api.HandleFunc("/expressions", s.handleListExpressions).Methods(http.MethodGet)
 	api.HandleFunc("/expressions/{id}", s.handleGetExpression).Methods(http.MethodGet)
+	api.HandleFunc("/expressions/{id}", s.handleCancelExpression).Methods(http.MethodDelete)
+	api.HandleFunc("/derive", s.handleDerive).Methods(http.MethodPost)
+	api.HandleFunc("/functions", s.handleCreateFunction).Methods(http.MethodPost)
+	api.HandleFunc("/functions", s.handleListFunctions).Methods(http.MethodGet)
+	api.HandleFunc("/functions/{name}", s.handleGetFunction).Methods(http.MethodGet)
+	api.HandleFunc("/functions/{name}", s.handleDeleteFunction).Methods(http.MethodDelete)
 
 	internal := router.PathPrefix("/internal").Subrouter()
 	internal.HandleFunc(constants.PathTask, s.handleGetTask).Methods(http.MethodGet)
 	internal.HandleFunc(constants.PathTask, s.handleSubmitTaskResult).Methods(http.MethodPost)
+	internal.HandleFunc(constants.PathTaskByID, s.handleGetTaskState).Methods(http.MethodGet)
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/dead-letter", s.handleListDeadLetters).Methods(http.MethodGet)
+	admin.HandleFunc("/dead-letter/{id}/requeue", s.handleRequeueDeadLetter).Methods(http.MethodPost)
 
 	web := router.PathPrefix("/web").Subrouter()
 	web.HandleFunc("/calculate", s.handleWebCalculatePage)
@@ -66,7 +91,15 @@ func New(cfg *configs.ServerConfig, log *logger.Logger) *Server {
 		zap.Int64("timeAdditionMS", cfg.TimeAdditionMS),
 		zap.Int64("timeSubtractionMS", cfg.TimeSubtractionMS),
 		zap.Int64("timeMultiplyMS", cfg.TimeMultiplyMS),
-		zap.Int64("timeDivisionMS", cfg.TimeDivisionMS))
+		zap.Int64("timeDivisionMS", cfg.TimeDivisionMS),
+		zap.Int64("timePowerMS", cfg.TimePowerMS),
+		zap.Int64("timeModuloMS", cfg.TimeModuloMS),
+		zap.Int64("timeBitwiseMS", cfg.TimeBitwiseMS),
+		zap.Int64("timeComparisonMS", cfg.TimeComparisonMS),
+		zap.Int64("timeFunctionMS", cfg.TimeFunctionMS),
+		zap.Int64("leaseTimeoutMS", cfg.LeaseTimeoutMS),
+		zap.Int("maxTaskAttempts", cfg.MaxTaskAttempts),
+		zap.Int64("retryBackoffMS", cfg.RetryBackoffMS))
 
 	return s
 }
@@ -77,13 +110,16 @@ func (s *Server) GetHandler() http.Handler {
 }
 
 // Start begins listening on the configured port and serves HTTP requests.
+// Until Shutdown, it also returns tasks with expired leases to the queue in the background.
 func (s *Server) Start() error {
 	s.logger.Info("Starting server", zap.String(constants.FieldPort, s.config.Port))
+	go s.runReaper(s.ctx)
 	return s.server.ListenAndServe()
 }
 
 // Shutdown gracefully shuts down the server without interrupting active connections.
 func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
 	return s.server.Shutdown(ctx)
 }
 
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+
+	"go.uber.org/zap"
+)
+
+// ListDeadLetters перечисляет задачи, исчерпавшие попытки выполнения, в порядке их поступления в список.
+func (s *Storage) ListDeadLetters() []models.DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.DeadLetter{}, s.deadLetter...)
+}
+
+// RequeueDeadLetter возвращает задачу из списка недоставленных в очередь с новым набором попыток.
+// Если других задач выражения в списке не осталось и выражение завершилось ошибкой из-за этой задачи,
+// оно снова вычисляется, а отменённые вместе с ней задачи возвращаются к выполнению.
+func (s *Storage) RequeueDeadLetter(taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.deadLetter, func(entry models.DeadLetter) bool {
+		return entry.TaskID == taskID
+	})
+	value, ok := s.tasks.Load(taskID)
+	if i < 0 || !ok {
+		s.logger.Warn("Failed to requeue task: task is not in dead-letter list",
+			zap.String("id", taskID))
+		return fmt.Errorf("dead-letter task not found")
+	}
+	s.deadLetter = slices.Delete(s.deadLetter, i, i+1)
+
+	task := *value.(*models.Task)
+	task.Attempts = 0
+	task.RetryAt = time.Time{}
+	s.tasks.Store(task.ID, &task)
+	s.taskQueue = append(s.taskQueue, task)
+
+	reopened := !slices.ContainsFunc(s.deadLetter, func(entry models.DeadLetter) bool {
+		return entry.ExpressionID == task.ExpressionID
+	}) && s.reopenExpression(task.ExpressionID)
+	if reopened {
+		s.restoreTasks(task.ExpressionID)
+	}
+	s.logger.Info("Task requeued from dead-letter list",
+		zap.String("id", task.ID),
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.Bool("reopened", reopened))
+	return nil
+}
+
+// deadLetterError возвращает текст ошибки, с которой завершается выражение задачи из списка недоставленных.
+func deadLetterError(entry models.DeadLetter) string {
+	return fmt.Sprintf(constants.ErrAttemptsExhausted, entry.TaskID, entry.Attempts, entry.Reason)
+}
+
+// reopenExpression возвращает выражение в состояние вычисления, если оно завершилось ошибкой из-за задачи
+// из списка недоставленных; такую задачу failAttempt запоминает в s.suspended. Обычный порядок статусов это
+// запрещает: ошибка здесь снимается, потому что задачу, которая её вызвала, вернули в очередь. Ошибку
+// по другой причине снимать нельзя, о таком выражении сообщает false. s.mu должен быть захвачен.
+func (s *Storage) reopenExpression(expressionID string) bool {
+	value, ok := s.expressions.Load(expressionID)
+	if !ok || s.suspended[expressionID].cause == "" {
+		return false
+	}
+	expr := value.(*models.Expression)
+	if expr.Status != models.StatusError {
+		return false
+	}
+
+	updated := *expr
+	updated.Status = models.StatusProgress
+	updated.Error = ""
+	updated.UpdatedAt = time.Now()
+	s.expressions.Store(expressionID, &updated)
+	s.logger.Info(constants.LogExpressionStatusUpdated,
+		zap.String(constants.FieldID, expressionID),
+		zap.String(constants.FieldOldStatus, string(expr.Status)),
+		zap.String(constants.FieldNewStatus, string(updated.Status)))
+	return true
+}
+
+// restoreTasks снимает отмену с задач выражения, отменённых из-за задачи в списке недоставленных.
+// Задачи, которые были в очереди, у агентов или ждали зависимостей, планируются снова; удерживаемые задачи
+// ждут, пока их отпустит оркестратор. s.mu должен быть захвачен.
+func (s *Storage) restoreTasks(expressionID string) {
+	running := s.suspended[expressionID].tasks
+	delete(s.suspended, expressionID)
+
+	restored := 0
+	s.tasks.Range(func(_, value interface{}) bool {
+		task := value.(*models.Task)
+		if task.ExpressionID != expressionID || !task.Cancelled {
+			return true
+		}
+		updated := *task
+		updated.Cancelled = false
+		s.tasks.Store(updated.ID, &updated)
+		if slices.Contains(running, updated.ID) {
+			s.schedule(&updated)
+		}
+		restored++
+		return true
+	})
+	s.logger.Info("Expression tasks restored",
+		zap.String(constants.FieldExpressionID, expressionID),
+		zap.Int(constants.FieldCount, restored))
+}
@@ -47,7 +47,11 @@ func (s *Storage) GetExpression(id string) (*models.Expression, error) {
 func (s *Storage) UpdateExpressionStatus(id string, status models.ExpressionStatus) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.setExpressionStatus(id, status)
+}
 
+// setExpressionStatus обновляет статус выражения, как UpdateExpressionStatus; s.mu должен быть захвачен.
+func (s *Storage) setExpressionStatus(id string, status models.ExpressionStatus) error {
 	if value, ok := s.expressions.Load(id); ok {
 		expr := value.(*models.Expression)
 		oldStatus := expr.Status
@@ -80,13 +84,34 @@ func (s *Storage) UpdateExpressionStatus(id string, status models.ExpressionStat
 	return fmt.Errorf("expression not found")
 }
 
+// CancelExpression отменяет вычисление выражения: оно получает статус CANCELLED, а его невычисленные задачи
+// убираются из очереди, и результаты по ним больше не принимаются. Завершённое выражение отменить нельзя.
+func (s *Storage) CancelExpression(id string) error {
+	if err := s.UpdateExpressionStatus(id, models.StatusCancelled); err != nil {
+		return err
+	}
+	s.CancelExpressionTasks(id)
+	return nil
+}
+
 // UpdateExpressionResult обновляет результат выражения в хранилище.
 func (s *Storage) UpdateExpressionResult(id string, result float64) error {
+	return s.UpdateExpressionExactResult(id, models.Value{Re: result}, models.ExactResult{})
+}
+
+// UpdateExpressionExactResult обновляет результат выражения вместе с его точной текстовой записью.
+func (s *Storage) UpdateExpressionExactResult(id string, result models.Value, exact models.ExactResult) error {
 	if value, ok := s.expressions.Load(id); ok {
 		expr := value.(*models.Expression)
 
+		// Отменённое выражение остаётся отменённым, даже если его вычисление успело завершиться.
+		if expr.Status == models.StatusCancelled {
+			return fmt.Errorf("expression cancelled")
+		}
+
 		updated := *expr
 		updated.Result = &result
+		updated.ExactResult = exact
 		updated.Status = models.StatusComplete
 		updated.UpdatedAt = time.Now()
 
@@ -96,11 +121,80 @@ func (s *Storage) UpdateExpressionResult(id string, result float64) error {
 	return fmt.Errorf("expression not found")
 }
 
+// UpdateExpressionArrayResult обновляет результат-массив выражения.
+func (s *Storage) UpdateExpressionArrayResult(id string, result *models.Array) error {
+	if value, ok := s.expressions.Load(id); ok {
+		expr := value.(*models.Expression)
+
+		if expr.Status == models.StatusCancelled {
+			return fmt.Errorf("expression cancelled")
+		}
+
+		updated := *expr
+		updated.ResultArray = result
+		updated.Status = models.StatusComplete
+		updated.UpdatedAt = time.Now()
+
+		s.expressions.Store(id, &updated)
+		return nil
+	}
+	return fmt.Errorf("expression not found")
+}
+
+// UpdateExpressionPlan сохраняет результаты планирования выражения: корневую задачу или, если результат —
+// массив, его элементы с задачами, которые их вычисляют, именованные значения программы и операции,
+// которые не стали задачами для агентов.
+func (s *Storage) UpdateExpressionPlan(id, rootTaskID string, resultArray *models.Array, bindings []models.Binding,
+	eliminated []models.EliminatedTask) error {
+	if value, ok := s.expressions.Load(id); ok {
+		expr := value.(*models.Expression)
+
+		updated := *expr
+		updated.RootTaskID = rootTaskID
+		updated.ResultArray = resultArray
+		updated.Bindings = bindings
+		updated.Eliminated = eliminated
+		updated.UpdatedAt = time.Now()
+
+		s.expressions.Store(id, &updated)
+		return nil
+	}
+	return fmt.Errorf("expression not found")
+}
+
+// UpdateExpressionBindings обновляет именованные значения программы.
+func (s *Storage) UpdateExpressionBindings(id string, bindings []models.Binding) error {
+	if value, ok := s.expressions.Load(id); ok {
+		expr := value.(*models.Expression)
+
+		updated := *expr
+		updated.Bindings = bindings
+		updated.UpdatedAt = time.Now()
+
+		s.expressions.Store(id, &updated)
+		return nil
+	}
+	return fmt.Errorf("expression not found")
+}
+
 // UpdateExpressionError обновляет ошибку выражения в хранилище.
+// Ошибка приходит не из списка недоставленных, поэтому возврат задач из него больше не возобновит выражение.
 func (s *Storage) UpdateExpressionError(id string, err string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.suspended, id)
+	return s.setExpressionError(id, err)
+}
+
+// setExpressionError переводит выражение в статус ERROR, как UpdateExpressionError; s.mu должен быть захвачен.
+func (s *Storage) setExpressionError(id string, err string) error {
 	if value, ok := s.expressions.Load(id); ok {
 		expr := value.(*models.Expression)
 
+		if expr.Status == models.StatusCancelled {
+			return fmt.Errorf("expression cancelled")
+		}
+
 		updated := *expr
 		updated.Error = err
 		updated.Status = models.StatusError
@@ -128,10 +222,10 @@ func (s *Storage) ListExpressions() []*models.Expression {
 func isValidStatusTransition(from, to models.ExpressionStatus) bool {
 	switch from {
 	case models.StatusPending:
-		return to == models.StatusProgress || to == models.StatusError
+		return to == models.StatusProgress || to == models.StatusError || to == models.StatusCancelled
 	case models.StatusProgress:
-		return to == models.StatusComplete || to == models.StatusError
-	case models.StatusComplete, models.StatusError:
+		return to == models.StatusComplete || to == models.StatusError || to == models.StatusCancelled
+	case models.StatusComplete, models.StatusError, models.StatusCancelled:
 		return false
 	default:
 		return true
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"distributed_calculator/internal/app/models"
+
+	"go.uber.org/zap"
+)
+
+// SaveFunction сохраняет пользовательскую функцию, заменяя функцию с тем же именем.
+func (s *Storage) SaveFunction(fn *models.Function) error {
+	if fn.Name == "" {
+		s.logger.Error("Failed to save function: empty name")
+		return fmt.Errorf("function name cannot be empty")
+	}
+
+	fnCopy := *fn
+	fnCopy.Params = append([]string{}, fn.Params...)
+	s.functions.Store(fn.Name, &fnCopy)
+	s.logger.Info("Function saved successfully",
+		zap.String("name", fn.Name),
+		zap.Strings("params", fn.Params),
+		zap.String("body", fn.Body))
+	return nil
+}
+
+// GetFunction извлекает пользовательскую функцию по имени.
+func (s *Storage) GetFunction(name string) (*models.Function, error) {
+	if value, ok := s.functions.Load(name); ok {
+		return value.(*models.Function), nil
+	}
+	s.logger.Warn("Function not found",
+		zap.String("name", name))
+	return nil, fmt.Errorf("function not found")
+}
+
+// ListFunctions перечисляет пользовательские функции в алфавитном порядке.
+func (s *Storage) ListFunctions() []*models.Function {
+	var functions []*models.Function
+	s.functions.Range(func(_, value interface{}) bool {
+		functions = append(functions, value.(*models.Function))
+		return true
+	})
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Name < functions[j].Name
+	})
+	return functions
+}
+
+// DeleteFunction удаляет пользовательскую функцию.
+func (s *Storage) DeleteFunction(name string) error {
+	if _, loaded := s.functions.LoadAndDelete(name); !loaded {
+		s.logger.Warn("Failed to delete function: function not found",
+			zap.String("name", name))
+		return fmt.Errorf("function not found")
+	}
+	s.logger.Info("Function deleted",
+		zap.String("name", name))
+	return nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"distributed_calculator/internal/app/models"
 
@@ -12,18 +13,61 @@ import (
 type Storage struct {
 	expressions sync.Map
 	tasks       sync.Map
-	taskQueue   []models.Task // Slice to ensure FIFO order
-	mu          sync.Mutex
-	logger      *zap.Logger
+	functions   sync.Map              // Пользовательские функции по имени.
+	taskQueue   []models.Task         // Slice to ensure FIFO order
+	blocked     map[string][]string   // Задачи, ожидающие результата задачи-ключа, в порядке блокировки; см. SaveTask.
+	leaseTTL    time.Duration         // Срок аренды задачи, выданной агенту; см. GetNextTask.
+	maxAttempts int                   // Сколько раз задача выдаётся агентам, прежде чем попасть в deadLetter.
+	backoff     time.Duration         // Пауза перед второй попыткой; перед каждой следующей она удваивается.
+	deadLetter  []models.DeadLetter   // Задачи, исчерпавшие попытки, в порядке поступления.
+	suspended   map[string]suspension // Задачи выражения, снятые с выполнения из-за задачи в deadLetter; см. RequeueDeadLetter.
+	// mu защищает очередь, списки задач и переходы статусов выражений. Экспортируемые методы захватывают его сами,
+	// а код пакета, который уже держит mu, меняет выражение через setExpressionStatus и setExpressionError.
+	mu     sync.Mutex
+	logger *zap.Logger
 }
 
+// suspension описывает задачи выражения, отменённые из-за задачи, исчерпавшей попытки.
+type suspension struct {
+	cause string   // Задача из deadLetter, из-за которой выражение завершилось ошибкой; пусто, если ошибка другая.
+	tasks []string // Задачи, которые были в очереди, у агентов или ждали зависимостей.
+}
+
+// Значения по умолчанию для SetLeaseTimeout и SetRetryPolicy.
+const (
+	DefaultLeaseTimeout = 30 * time.Second // Срок аренды задачи.
+	DefaultMaxAttempts  = 3                // Число попыток выполнения задачи.
+	DefaultRetryBackoff = time.Second      // Пауза перед второй попыткой.
+)
+
 func New(logger *zap.Logger) *Storage {
 	return &Storage{
-		taskQueue: make([]models.Task, 0),
-		logger:    logger,
+		taskQueue:   make([]models.Task, 0),
+		blocked:     make(map[string][]string),
+		suspended:   make(map[string]suspension),
+		leaseTTL:    DefaultLeaseTimeout,
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultRetryBackoff,
+		logger:      logger,
 	}
 }
 
+// SetLeaseTimeout задаёт срок аренды для задач, которые будут выданы агентам после вызова.
+func (s *Storage) SetLeaseTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leaseTTL = timeout
+}
+
+// SetRetryPolicy задаёт, сколько раз задача выдаётся агентам, прежде чем попасть в список недоставленных,
+// и паузу перед второй попыткой; перед каждой следующей попыткой пауза удваивается.
+func (s *Storage) SetRetryPolicy(maxAttempts int, backoff time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxAttempts = maxAttempts
+	s.backoff = backoff
+}
+
 func (s *Storage) GetTasksByDependency(taskID string) []*models.Task {
 	var dependentTasks []*models.Task
 	s.tasks.Range(func(_, value interface{}) bool {
@@ -39,15 +83,15 @@ func (s *Storage) GetTasksByDependency(taskID string) []*models.Task {
 	return dependentTasks
 }
 
-func (s *Storage) GetTaskResult(taskID string) (float64, error) {
+func (s *Storage) GetTaskResult(taskID string) (string, error) {
 	if value, ok := s.tasks.Load(taskID); ok {
 		task := value.(*models.Task)
 		if task.Result == nil {
-			return 0, fmt.Errorf("task result not set: %s", taskID)
+			return "", fmt.Errorf("task result not set: %s", taskID)
 		}
 		return *task.Result, nil
 	}
-	return 0, fmt.Errorf("task not found") 
+	return "", fmt.Errorf("task not found")
 }
 
 func (s *Storage) GetTasksByExpressionID(expressionID string) []*models.Task {
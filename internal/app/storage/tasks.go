@@ -2,17 +2,50 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"time"
 
 	"distributed_calculator/internal/constants"
 	"distributed_calculator/internal/app/models"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-// SaveTask saves a task to storage and adds it to the task queue.
+// SaveTask saves a task to storage and adds it to the task queue once it is runnable:
+// a task whose dependencies are not all computed yet is kept in the blocked set
+// and promoted to the queue by UpdateTaskResult when the last of their results arrives.
 func (s *Storage) SaveTask(task *models.Task) error {
+	return s.saveTask(task, true)
+}
+
+// SaveHeldTask saves a task without queuing it even when it is runnable: the task waits for the outcome
+// of a conditional task, or is a conditional task itself, which the orchestrator resolves. See ReleaseTask.
+func (s *Storage) SaveHeldTask(task *models.Task) error {
+	return s.saveTask(task, false)
+}
+
+// ReleaseTask lets a held task run: it is queued right away if its dependencies are computed,
+// otherwise it is blocked until they are.
+func (s *Storage) ReleaseTask(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.tasks.Load(id)
+	if !ok {
+		s.logger.Error("Failed to release task: task not found",
+			zap.String("id", id))
+		return fmt.Errorf("task not found")
+	}
+	s.schedule(value.(*models.Task))
+	return nil
+}
+
+// saveTask stores a copy of the task and, if schedule is set, queues or blocks it.
+func (s *Storage) saveTask(task *models.Task, schedule bool) error {
 	if task.ID == "" {
 		s.logger.Error("Failed to save task: empty ID")
 		return fmt.Errorf("task ID cannot be empty")
@@ -25,16 +58,58 @@ func (s *Storage) SaveTask(task *models.Task) error {
 	task.CreatedAt = now
 
 	taskCopy := *task
+	// Выражение могли отменить, пока его задачи планировались.
+	if value, ok := s.expressions.Load(task.ExpressionID); ok && value.(*models.Expression).Status == models.StatusCancelled {
+		taskCopy.Cancelled = true
+		schedule = false
+	}
 	s.tasks.Store(task.ID, &taskCopy)
-	s.taskQueue = append(s.taskQueue, taskCopy)
+	queued := schedule && s.schedule(&taskCopy)
 
 	s.logger.Info("Task saved successfully",
 		zap.String("id", task.ID),
 		zap.String(constants.FieldExpressionID, task.ExpressionID),
-		zap.String(constants.FieldOperation, task.Operation))
+		zap.String(constants.FieldOperation, task.Operation),
+		zap.Bool("queued", queued))
 	return nil
 }
 
+// schedule queues the task with the results of its dependencies written into their argument slots by position,
+// or blocks it on the first dependency whose result is missing. It reports whether the task was queued;
+// s.mu must be held.
+func (s *Storage) schedule(task *models.Task) bool {
+	ready := *task
+	ready.Args = append([]string(nil), task.Args...)
+	for i, depID := range task.DependsOnTaskIDs {
+		result, err := s.GetTaskResult(depID)
+		if err != nil {
+			s.blocked[depID] = append(s.blocked[depID], task.ID)
+			return false
+		}
+		ready.SetArg(task.DependencySlots[i], result)
+	}
+
+	s.tasks.Store(task.ID, &ready)
+	s.taskQueue = append(s.taskQueue, ready)
+	return true
+}
+
+// SkipTask marks a task of a branch that was not selected by its conditional task; it will never be executed.
+func (s *Storage) SkipTask(id string) error {
+	if value, ok := s.tasks.Load(id); ok {
+		task := *value.(*models.Task)
+		task.Skipped = true
+		s.tasks.Store(id, &task)
+		s.logger.Info("Task skipped",
+			zap.String("id", id),
+			zap.String(constants.FieldExpressionID, task.ExpressionID))
+		return nil
+	}
+	s.logger.Error("Failed to skip task: task not found",
+		zap.String("id", id))
+	return fmt.Errorf("task not found")
+}
+
 // GetTask retrieves a task by ID.
 func (s *Storage) GetTask(id string) (*models.Task, error) {
 	if value, ok := s.tasks.Load(id); ok {
@@ -47,20 +122,177 @@ func (s *Storage) GetTask(id string) (*models.Task, error) {
 	return nil, fmt.Errorf("task not found") // Исправлено на константную строку вместо strings.ToLower
 }
 
-// UpdateTaskResult updates a task's result and checks for expression completion.
-func (s *Storage) UpdateTaskResult(id string, result float64) error {
+// Errors returned by SubmitTaskResult and FailTask when the result of a task is not accepted.
+var (
+	// ErrLeaseExpired means the result comes under a lease that has expired or was never issued for the task.
+	ErrLeaseExpired = errors.New(constants.ErrLeaseExpired)
+	// ErrTaskCancelled means the expression of the task failed or was cancelled; the task will not be computed.
+	ErrTaskCancelled = errors.New(constants.ErrTaskCancelled)
+)
+
+// UpdateTaskResult updates a task's result, promotes the blocked tasks that were waiting only for it
+// to the task queue and checks for expression completion.
+func (s *Storage) UpdateTaskResult(id string, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completeTask(id, result)
+}
+
+// SubmitTaskResult accepts the result of a task computed by an agent: it is recorded like in UpdateTaskResult
+// only while the lease the agent received the task under is still held.
+func (s *Storage) SubmitTaskResult(id, leaseID, result string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if value, ok := s.tasks.Load(id); ok {
+		if err := s.checkLease(value.(*models.Task), leaseID); err != nil {
+			return err
+		}
+	}
+	return s.completeTask(id, result)
+}
+
+// FailTask records the error an agent reported for a task it holds the lease of and releases the lease.
+// The task is not retried: the error is a property of its arguments, so the caller fails the expression.
+func (s *Storage) FailTask(id, leaseID, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.tasks.Load(id)
+	if !ok {
+		s.logger.Error("Failed to fail task: task not found",
+			zap.String("id", id))
+		return fmt.Errorf("task not found")
+	}
+	if err := s.checkLease(value.(*models.Task), leaseID); err != nil {
+		return err
+	}
+
+	task := *value.(*models.Task)
+	task.LeaseID = ""
+	task.LeaseDeadline = time.Time{}
+	task.LastError = message
+	s.tasks.Store(id, &task)
+	s.logger.Warn("Task failed",
+		zap.String("id", id),
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.String("error", message))
+	return nil
+}
+
+// checkLease reports why an agent may not submit the outcome of a task under leaseID:
+// the task was cancelled, or leaseID is not its current, unexpired lease.
+func (s *Storage) checkLease(task *models.Task, leaseID string) error {
+	if task.Cancelled {
+		s.logger.Info("Rejected outcome of cancelled task",
+			zap.String("id", task.ID),
+			zap.String(constants.FieldExpressionID, task.ExpressionID))
+		return ErrTaskCancelled
+	}
+	if task.LeaseID == "" || task.LeaseID != leaseID || !time.Now().Before(task.LeaseDeadline) {
+		s.logger.Warn("Rejected task outcome: lease expired or not held",
+			zap.String("id", task.ID),
+			zap.String(constants.FieldLeaseID, leaseID))
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// CancelExpressionTasks cancels the tasks of an expression that have not been computed yet: they are removed
+// from the queue, the blocked set and the dead-letter list, and results under their leases are rejected.
+// It reports how many tasks were cancelled.
+func (s *Storage) CancelExpressionTasks(expressionID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetter = slices.DeleteFunc(s.deadLetter, func(entry models.DeadLetter) bool {
+		return entry.ExpressionID == expressionID
+	})
+	delete(s.suspended, expressionID)
+	cancelled, _ := s.cancelTasks(expressionID)
+
+	s.logger.Info("Expression tasks cancelled",
+		zap.String(constants.FieldExpressionID, expressionID),
+		zap.Int(constants.FieldCount, cancelled))
+	return cancelled
+}
+
+// cancelTasks cancels the tasks of an expression that have not been computed yet, except the tasks
+// in the dead-letter list, which stay there to be requeued. It reports how many tasks were cancelled
+// and which of them were queued, leased or blocked rather than held; s.mu must be held.
+func (s *Storage) cancelTasks(expressionID string) (int, []string) {
+	scheduled := make(map[string]bool)
+	for _, task := range s.taskQueue {
+		scheduled[task.ID] = true
+	}
+	for _, waiting := range s.blocked {
+		for _, id := range waiting {
+			scheduled[id] = true
+		}
+	}
+
+	cancelled := 0
+	var running []string
+	s.tasks.Range(func(_, value interface{}) bool {
 		task := value.(*models.Task)
+		if task.ExpressionID != expressionID || task.Result != nil || task.Skipped || task.Cancelled {
+			return true
+		}
+		if slices.ContainsFunc(s.deadLetter, func(entry models.DeadLetter) bool { return entry.TaskID == task.ID }) {
+			return true
+		}
+		if scheduled[task.ID] || task.LeaseID != "" {
+			running = append(running, task.ID)
+		}
+		updated := *task
+		updated.Cancelled = true
+		updated.LeaseID = ""
+		updated.LeaseDeadline = time.Time{}
+		s.tasks.Store(updated.ID, &updated)
+		delete(s.blocked, updated.ID)
+		cancelled++
+		return true
+	})
+	s.taskQueue = slices.DeleteFunc(s.taskQueue, func(task models.Task) bool {
+		return task.ExpressionID == expressionID
+	})
+	return cancelled, running
+}
+
+// completeTask records a task's result, releases its lease and completes the expression
+// once all of its tasks are done. s.mu must be held.
+func (s *Storage) completeTask(id string, result string) error {
+	value, ok := s.tasks.Load(id)
+	if ok {
+		task := *value.(*models.Task)
 		task.Result = &result
-		s.tasks.Store(id, task)
+		task.LeaseID = ""
+		task.LeaseDeadline = time.Time{}
+		s.tasks.Store(id, &task)
+		promoted := 0
+		waiting := s.blocked[id]
+		delete(s.blocked, id)
+		seen := make(map[string]bool, len(waiting))
+		for _, waitingID := range waiting {
+			waitingValue, _ := s.tasks.Load(waitingID)
+			waitingTask := waitingValue.(*models.Task)
+			// Задача, сохранённая повторно, могла попасть в список дважды.
+			if waitingTask.Skipped || waitingTask.Cancelled || waitingTask.Result != nil || seen[waitingID] {
+				continue
+			}
+			seen[waitingID] = true
+			if s.schedule(waitingTask) {
+				promoted++
+			}
+		}
 		s.logger.Info("Task result updated",
 			zap.String("id", id),
-			zap.Float64("result", result))
+			zap.String("result", result),
+			zap.Int("promoted", promoted))
 
 		allTasksCompleted := true
 		s.tasks.Range(func(_, v interface{}) bool {
 			t := v.(*models.Task)
-			if t.ExpressionID == task.ExpressionID && t.Result == nil {
+			if t.ExpressionID == task.ExpressionID && t.Result == nil && !t.Skipped {
 				allTasksCompleted = false
 				return false
 			}
@@ -68,7 +300,7 @@ func (s *Storage) UpdateTaskResult(id string, result float64) error {
 		})
 
 		if allTasksCompleted {
-			if err := s.UpdateExpressionStatus(task.ExpressionID, models.StatusComplete); err != nil {
+			if err := s.setExpressionStatus(task.ExpressionID, models.StatusComplete); err != nil {
 				s.logger.Error("Failed to update expression status",
 					zap.String("expressionID", task.ExpressionID),
 					zap.Error(err))
@@ -82,21 +314,136 @@ func (s *Storage) UpdateTaskResult(id string, result float64) error {
 	return fmt.Errorf("task not found") // Исправлено на константную строку вместо strings.ToLower
 }
 
-// GetNextTask retrieves and removes the next task from the queue.
+// GetNextTask retrieves and removes the next task from the queue and leases it to the agent:
+// the returned task carries a lease ID and a deadline, and the stored task keeps them until
+// the result arrives or ExpireLeases returns the task to the queue. A task retried after a failed
+// attempt is skipped until its backoff has passed.
 func (s *Storage) GetNextTask() (*models.Task, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.taskQueue) == 0 {
+	now := time.Now()
+	next := slices.IndexFunc(s.taskQueue, func(task models.Task) bool {
+		return !now.Before(task.RetryAt)
+	})
+	if next < 0 {
 		s.logger.Debug("No tasks available in queue")
 		return nil, fmt.Errorf("task not found")
 	}
 
-	task := s.taskQueue[0]
-	s.taskQueue = s.taskQueue[1:]
+	task := s.taskQueue[next]
+	s.taskQueue = slices.Delete(s.taskQueue, next, next+1)
+	task.Attempts++
+	task.LeaseID = uuid.New().String()
+	task.LeaseDeadline = now.Add(s.leaseTTL)
+	leased := task
+	s.tasks.Store(task.ID, &leased)
 
 	s.logger.Info("Next task retrieved from queue",
 		zap.String("id", task.ID),
-		zap.String(constants.FieldExpressionID, task.ExpressionID))
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.String(constants.FieldLeaseID, task.LeaseID),
+		zap.Time("deadline", task.LeaseDeadline),
+		zap.Int("attempt", task.Attempts))
 	return &task, nil
 }
+
+// ExpireLeases fails the attempts of the tasks whose leases ran out by now, oldest deadline first,
+// and reports how many there were: each task is returned to the queue or, when it has no attempts left,
+// moved to the dead-letter list. Results submitted under the expired leases are rejected.
+func (s *Storage) ExpireLeases(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []*models.Task
+	s.tasks.Range(func(_, value interface{}) bool {
+		task := value.(*models.Task)
+		if task.LeaseID != "" && task.Result == nil && !now.Before(task.LeaseDeadline) {
+			expired = append(expired, task)
+		}
+		return true
+	})
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].LeaseDeadline.Before(expired[j].LeaseDeadline)
+	})
+
+	count := 0
+	for _, task := range expired {
+		// Задачу могли отменить в этом же проходе, когда другая задача выражения исчерпала попытки.
+		if current, _ := s.tasks.Load(task.ID); current.(*models.Task).Cancelled {
+			continue
+		}
+		count++
+		s.logger.Warn("Task lease expired",
+			zap.String("id", task.ID),
+			zap.String(constants.FieldExpressionID, task.ExpressionID),
+			zap.String(constants.FieldLeaseID, task.LeaseID))
+		s.failAttempt(task, constants.ErrLeaseTimeout)
+	}
+	return count
+}
+
+// failAttempt releases the lease of a task whose attempt failed for the given reason. The task is queued again
+// after a backoff that doubles with every attempt, or, if it has used up all attempts, moved to the dead-letter list
+// and its expression fails. s.mu must be held.
+func (s *Storage) failAttempt(leased *models.Task, reason string) {
+	now := time.Now()
+	task := *leased
+	task.LeaseID = ""
+	task.LeaseDeadline = time.Time{}
+	task.LastError = reason
+
+	if task.Attempts >= s.maxAttempts {
+		s.tasks.Store(task.ID, &task)
+		s.deadLetter = append(s.deadLetter, models.DeadLetter{
+			TaskID:       task.ID,
+			ExpressionID: task.ExpressionID,
+			Operation:    task.Operation,
+			Attempts:     task.Attempts,
+			Reason:       reason,
+			FailedAt:     now,
+		})
+		s.logger.Error("Task moved to dead-letter list",
+			zap.String("id", task.ID),
+			zap.String(constants.FieldExpressionID, task.ExpressionID),
+			zap.Int("attempts", task.Attempts),
+			zap.String("reason", reason))
+		// Ошибку, с которой выражение уже завершилось, не заменяем: возврат этой задачи её причину не снимет.
+		// Задача, из-за которой выражение завершилось ошибкой, запоминается: её возврат возобновит выражение.
+		suspended := s.suspended[task.ExpressionID]
+		if value, ok := s.expressions.Load(task.ExpressionID); !ok || value.(*models.Expression).Status != models.StatusError {
+			if err := s.setExpressionError(task.ExpressionID, deadLetterError(s.deadLetter[len(s.deadLetter)-1])); err != nil {
+				s.logger.Error("Failed to update expression error status",
+					zap.String(constants.FieldExpressionID, task.ExpressionID),
+					zap.Error(err))
+			} else {
+				suspended.cause = task.ID
+			}
+		}
+		// Выражение уже завершилось ошибкой: остальные его задачи не должны выдаваться агентам.
+		// Выполнявшиеся задачи запоминаются, чтобы RequeueDeadLetter вернул их в очередь.
+		cancelled, running := s.cancelTasks(task.ExpressionID)
+		suspended.tasks = append(suspended.tasks, running...)
+		s.suspended[task.ExpressionID] = suspended
+		s.logger.Info("Expression tasks cancelled",
+			zap.String(constants.FieldExpressionID, task.ExpressionID),
+			zap.Int(constants.FieldCount, cancelled))
+		return
+	}
+
+	delay := s.backoff
+	for i := 1; i < task.Attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	task.RetryAt = now.Add(min(delay, maxRetryDelay))
+	s.tasks.Store(task.ID, &task)
+	s.taskQueue = append(s.taskQueue, task)
+	s.logger.Info("Task returned to queue for retry",
+		zap.String("id", task.ID),
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.Int("attempts", task.Attempts),
+		zap.Time("retryAt", task.RetryAt))
+}
+
+// maxRetryDelay ограничивает паузу между попытками, которая удваивается с каждой попыткой.
+const maxRetryDelay = 5 * time.Minute
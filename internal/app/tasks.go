@@ -0,0 +1,233 @@
+package server
+
+import (
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
+
+	"go.uber.org/zap"
+)
+
+// finishTask передаёт результат вычисленной задачи зависящим от неё условным задачам
+// и завершает выражение, если эта задача была последней. Обычные зависимые задачи
+// хранилище само переводит в очередь, когда готовы все их зависимости.
+func (s *Server) finishTask(task *models.Task) {
+	dependents := s.storage.GetTasksByDependency(task.ID)
+	for _, depTask := range dependents {
+		if depTask.Skipped || depTask.Operation != models.OperationCondition {
+			continue
+		}
+		ready, _ := s.withDependencyResults(depTask)
+		s.resolveCondition(ready)
+	}
+
+	// Выражение завершается, когда вычислены все его задачи. Корневая задача известна с планирования:
+	// у программы задач без зависимых может быть несколько, если результат использует не все привязки.
+	// Результат-массив собирается из результатов задач его элементов.
+	if !s.allTasksFinished(task.ExpressionID) {
+		return
+	}
+	expr, err := s.storage.GetExpression(task.ExpressionID)
+	if err != nil || expr.HasResult() {
+		return
+	}
+	arith := calculation.Arithmetic{Mode: calculation.Mode(task.Mode), Precision: task.Precision}
+	if expr.ResultArray != nil {
+		if err := s.completeArray(task.ExpressionID, arith); err != nil {
+			s.logger.Error(constants.LogFailedUpdateExpr, zap.String(constants.FieldExpressionID, task.ExpressionID), zap.Error(err))
+		}
+		return
+	}
+	rootID := expr.RootTaskID
+	if rootID == "" {
+		rootID = task.ID
+	}
+	result, err := s.storage.GetTaskResult(rootID)
+	if err != nil {
+		s.logger.Error(constants.LogFailedGetTaskResult, zap.String(constants.FieldTaskID, rootID), zap.Error(err))
+		return
+	}
+	if err := s.completeExpression(task.ExpressionID, arith, result); err != nil {
+		s.logger.Error(constants.LogFailedUpdateExpr, zap.String(constants.FieldExpressionID, task.ExpressionID), zap.Error(err))
+	}
+}
+
+// withDependencyResults возвращает копию задачи с подставленными результатами готовых зависимостей
+// и признак того, что готовы все зависимости.
+func (s *Server) withDependencyResults(task *models.Task) (*models.Task, bool) {
+	ready := *task
+	ready.Args = append([]string(nil), task.Args...)
+	allDepsMet := true
+	for i, depID := range task.DependsOnTaskIDs {
+		depResult, err := s.storage.GetTaskResult(depID)
+		if err != nil {
+			allDepsMet = false
+			continue
+		}
+		if i < len(task.DependencySlots) {
+			ready.SetArg(task.DependencySlots[i], depResult)
+		}
+	}
+	return &ready, allDepsMet
+}
+
+// failExpression записывает ошибку в выражение, которому принадлежит задача, и отменяет его невычисленные задачи.
+func (s *Server) failExpression(task *models.Task, message string) {
+	s.logger.Error("Failed to process dependent task",
+		zap.String(constants.FieldTaskID, task.ID),
+		zap.String(constants.FieldExpressionID, task.ExpressionID),
+		zap.String("error", message))
+
+	if updateErr := s.storage.UpdateExpressionError(task.ExpressionID, message); updateErr != nil {
+		s.logger.Error("Failed to update expression error status",
+			zap.String(constants.FieldExpressionID, task.ExpressionID),
+			zap.Error(updateErr))
+	}
+	s.storage.CancelExpressionTasks(task.ExpressionID)
+}
+
+// resolveCondition обрабатывает условную задачу с подставленными результатами зависимостей.
+// Когда становится известно условие, задачи выбранной ветви ставятся в очередь, а задачи другой ветви пропускаются;
+// когда вычислена выбранная ветвь, её значение становится результатом условной задачи.
+func (s *Server) resolveCondition(cond *models.Task) {
+	if !s.isConditionReleased(cond) {
+		return
+	}
+	branch, err := s.selectedBranch(cond)
+	if err != nil {
+		s.failExpression(cond, err.Error())
+		return
+	}
+	if branch == 0 {
+		return
+	}
+
+	stored, err := s.storage.GetTask(cond.ID)
+	if err != nil {
+		s.failExpression(cond, err.Error())
+		return
+	}
+	decided, _ := s.selectedBranch(stored)
+	if err := s.storage.SaveHeldTask(cond); err != nil {
+		s.failExpression(cond, err.Error())
+		return
+	}
+	if decided == 0 {
+		s.logger.Info("Condition resolved",
+			zap.String(constants.FieldTaskID, cond.ID),
+			zap.String(constants.FieldExpressionID, cond.ExpressionID),
+			zap.Int("branch", branch))
+		s.skipBranch(cond, 3-branch)
+		s.startBranch(cond, branch)
+	}
+
+	if !s.isBranchComputed(cond, branch) {
+		return
+	}
+	// Вложенная условная задача, запущенная из startBranch, могла уже записать результат.
+	if _, err := s.storage.GetTaskResult(cond.ID); err == nil {
+		return
+	}
+	if err := s.storage.UpdateTaskResult(cond.ID, cond.Args[branch]); err != nil {
+		s.failExpression(cond, err.Error())
+		return
+	}
+	s.finishTask(cond)
+}
+
+// isConditionReleased сообщает, что условную задачу можно разрешать: она не пропущена, а если вложена в ветвь
+// другой условной задачи, эта ветвь уже выбрана. Вложенное условие может совпадать с внешним и вычисляться
+// той же задачей, поэтому его результат становится известен раньше, чем выбрана ветвь, в которой оно стоит.
+func (s *Server) isConditionReleased(cond *models.Task) bool {
+	stored, err := s.storage.GetTask(cond.ID)
+	if err != nil || stored.Skipped || stored.Cancelled {
+		return false
+	}
+	if cond.ConditionID == "" {
+		return true
+	}
+	outer, err := s.storage.GetTask(cond.ConditionID)
+	if err != nil || outer.Skipped {
+		return false
+	}
+	branch, err := s.selectedBranch(outer)
+	return err == nil && branch == cond.Branch
+}
+
+// selectedBranch возвращает ветвь, выбранную условием задачи: 1 или 2, либо 0, если условие ещё не вычислено.
+func (s *Server) selectedBranch(cond *models.Task) (int, error) {
+	if len(cond.Args) == 0 || cond.Args[0] == "" {
+		return 0, nil
+	}
+	arith := calculation.Arithmetic{Mode: calculation.Mode(cond.Mode), Precision: cond.Precision}
+	value, err := arith.Parse(cond.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	if arith.IsTrue(value) {
+		return 1, nil
+	}
+	return 2, nil
+}
+
+// isBranchComputed сообщает, известно ли значение ветви условной задачи.
+func (s *Server) isBranchComputed(cond *models.Task, branch int) bool {
+	for i, depID := range cond.DependsOnTaskIDs {
+		if cond.DependencySlots[i] != branch {
+			continue
+		}
+		if _, err := s.storage.GetTaskResult(depID); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// startBranch отпускает задачи выбранной ветви: готовые сразу попадают в очередь,
+// остальные — по мере вычисления своих зависимостей; вложенные условные задачи разрешаются.
+func (s *Server) startBranch(cond *models.Task, branch int) {
+	for _, task := range s.storage.GetTasksByExpressionID(cond.ExpressionID) {
+		if task.ConditionID != cond.ID || task.Branch != branch {
+			continue
+		}
+		// Условие вложенной условной задачи могло быть вычислено до выбора ветви.
+		if task.Operation == models.OperationCondition {
+			ready, _ := s.withDependencyResults(task)
+			s.resolveCondition(ready)
+			continue
+		}
+		if err := s.storage.ReleaseTask(task.ID); err != nil {
+			s.failExpression(task, "Failed to release branch task: "+err.Error())
+		}
+	}
+}
+
+// skipBranch пропускает задачи невыбранной ветви вместе с вложенными в неё условными задачами.
+func (s *Server) skipBranch(cond *models.Task, branch int) {
+	for _, task := range s.storage.GetTasksByExpressionID(cond.ExpressionID) {
+		if task.ConditionID != cond.ID || task.Branch != branch {
+			continue
+		}
+		if err := s.storage.SkipTask(task.ID); err != nil {
+			s.failExpression(task, err.Error())
+			continue
+		}
+		if task.Operation == models.OperationCondition {
+			s.skipBranch(task, 1)
+			s.skipBranch(task, 2)
+		}
+	}
+}
+
+// allTasksFinished сообщает, что все задачи выражения вычислены или пропущены.
+func (s *Server) allTasksFinished(expressionID string) bool {
+	for _, task := range s.storage.GetTasksByExpressionID(expressionID) {
+		if task.Skipped {
+			continue
+		}
+		if _, err := s.storage.GetTaskResult(task.ID); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -15,13 +15,92 @@ const (
 	ErrModuloByZero            = "modulo by zero"
 	ErrInvalidModulo           = "modulo operation requires integer operands"
 	ErrUnexpectedEndExpr       = "unexpected end of expression"
-	ErrMissingCloseParen       = "missing closing parenthesis"
+	ErrUnmatchedParentheses    = "invalid expression: unmatched parentheses"
+	ErrEmptyExpression         = "invalid expression: empty expression"
+	ErrInvalidStructure        = "invalid expression: invalid structure"
+	ErrTrailingOperator        = "invalid expression: trailing operator"
+	ErrTooFewTokens            = "invalid expression: too few tokens"
+	ErrUnexpectedCharacter     = "invalid expression: unexpected character"
+	ErrInvalidNumberFormat     = "invalid expression: invalid number format"
+	ErrNumberOutOfRange        = "invalid expression: number out of range"
+	ErrUnsupportedOperation    = "invalid expression: unsupported operation"
+	ErrUnknownFunction         = "invalid expression: unknown function"
+	ErrUnknownVariable         = "invalid expression: unknown variable"
+	ErrWrongArgumentCount      = "invalid expression: wrong number of arguments"
+	ErrNegativeSqrt            = "square root of negative number"
+	ErrInvalidLogarithm        = "logarithm of non-positive number"
+	ErrInvalidLogarithmBase    = "invalid logarithm base"
+	ErrInvalidRoundDigits      = "round precision must be an integer"
+	ErrInvalidMode             = "invalid mode"
+	ErrInvalidPrecision        = "invalid precision"
+	ErrInexactResult           = "result cannot be represented exactly"
+	ErrExponentTooLarge        = "exponent is too large"
+	ErrComplexUnsupported      = "complex numbers are supported only in float64 mode"
+	ErrComplexArgument         = "function does not accept complex arguments"
+	ErrComplexResult           = "result is a complex number"
+	ErrIntegerRequired         = "int64 mode requires integer operands"
+	ErrIntegerOverflow         = "integer overflow"
+	ErrInvalidShift            = "shift count must be between 0 and 63"
+	ErrBitwiseRequiresInt64    = "bitwise operations require int64 mode"
+	ErrComplexComparison       = "complex numbers cannot be ordered"
+	ErrInvalidFunctionName     = "invalid function name"
+	ErrInvalidParameter        = "invalid parameter name"
+	ErrRecursiveFunction       = "recursive function"
+	ErrFunctionNotFound        = "Function not found"
+	ErrFunctionInUse           = "function is used by other functions"
+	ErrTooManyTasks            = "expression is too large"
+	ErrNotDifferentiable       = "expression is not differentiable"
+	ErrInvalidVariable         = "invalid variable name"
+	ErrUnsupportedFormat       = "unsupported format"
+	ErrInvalidBinding          = "invalid expression: invalid binding name"
+	ErrDuplicateBinding        = "invalid expression: duplicate binding"
+	ErrBindingBeforeAssignment = "invalid expression: name used before it is bound"
+	ErrMissingResult           = "invalid expression: program must end with an expression"
+	ErrUnusedStatement         = "invalid expression: only the last statement may be an expression"
+	ErrProgramInFunction       = "function body must be a single expression"
+	ErrInvalidArray            = "invalid expression: invalid array"
+	ErrUnmatchedBrackets       = "invalid expression: unmatched brackets"
+	ErrShapeMismatch           = "shapes do not match"
+	ErrArrayRequired           = "function requires a vector or a matrix"
+	ErrSquareMatrix            = "matrix is not square"
+	ErrScalarRequired          = "value must be a scalar"
+	ErrArrayResult             = "result is an array"
+	ErrNonFiniteResult         = "result is not a finite number"
+	ErrLeaseExpired            = "Task lease expired or is not held"
+	ErrLeaseTimeout            = "agent did not submit the result before the lease expired"
+	ErrAttemptsExhausted       = "task %s failed after %d attempts: %s"
+	ErrDeadLetterNotFound      = "Task not found in dead-letter list"
+	ErrTaskCancelled           = "Task cancelled"
+	ErrExpressionFinished      = "Expression is already finished"
 	ErrFailedProcessExpression = "Failed to process expression"
 	ErrFailedProcessResult     = "Failed to process result"
 	ErrFailedStartServer       = "Failed to start server"
 	ErrServerShutdownFailed    = "Server shutdown failed"
 )
 
+// Parse error codes reported together with the position of the error.
+const (
+	CodeUnexpectedToken      = "UNEXPECTED_TOKEN"
+	CodeUnexpectedEnd        = "UNEXPECTED_END"
+	CodeUnmatchedParenthesis = "UNMATCHED_PARENTHESIS"
+	CodeEmptyExpression      = "EMPTY_EXPRESSION"
+	CodeUnexpectedCharacter  = "UNEXPECTED_CHARACTER"
+	CodeInvalidNumber        = "INVALID_NUMBER"
+	CodeUnknownFunction      = "UNKNOWN_FUNCTION"
+	CodeWrongArgumentCount   = "WRONG_ARGUMENT_COUNT"
+	CodeTooFewTokens         = "TOO_FEW_TOKENS"
+	CodeInvalidBinding       = "INVALID_BINDING"
+	CodeInvalidStatement     = "INVALID_STATEMENT"
+)
+
+// Error codes an agent reports together with the message of a failed task.
+const (
+	CodeDivisionByZero   = "DIVISION_BY_ZERO"
+	CodeInvalidOperation = "INVALID_OPERATION"
+	CodeInvalidArgument  = "INVALID_ARGUMENT"
+	CodeCalculationError = "CALCULATION_ERROR"
+)
+
 // Log messages used for logging application events.
 const (
 	LogTaskRetrieved              = "Task retrieved"
@@ -35,6 +114,7 @@ const (
 	LogFailedGetTaskResult        = "Failed to get task after updating result"
 	LogFailedUpdateExpr           = "Failed to update expression result"
 	LogTaskProcessed              = "Task result processed successfully"
+	LogLeasesExpired              = "Expired task leases returned to queue"
 	LogOrchestratorStarted        = "Orchestrator service started successfully"
 	LogOrchestratorStoppedGrace   = "Orchestrator service stopped gracefully"
 	LogInvalidStatusTransition    = "Invalid status transition"
@@ -52,8 +132,10 @@ const (
 
 // URL paths used for API endpoints.
 const (
-	PathTask         = "/task"
-	PathInternalTask = "%s/internal/task"
+	PathTask             = "/task"
+	PathTaskByID         = "/task/{id}"
+	PathInternalTask     = "%s/internal/task"
+	PathInternalTaskByID = "%s/internal/task/%s"
 )
 
 // Field names used in JSON and other data structures.
@@ -63,6 +145,7 @@ const (
 	FieldExpressionID    = "expressionID"
 	FieldOperation       = "operation"
 	FieldTaskID          = "taskID"
+	FieldLeaseID         = "leaseID"
 	FieldNewStatus       = "newStatus"
 	FieldOldStatus       = "oldStatus"
 	FieldToken           = "token"
@@ -1,31 +1,88 @@
 package worker
 
 import (
-	"distributed_calculator/internal/constants"
 	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/app/planner"
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
 
 	"go.uber.org/zap"
 )
 
-func (a *Agent) Calculate(task *models.Task) float64 {
-	switch task.Operation {
-	case "+":
-		return task.Arg1 + task.Arg2
-	case "-":
-		return task.Arg1 - task.Arg2
-	case "*":
-		return task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 == 0 {
-			a.logger.Error(constants.ErrDivisionByZero,
-				zap.String(constants.FieldTaskID, task.ID))
-			panic(constants.ErrDivisionByZero)
+// TaskError — ошибка выполнения задачи. Агент сообщает её оркестратору вместо результата.
+type TaskError struct {
+	Code    string // Код ошибки, например DIVISION_BY_ZERO.
+	Message string // Текст ошибки.
+}
+
+func (e *TaskError) Error() string {
+	return e.Message
+}
+
+// Calculate выполняет операцию задачи в её числовом режиме и возвращает результат в текстовом виде.
+// Ошибка вычисления возвращается как *TaskError.
+func (a *Agent) Calculate(task *models.Task) (string, error) {
+	arith := calculation.Arithmetic{Mode: calculation.Mode(task.Mode), Precision: task.Precision}
+	if err := arith.Validate(); err != nil {
+		return "", a.fail(task, constants.CodeInvalidOperation, err.Error())
+	}
+
+	var (
+		result calculation.Number
+		err    error
+	)
+	if planner.IsOperator(task.Operation) {
+		x, parseErr := a.parseArg(task, arith, task.Arg1)
+		if parseErr != nil {
+			return "", parseErr
+		}
+		y, parseErr := a.parseArg(task, arith, task.Arg2)
+		if parseErr != nil {
+			return "", parseErr
+		}
+		result, err = arith.Apply(task.Operation, x, y)
+	} else if _, ok := calculation.LookupFunction(task.Operation); ok {
+		args := make([]calculation.Number, len(task.Args))
+		for i, arg := range task.Args {
+			if args[i], err = a.parseArg(task, arith, arg); err != nil {
+				return "", err
+			}
 		}
-		return task.Arg1 / task.Arg2
+		result, err = arith.Call(task.Operation, args)
+	} else {
+		return "", a.fail(task, constants.CodeInvalidOperation, constants.ErrUnexpectedToken)
+	}
+	if err != nil {
+		return "", a.fail(task, errorCode(err.Error()), err.Error())
+	}
+
+	return result.String(), nil
+}
+
+// parseArg разбирает аргумент задачи в числовом режиме задачи.
+func (a *Agent) parseArg(task *models.Task, arith calculation.Arithmetic, text string) (calculation.Number, error) {
+	value, err := arith.Parse(text)
+	if err != nil {
+		return nil, a.fail(task, constants.CodeInvalidArgument, err.Error())
+	}
+	return value, nil
+}
+
+// errorCode выбирает код для ошибки вычисления по её тексту.
+func errorCode(message string) string {
+	switch message {
+	case constants.ErrDivisionByZero, constants.ErrModuloByZero:
+		return constants.CodeDivisionByZero
 	default:
-		a.logger.Error(constants.ErrUnexpectedToken,
-			zap.String(constants.FieldTaskID, task.ID),
-			zap.String(constants.FieldOperation, task.Operation))
-		panic(constants.ErrUnexpectedToken)
+		return constants.CodeCalculationError
 	}
 }
+
+// fail записывает ошибку вычисления в лог и возвращает её для отправки оркестратору.
+func (a *Agent) fail(task *models.Task, code, message string) error {
+	a.logger.Error(message,
+		zap.String(constants.FieldTaskID, task.ID),
+		zap.String(constants.FieldOperation, task.Operation),
+		zap.String("code", code))
+	return &TaskError{Code: code, Message: message}
+}
@@ -40,12 +40,8 @@ func (a *Agent) getTask() (*models.Task, error) {
 	return &taskResp.Task, nil
 }
 
-func (a *Agent) sendResult(taskID string, result float64) error {
-	taskResult := models.TaskResult{
-		ID:     taskID,
-		Result: result,
-	}
-
+// sendResult отправляет результат задачи или ошибку её вычисления вместе с арендой, под которой она была получена.
+func (a *Agent) sendResult(taskResult models.TaskResult) error {
 	body, err := json.Marshal(taskResult)
 	if err != nil {
 		return err
@@ -65,9 +61,38 @@ func (a *Agent) sendResult(taskID string, result float64) error {
 		}
 	}()
 
+	// Выражение задачи отменено или завершилось ошибкой: результат больше не нужен.
+	if resp.StatusCode == http.StatusGone {
+		a.logger.Info("Task cancelled, result discarded",
+			zap.String(constants.FieldTaskID, taskResult.ID))
+		return nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf(constants.ErrUnexpectedStatusCode, resp.StatusCode)
 	}
 
 	return nil
 }
+
+// isCancelled спрашивает у оркестратора, не отменена ли задача.
+func (a *Agent) isCancelled(task *models.Task) (bool, error) {
+	resp, err := a.httpClient.Get(fmt.Sprintf(constants.PathInternalTaskByID, a.config.OrchestratorURL, task.ID))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			a.logger.Error(constants.ErrFailedCloseRespBody, zap.Error(err))
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusGone:
+		return true, nil
+	default:
+		return false, fmt.Errorf(constants.ErrUnexpectedStatusCode, resp.StatusCode)
+	}
+}
@@ -1,10 +1,13 @@
 package worker
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
+	"distributed_calculator/internal/app/models"
 	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
 	"go.uber.org/zap"
 )
 
@@ -44,26 +47,92 @@ func (a *Agent) processTask(workerID int) error {
 		zap.String(constants.FieldTaskID, task.ID),
 		zap.String(constants.FieldOperation, task.Operation))
 
-	var operationTime time.Duration = 100 * time.Millisecond
-
-	switch task.Operation {
-	case "+":
-		operationTime = 1000 * time.Millisecond
-	case "-":
-		operationTime = 1000 * time.Millisecond
-	case "*":
-		operationTime = 1000 * time.Millisecond
-	case "/":
-		operationTime = 1000 * time.Millisecond
+	if !a.simulate(task, a.operationTime(task.Operation)) {
+		return nil
 	}
 
-	time.Sleep(operationTime)
-
-	result := a.Calculate(task)
+	taskResult := models.TaskResult{ID: task.ID, LeaseID: task.LeaseID}
+	if result, err := a.Calculate(task); err != nil {
+		// Ошибка вычисления не прерывает агента: оркестратор завершает выражение с этой ошибкой.
+		taskResult.Error, taskResult.Code = err.Error(), constants.CodeCalculationError
+		var taskErr *TaskError
+		if errors.As(err, &taskErr) {
+			taskResult.Code = taskErr.Code
+		}
+	} else {
+		taskResult.Result = result
+	}
 
-	if err := a.sendResult(task.ID, result); err != nil {
+	// Если аренда истекла, оркестратор отклонит результат и выдаст задачу другому агенту.
+	if err := a.sendResult(taskResult); err != nil {
 		return fmt.Errorf(constants.ErrFormatWithWrap, constants.LogFailedSendResult, err)
 	}
 
 	return nil
 }
+
+// simulate имитирует вычисление задачи в течение d и каждые CancelCheckMS проверяет у оркестратора,
+// не отменена ли задача. Возвращает false, если задача отменена или агент остановлен: тогда результат не нужен.
+func (a *Agent) simulate(task *models.Task, d time.Duration) bool {
+	done := time.NewTimer(d)
+	defer done.Stop()
+
+	var check <-chan time.Time
+	if a.config.CancelCheckMS > 0 {
+		ticker := time.NewTicker(time.Duration(a.config.CancelCheckMS) * time.Millisecond)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return false
+		case <-done.C:
+			return true
+		case <-check:
+			cancelled, err := a.isCancelled(task)
+			if err != nil {
+				a.logger.Warn("Failed to check task cancellation",
+					zap.String(constants.FieldTaskID, task.ID),
+					zap.Error(err))
+				continue
+			}
+			if cancelled {
+				a.logger.Info("Task cancelled, calculation aborted",
+					zap.String(constants.FieldTaskID, task.ID))
+				return false
+			}
+		}
+	}
+}
+
+// operationTime возвращает время имитации вычисления для операции.
+func (a *Agent) operationTime(op string) time.Duration {
+	var ms int64 = 100
+
+	switch op {
+	case "+":
+		ms = a.config.AdditionTimeMS
+	case "-":
+		ms = a.config.SubtractionTimeMS
+	case "*":
+		ms = a.config.MultiplyTimeMS
+	case "/", "//":
+		ms = a.config.DivisionTimeMS
+	case "^":
+		ms = a.config.PowerTimeMS
+	case "%":
+		ms = a.config.ModuloTimeMS
+	case "&", "|", "xor", "<<", ">>":
+		ms = a.config.BitwiseTimeMS
+	case "==", "!=", "<", "<=", ">", ">=", "and", "or":
+		ms = a.config.ComparisonTimeMS
+	default:
+		if fn, ok := calculation.LookupFunction(op); ok {
+			ms = a.config.FunctionTimeMS * fn.Cost
+		}
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
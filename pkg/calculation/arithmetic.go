@@ -0,0 +1,719 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"slices"
+	"strconv"
+
+	"distributed_calculator/internal/constants"
+)
+
+// maxComplexIntPower limits integer exponents of complex numbers computed by repeated squaring.
+const maxComplexIntPower = 1024
+
+// maxExactExponent limits integer exponents in exact modes, where the size of the result grows with the exponent.
+const maxExactExponent = 10000
+
+// Arithmetic performs operations in the number system selected by Mode.
+// The zero value evaluates in float64 mode.
+type Arithmetic struct {
+	Mode      Mode // Number system; empty means float64.
+	Precision int  // Fractional digits in decimal mode; zero means DefaultDecimalPrecision.
+}
+
+// Validate checks that the mode and the precision are supported.
+func (a Arithmetic) Validate() error {
+	switch a.mode() {
+	case ModeFloat64, ModeRational, ModeInt64:
+	case ModeDecimal:
+		if a.Precision < 0 || a.Precision > MaxDecimalPrecision {
+			return fmt.Errorf("%s: expected 0 to %d digits, got %d",
+				constants.ErrInvalidPrecision, MaxDecimalPrecision, a.Precision)
+		}
+	default:
+		return fmt.Errorf("%s '%s'", constants.ErrInvalidMode, a.Mode)
+	}
+	return nil
+}
+
+// IsExact reports whether values are kept exactly rather than as float64.
+func (a Arithmetic) IsExact() bool {
+	return a.mode() != ModeFloat64
+}
+
+// CheckOperation reports whether a unary or binary operator is available in the mode.
+func (a Arithmetic) CheckOperation(op string) error {
+	if isBitwise(op) && a.mode() != ModeInt64 {
+		return fmt.Errorf("%s: %s", constants.ErrBitwiseRequiresInt64, op)
+	}
+	return nil
+}
+
+// Parse converts a numeric literal or a serialized value into a number.
+func (a Arithmetic) Parse(text string) (Number, error) {
+	if a.mode() == ModeInt64 {
+		return parseInt(text)
+	}
+	if !a.IsExact() {
+		if value, err := strconv.ParseFloat(text, 64); err == nil {
+			return finite(floatNumber(value), nil)
+		}
+		value, err := strconv.ParseComplex(text, 128)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", constants.ErrInvalidNumberFormat, text)
+		}
+		return finite(newComplex(value), nil)
+	}
+	value, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", constants.ErrInvalidNumberFormat, text)
+	}
+	return a.wrap(value), nil
+}
+
+// CheckLiterals reports the first numeric literal of the tree that the number system cannot hold as a parse error
+// at the literal. Only float64 mode limits the magnitude of literals: the exact modes read them exactly,
+// and int64 mode reports an overflow when the literal is evaluated.
+func (a Arithmetic) CheckLiterals(expression string, node Node) error {
+	if a.IsExact() {
+		return nil
+	}
+	var err *ParseError
+	Walk(node, func(node Node) bool {
+		if n, ok := node.(*NumberNode); ok && err == nil && math.IsInf(n.Value, 0) {
+			err = newParseError(expression, constants.CodeInvalidNumber, "",
+				Token{Kind: TokenNumber, Text: n.Literal, Pos: n.Position})
+			err.Message = fmt.Sprintf("%s '%s' at column %d", constants.ErrNumberOutOfRange, err.Token, err.Column())
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FromFloat converts a float64, e.g. a variable binding, into a number.
+// In exact modes the shortest decimal representation is used, so 0.1 becomes exactly 1/10;
+// int64 mode rejects values with a fractional part. Infinities and NaN are rejected in every mode.
+func (a Arithmetic) FromFloat(value float64) (Number, error) {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil, errors.New(constants.ErrNonFiniteResult)
+	}
+	if a.mode() == ModeInt64 {
+		return floatToInt(value)
+	}
+	if !a.IsExact() {
+		return floatNumber(value), nil
+	}
+	rat, _ := new(big.Rat).SetString(strconv.FormatFloat(value, 'g', -1, 64))
+	return a.wrap(rat), nil
+}
+
+// Constant returns the value of a built-in constant, pi or e. Decimal mode computes it to the precision
+// of the mode; rational and int64 modes reject it, because the constants are irrational.
+func (a Arithmetic) Constant(name string) (Number, error) {
+	value, ok := builtinConstants[name]
+	if !ok {
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownVariable, name)
+	}
+	switch a.mode() {
+	case ModeFloat64:
+		return floatNumber(value), nil
+	case ModeDecimal:
+		constant := bigE
+		if name == "pi" {
+			constant = bigPi
+		}
+		rat, _ := constant(a.workingPrec()).Rat(nil)
+		return a.wrap(rat), nil
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrInexactResult, name)
+	}
+}
+
+// Unary applies a prefix operator: negation, logical not or, in int64 mode, bitwise complement.
+func (a Arithmetic) Unary(op string, x Number) (Number, error) {
+	if err := a.CheckOperation(op); err != nil {
+		return nil, err
+	}
+	if isArray(x) {
+		return mapElements([]Number{x}, func(xs []Number) (Number, error) { return a.Unary(op, xs[0]) })
+	}
+	if op == "not" {
+		return a.boolean(!a.IsTrue(x)), nil
+	}
+	if n, ok := x.(intNumber); ok {
+		return unaryInt(op, int64(n))
+	}
+	if op != "-" {
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+	if !a.IsExact() {
+		return newComplex(-ToComplex(x)), nil
+	}
+	return a.wrap(new(big.Rat).Neg(a.rat(x))), nil
+}
+
+// Apply performs a single binary operation.
+func (a Arithmetic) Apply(op string, left, right Number) (Number, error) {
+	if err := a.CheckOperation(op); err != nil {
+		return nil, err
+	}
+	if isArray(left) || isArray(right) {
+		return mapElements([]Number{left, right}, func(xs []Number) (Number, error) { return a.Apply(op, xs[0], xs[1]) })
+	}
+	if isComparison(op) {
+		return a.compare(op, left, right)
+	}
+	if isLogical(op) {
+		return a.logic(op, left, right), nil
+	}
+	if a.mode() == ModeInt64 {
+		x, err := a.integer(left)
+		if err != nil {
+			return nil, err
+		}
+		y, err := a.integer(right)
+		if err != nil {
+			return nil, err
+		}
+		return applyInt(op, x, y)
+	}
+	if !a.IsExact() {
+		// Дробная степень отрицательного числа, как и sqrt(-4), имеет только комплексное значение.
+		negativeRoot := op == "^" && left.Float64() < 0 && right.Float64() != math.Trunc(right.Float64())
+		if isComplex(left) || isComplex(right) || negativeRoot {
+			return finite(applyComplex(op, ToComplex(left), ToComplex(right)))
+		}
+		value, err := apply(op, left.Float64(), right.Float64())
+		if err != nil {
+			return nil, err
+		}
+		return finite(floatNumber(value), nil)
+	}
+
+	x, y := a.rat(left), a.rat(right)
+	switch op {
+	case "+":
+		return a.wrap(new(big.Rat).Add(x, y)), nil
+	case "-":
+		return a.wrap(new(big.Rat).Sub(x, y)), nil
+	case "*":
+		return a.wrap(new(big.Rat).Mul(x, y)), nil
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return a.wrap(new(big.Rat).Quo(x, y)), nil
+	case "//":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		quotient := new(big.Rat).Quo(x, y)
+		// Знаменатель big.Rat положителен, поэтому евклидово деление совпадает с округлением вниз.
+		return a.wrap(new(big.Rat).SetInt(new(big.Int).Div(quotient.Num(), quotient.Denom()))), nil
+	case "%":
+		if y.Sign() == 0 {
+			return nil, errors.New(constants.ErrModuloByZero)
+		}
+		if !x.IsInt() || !y.IsInt() {
+			return nil, errors.New(constants.ErrInvalidModulo)
+		}
+		// Rem сохраняет знак делимого, как и math.Mod в режиме float64.
+		return a.wrap(new(big.Rat).SetInt(new(big.Int).Rem(x.Num(), y.Num()))), nil
+	case "^":
+		return a.power(x, y)
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// Call invokes a built-in function.
+// In exact modes abs, min, max, round, sum, mean, dot and det are computed exactly and sqrt is exact for perfect
+// squares; the remaining functions are computed to the precision of decimal mode and rejected in rational mode.
+func (a Arithmetic) Call(name string, args []Number) (Number, error) {
+	fn, ok := LookupFunction(name)
+	if !ok {
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownFunction, name)
+	}
+	if err := fn.CheckArity(len(args)); err != nil {
+		return nil, err
+	}
+	if fn.Eval == nil || slices.ContainsFunc(args, isArray) {
+		return a.callArray(fn, args)
+	}
+
+	if !a.IsExact() {
+		return finite(callFloat(fn, args))
+	}
+	if a.mode() == ModeInt64 {
+		// Функции вычисляются точно в рациональных числах; результат обязан быть целым.
+		result, err := Arithmetic{Mode: ModeRational}.Call(name, args)
+		if err != nil {
+			return nil, err
+		}
+		return ratToInt(result.(Rational).value())
+	}
+
+	rats := make([]*big.Rat, len(args))
+	for i, arg := range args {
+		rats[i] = a.rat(arg)
+	}
+
+	switch fn.Name {
+	case "abs":
+		return a.wrap(new(big.Rat).Abs(rats[0])), nil
+	case "min", "max":
+		result := rats[0]
+		for _, rat := range rats[1:] {
+			if (fn.Name == "min" && rat.Cmp(result) < 0) || (fn.Name == "max" && rat.Cmp(result) > 0) {
+				result = rat
+			}
+		}
+		return a.wrap(new(big.Rat).Set(result)), nil
+	case "round":
+		digits := 0
+		if len(rats) == 2 {
+			if !rats[1].IsInt() || rats[1].Num().CmpAbs(big.NewInt(MaxDecimalPrecision)) > 0 {
+				return nil, errors.New(constants.ErrInvalidRoundDigits)
+			}
+			digits = int(rats[1].Num().Int64())
+		}
+		return a.wrap(roundRat(rats[0], digits)), nil
+	case "sqrt":
+		return a.sqrt(rats[0])
+	case "sum", "mean":
+		total := new(big.Rat)
+		for _, rat := range rats {
+			total.Add(total, rat)
+		}
+		if fn.Name == "mean" {
+			total.Quo(total, big.NewRat(int64(len(rats)), 1))
+		}
+		return a.wrap(total), nil
+	case "dot":
+		if len(rats)%2 != 0 {
+			return nil, fmt.Errorf("%s: dot expects two vectors of the same length", constants.ErrShapeMismatch)
+		}
+		half, total := len(rats)/2, new(big.Rat)
+		for i := 0; i < half; i++ {
+			total.Add(total, new(big.Rat).Mul(rats[i], rats[half+i]))
+		}
+		return a.wrap(total), nil
+	case "det":
+		det, err := ratDeterminant(rats)
+		if err != nil {
+			return nil, err
+		}
+		return a.wrap(det), nil
+	}
+
+	if a.mode() == ModeRational {
+		return nil, fmt.Errorf("%s: %s", constants.ErrInexactResult, fn.Name)
+	}
+	return a.approximate(fn.Name, rats)
+}
+
+// Evaluate walks an abstract syntax tree and computes its value.
+func (a Arithmetic) Evaluate(node Node, variables map[string]float64) (Number, error) {
+	return a.evaluate(node, variables, nil)
+}
+
+// EvaluateProgram evaluates the bindings of a program in order and then its result.
+// It returns the value of every binding in the order of the statements together with the result.
+func (a Arithmetic) EvaluateProgram(program *ProgramNode, variables map[string]float64) ([]Number, Number, error) {
+	bound := make(map[string]Number, len(program.Bindings))
+	values := make([]Number, len(program.Bindings))
+	for i, binding := range program.Bindings {
+		value, err := a.evaluate(binding.Value, variables, bound)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", binding.Name, err)
+		}
+		bound[binding.Name] = value
+		values[i] = value
+	}
+	result, err := a.evaluate(program.Result, variables, bound)
+	if err != nil {
+		return nil, nil, err
+	}
+	return values, result, nil
+}
+
+// evaluate computes the value of the tree; names bound by the program shadow the variables.
+func (a Arithmetic) evaluate(node Node, variables map[string]float64, bound map[string]Number) (Number, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		if n.Imaginary && a.IsExact() {
+			return nil, errors.New(constants.ErrComplexUnsupported)
+		}
+		if a.IsExact() {
+			return a.Parse(decimalLiteral(n.Literal))
+		}
+		if math.IsInf(n.Value, 0) {
+			return nil, fmt.Errorf("%s: %s", constants.ErrNumberOutOfRange, n.Literal)
+		}
+		if n.Imaginary {
+			return newComplex(complex(0, n.Value)), nil
+		}
+		return floatNumber(n.Value), nil
+	case *IdentNode:
+		if value, ok := bound[n.Name]; ok {
+			return value, nil
+		}
+		value, ok := variables[n.Name]
+		if !ok {
+			if IsConstant(n.Name) {
+				return a.Constant(n.Name)
+			}
+			return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownVariable, n.Name)
+		}
+		return a.FromFloat(value)
+	case *GroupNode:
+		return a.evaluate(n.Inner, variables, bound)
+	case *UnaryNode:
+		operand, err := a.evaluate(n.Operand, variables, bound)
+		if err != nil {
+			return nil, err
+		}
+		return a.Unary(n.Op, operand)
+	case *BinaryNode:
+		left, err := a.evaluate(n.Left, variables, bound)
+		if err != nil {
+			return nil, err
+		}
+		right, err := a.evaluate(n.Right, variables, bound)
+		if err != nil {
+			return nil, err
+		}
+		return a.Apply(n.Op, left, right)
+	case *CallNode:
+		args := make([]Number, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := a.evaluate(arg, variables, bound)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		// Функции массивов получают массивы целиком, а не развёрнутые списки элементов, как у агентов.
+		if fn, ok := LookupFunction(n.Name); ok && IsArrayFunction(fn.Name) {
+			if err := fn.CheckArity(len(args)); err != nil {
+				return nil, err
+			}
+			return a.callArray(fn, args)
+		}
+		return a.Call(n.Name, args)
+	case *ListNode:
+		items := make([]Number, len(n.Elements))
+		for i, element := range n.Elements {
+			value, err := a.evaluate(element, variables, bound)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return newList(items)
+	case *ConditionalNode:
+		cond, err := a.evaluate(n.Cond, variables, bound)
+		if err != nil {
+			return nil, err
+		}
+		if isArray(cond) {
+			return nil, fmt.Errorf("%s: condition", constants.ErrScalarRequired)
+		}
+		if a.IsTrue(cond) {
+			return a.evaluate(n.Then, variables, bound)
+		}
+		return a.evaluate(n.Else, variables, bound)
+	case *ProgramNode:
+		_, result, err := a.EvaluateProgram(n, variables)
+		return result, err
+	default:
+		return nil, fmt.Errorf("%s: %T", constants.ErrUnexpectedToken, node)
+	}
+}
+
+// power raises x to the power y; non-integer exponents are computed to the precision of decimal mode
+// and rejected in rational mode.
+func (a Arithmetic) power(x, y *big.Rat) (Number, error) {
+	if new(big.Rat).Abs(y).Cmp(big.NewRat(maxExactExponent, 1)) > 0 {
+		return nil, fmt.Errorf("%s: %s", constants.ErrExponentTooLarge, y.RatString())
+	}
+	if !y.IsInt() {
+		if a.mode() == ModeRational {
+			return nil, fmt.Errorf("%s: ^", constants.ErrInexactResult)
+		}
+		return a.approximatePower(x, y)
+	}
+
+	exponent := y.Num().Int64()
+	if exponent < 0 {
+		if x.Sign() == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		x = new(big.Rat).Inv(x)
+		exponent = -exponent
+	}
+	num := new(big.Int).Exp(x.Num(), big.NewInt(exponent), nil)
+	den := new(big.Int).Exp(x.Denom(), big.NewInt(exponent), nil)
+	return a.wrap(new(big.Rat).SetFrac(num, den)), nil
+}
+
+// sqrt computes a square root: exactly for perfect squares, otherwise to the decimal precision.
+func (a Arithmetic) sqrt(x *big.Rat) (Number, error) {
+	if x.Sign() < 0 {
+		return nil, errors.New(constants.ErrNegativeSqrt)
+	}
+	num, numExact := intSqrt(x.Num())
+	den, denExact := intSqrt(x.Denom())
+	if numExact && denExact {
+		return a.wrap(new(big.Rat).SetFrac(num, den)), nil
+	}
+	if a.mode() == ModeRational {
+		return nil, fmt.Errorf("%s: sqrt", constants.ErrInexactResult)
+	}
+
+	root := new(big.Float).SetPrec(a.workingPrec()).SetRat(x)
+	root.Sqrt(root)
+	rat, _ := root.Rat(nil)
+	return a.wrap(rat), nil
+}
+
+// approximate computes sin, cos or log in decimal mode to the precision of the mode.
+func (a Arithmetic) approximate(name string, args []*big.Rat) (Number, error) {
+	prec := a.workingPrec()
+	var result *big.Float
+	switch name {
+	case "sin", "cos":
+		result = bigSinCos(ratFloat(args[0], prec), prec, name == "cos")
+	case "log":
+		if args[0].Sign() <= 0 {
+			return nil, errors.New(constants.ErrInvalidLogarithm)
+		}
+		result = bigLog(ratFloat(args[0], prec), prec)
+		if len(args) == 2 {
+			if args[1].Sign() <= 0 || args[1].Cmp(big.NewRat(1, 1)) == 0 {
+				return nil, errors.New(constants.ErrInvalidLogarithmBase)
+			}
+			result.Quo(result, bigLog(ratFloat(args[1], prec), prec))
+		}
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrInexactResult, name)
+	}
+	rat, _ := result.Rat(nil)
+	return a.wrap(rat), nil
+}
+
+// maxPowerBits limits the magnitude of a power with a non-integer exponent, 2^maxPowerBits,
+// which decimal mode computes with all its integer digits.
+const maxPowerBits = 1 << 16
+
+// approximatePower computes x^y = e^(y·ln x) for a non-integer y in decimal mode to the precision of the mode.
+func (a Arithmetic) approximatePower(x, y *big.Rat) (Number, error) {
+	switch x.Sign() {
+	case -1:
+		return nil, errors.New(constants.ErrComplexUnsupported)
+	case 0:
+		if y.Sign() < 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return a.wrap(new(big.Rat)), nil
+	}
+
+	// Оценка двоичного порядка результата: столько же бит нужно сверх точности режима для его целой части.
+	mant := new(big.Float)
+	binaryExp := new(big.Float).SetRat(x).MantExp(mant)
+	fy, _ := y.Float64()
+	fm, _ := mant.Float64()
+	magnitude := fy * (float64(binaryExp) + math.Log2(fm))
+	if magnitude > maxPowerBits {
+		return nil, fmt.Errorf("%s: %s", constants.ErrExponentTooLarge, y.RatString())
+	}
+	prec := a.workingPrec() + uint(max(magnitude, 0))
+	exponent := bigLog(ratFloat(x, prec), prec)
+	exponent.Mul(exponent, ratFloat(y, prec))
+	rat, _ := bigExp(exponent, prec).Rat(nil)
+	return a.wrap(rat), nil
+}
+
+// callFloat invokes a function in float64 mode. Complex arguments, and real arguments outside
+// the real domain of the function, e.g. sqrt(-4), are evaluated with its complex implementation.
+func callFloat(fn Function, args []Number) (Number, error) {
+	values := make([]complex128, len(args))
+	complexArgs := false
+	for i, arg := range args {
+		values[i] = ToComplex(arg)
+		complexArgs = complexArgs || isComplex(arg)
+	}
+
+	if !complexArgs {
+		reals := make([]float64, len(args))
+		for i, arg := range args {
+			reals[i] = arg.Float64()
+		}
+		value, err := fn.Eval(reals)
+		if err == nil || fn.Complex == nil {
+			return floatNumber(value), err
+		}
+		// Для аргументов вне действительной области пробуем комплексное значение.
+		result, complexErr := fn.Complex(values)
+		if complexErr != nil || cmplx.IsInf(result) || cmplx.IsNaN(result) {
+			return nil, err
+		}
+		return newComplex(result), nil
+	}
+
+	if fn.Complex == nil {
+		return nil, fmt.Errorf("%s: %s", constants.ErrComplexArgument, fn.Name)
+	}
+	result, err := fn.Complex(values)
+	if err != nil {
+		return nil, err
+	}
+	return newComplex(result), nil
+}
+
+// applyComplex performs a single binary operation on complex numbers.
+func applyComplex(op string, left, right complex128) (Number, error) {
+	switch op {
+	case "+":
+		return newComplex(left + right), nil
+	case "-":
+		return newComplex(left - right), nil
+	case "*":
+		return newComplex(left * right), nil
+	case "/":
+		if right == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		return newComplex(left / right), nil
+	case "^":
+		// Целые степени вычисляются умножением, чтобы (2i)^2 давало ровно -4.
+		if n := real(right); imag(right) == 0 && n == math.Trunc(n) && math.Abs(n) <= maxComplexIntPower {
+			return newComplex(complexIntPow(left, int(n))), nil
+		}
+		if imag(left) == 0 && real(left) < 0 && imag(right) == 0 {
+			return newComplex(negativePow(real(left), real(right))), nil
+		}
+		return newComplex(cmplx.Pow(left, right)), nil
+	case "%":
+		return nil, errors.New(constants.ErrInvalidModulo)
+	case "//":
+		return nil, fmt.Errorf("%s '%s'", constants.ErrUnsupportedOperation, op)
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// negativePow returns the principal value of x^y for a negative real x, |x|^y * e^(iπy).
+// Exponents that are multiples of 1/2 give exact axis directions, so (-4)^0.5 is exactly 2i.
+func negativePow(x, y float64) complex128 {
+	magnitude := math.Pow(-x, y)
+	turn := math.Mod(y, 2)
+	if half := 2 * turn; half == math.Trunc(half) {
+		// Поворот на целое число четвертей окружности: cos и sin равны 0 или ±1.
+		quarter := int(half+4) % 4
+		return complex(magnitude*[]float64{1, 0, -1, 0}[quarter], magnitude*[]float64{0, 1, 0, -1}[quarter])
+	}
+	sin, cos := math.Sincos(math.Pi * turn)
+	return complex(magnitude*cos, magnitude*sin)
+}
+
+// complexIntPow raises x to an integer power by repeated squaring.
+func complexIntPow(x complex128, n int) complex128 {
+	if n < 0 {
+		return 1 / complexIntPow(x, -n)
+	}
+	result := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= x
+		}
+		x *= x
+	}
+	return result
+}
+
+// finite rejects an infinite or NaN float64 result, e.g. of 10^400 or 0^-1, which has no JSON representation.
+func finite(n Number, err error) (Number, error) {
+	if err != nil {
+		return nil, err
+	}
+	value := ToComplex(n)
+	if cmplx.IsInf(value) || cmplx.IsNaN(value) {
+		return nil, errors.New(constants.ErrNonFiniteResult)
+	}
+	return n, nil
+}
+
+// isComplex reports whether a number has a non-zero imaginary part.
+func isComplex(n Number) bool {
+	_, ok := n.(complexNumber)
+	return ok
+}
+
+// mode returns the effective mode.
+func (a Arithmetic) mode() Mode {
+	if a.Mode == "" {
+		return ModeFloat64
+	}
+	return a.Mode
+}
+
+// digits returns the effective number of fractional digits in decimal mode.
+func (a Arithmetic) digits() int {
+	if a.Precision == 0 {
+		return DefaultDecimalPrecision
+	}
+	return a.Precision
+}
+
+// workingPrec returns the mantissa size in bits for approximations in decimal mode.
+func (a Arithmetic) workingPrec() uint {
+	// Запас в 64 бита гарантирует корректное округление до нужного числа знаков.
+	return uint(float64(a.digits())*3.33) + 64
+}
+
+// integer returns the value of a number in int64 mode.
+func (a Arithmetic) integer(x Number) (int64, error) {
+	if n, ok := x.(intNumber); ok {
+		return int64(n), nil
+	}
+	n, err := parseInt(x.String())
+	if err != nil {
+		return 0, err
+	}
+	return int64(n.(intNumber)), nil
+}
+
+// wrap converts an exact intermediate value into a number of the current mode.
+func (a Arithmetic) wrap(x *big.Rat) Number {
+	if a.mode() == ModeDecimal {
+		return decimalNumber{rat: roundRat(x, a.digits()), digits: a.digits()}
+	}
+	return Rational{rat: x}
+}
+
+// rat returns the exact value of a number.
+func (a Arithmetic) rat(x Number) *big.Rat {
+	switch n := x.(type) {
+	case decimalNumber:
+		return n.rat
+	case Rational:
+		return n.value()
+	default:
+		rat, _ := new(big.Rat).SetString(x.String())
+		return rat
+	}
+}
+
+// intSqrt returns the integer square root of n and whether it is exact.
+func intSqrt(n *big.Int) (*big.Int, bool) {
+	root := new(big.Int).Sqrt(n)
+	return root, new(big.Int).Mul(root, root).Cmp(n) == 0
+}
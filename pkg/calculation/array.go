@@ -0,0 +1,427 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+
+	"distributed_calculator/internal/constants"
+)
+
+// Array is a vector or a matrix of scalars, e.g. [1, 2, 3] or [[1, 2], [3, 4]].
+// It is a Number, so arrays flow through the evaluator like scalars: operators and scalar functions
+// apply to arrays element by element, and the array functions below reduce or combine them.
+type Array struct {
+	Shape    []int    // Length of a vector, or the numbers of rows and columns of a matrix.
+	Elements []Number // Scalar elements row by row.
+}
+
+// String formats the array as nested lists, e.g. [[1, 2], [3, 4]].
+func (v *Array) String() string {
+	var b strings.Builder
+	rowLength := v.Shape[len(v.Shape)-1]
+	if len(v.Shape) == 2 {
+		b.WriteByte('[')
+	}
+	for i, element := range v.Elements {
+		switch {
+		case i%rowLength == 0 && i > 0:
+			b.WriteString("], [")
+		case i%rowLength == 0:
+			b.WriteByte('[')
+		default:
+			b.WriteString(", ")
+		}
+		b.WriteString(element.String())
+	}
+	b.WriteByte(']')
+	if len(v.Shape) == 2 {
+		b.WriteByte(']')
+	}
+	return b.String()
+}
+
+// Float64 returns NaN: an array has no single numeric value.
+func (v *Array) Float64() float64 {
+	return math.NaN()
+}
+
+// isArray reports whether the number is a vector or a matrix.
+func isArray(n Number) bool {
+	_, ok := n.(*Array)
+	return ok
+}
+
+// shapeOf returns the shape of an array; scalars have no shape.
+func shapeOf(n Number) []int {
+	if array, ok := n.(*Array); ok {
+		return array.Shape
+	}
+	return nil
+}
+
+// elementsOf returns the elements of an array, or the scalar itself as the only element.
+func elementsOf(n Number) []Number {
+	if array, ok := n.(*Array); ok {
+		return array.Elements
+	}
+	return []Number{n}
+}
+
+// newArray returns an array of the given shape, or the only element if the shape is that of a scalar.
+func newArray(shape []int, elements []Number) Number {
+	if shape == nil {
+		return elements[0]
+	}
+	return &Array{Shape: shape, Elements: elements}
+}
+
+// FormatShape writes a shape as in error messages, e.g. [2, 3] or [] for a scalar.
+func FormatShape(shape []int) string {
+	return strings.ReplaceAll(fmt.Sprint(shape), " ", ", ")
+}
+
+// ListShape returns the shape of a list literal from the shapes of its items:
+// scalars make a vector, vectors of one length make a matrix with a row per vector.
+func ListShape(items [][]int) ([]int, error) {
+	first := items[0]
+	for _, item := range items {
+		switch {
+		case len(item) > 1:
+			return nil, fmt.Errorf("%s: arrays have at most two dimensions", constants.ErrInvalidArray)
+		case len(item) != len(first):
+			return nil, fmt.Errorf("%s: a list mixes numbers and vectors", constants.ErrInvalidArray)
+		case len(item) == 1 && item[0] != first[0]:
+			return nil, fmt.Errorf("%s: rows of a matrix have different lengths %d and %d",
+				constants.ErrInvalidArray, first[0], item[0])
+		}
+	}
+	if len(first) == 0 {
+		return []int{len(items)}, nil
+	}
+	return []int{len(items), first[0]}, nil
+}
+
+// BroadcastShape returns the shape that operands of the given shapes are stretched to by an elementwise
+// operation. Shapes are aligned at the last dimension; a missing dimension or a dimension of length 1
+// is repeated, so a scalar applies to every element and a vector to every row of a matrix.
+func BroadcastShape(a, b []int) ([]int, error) {
+	long, short := a, b
+	if len(long) < len(short) {
+		long, short = short, long
+	}
+	shape := append([]int(nil), long...)
+	offset := len(long) - len(short)
+	for i, n := range short {
+		switch {
+		case shape[offset+i] == n || n == 1:
+		case shape[offset+i] == 1:
+			shape[offset+i] = n
+		default:
+			return nil, fmt.Errorf("%s: %s and %s", constants.ErrShapeMismatch, FormatShape(a), FormatShape(b))
+		}
+	}
+	if len(shape) == 0 {
+		return nil, nil
+	}
+	return shape, nil
+}
+
+// BroadcastIndex returns the index of the element of an operand of the given shape
+// that is used at the index of the broadcast result of the given shape.
+func BroadcastIndex(shape, operand []int, index int) int {
+	result, stride := 0, 1
+	for i := 1; i <= len(operand); i++ {
+		n := shape[len(shape)-i]
+		if operand[len(operand)-i] != 1 {
+			result += index % n * stride
+			stride *= operand[len(operand)-i]
+		}
+		index /= n
+	}
+	return result
+}
+
+// Size returns the number of elements of an array of the given shape; a scalar has one.
+func Size(shape []int) int {
+	size := 1
+	for _, n := range shape {
+		size *= n
+	}
+	return size
+}
+
+// MatmulOperands returns the shape of the matrix product of arrays of the given shapes and,
+// for every cell of the product, the indices of the elements of a and of b whose dot product it is.
+// A vector on the left is a row and on the right a column; the product of two vectors is a scalar.
+func MatmulOperands(a, b []int) (shape []int, left, right [][]int, err error) {
+	if a == nil || b == nil {
+		return nil, nil, nil, fmt.Errorf("%s: matmul", constants.ErrArrayRequired)
+	}
+	rows, inner := 1, a[0]
+	if len(a) == 2 {
+		rows, inner = a[0], a[1]
+		shape = append(shape, rows)
+	}
+	depth, cols := b[0], 1
+	if len(b) == 2 {
+		cols = b[1]
+		shape = append(shape, cols)
+	}
+	if inner != depth {
+		return nil, nil, nil, fmt.Errorf("%s: %s and %s", constants.ErrShapeMismatch, FormatShape(a), FormatShape(b))
+	}
+
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			row, col := make([]int, inner), make([]int, inner)
+			for k := 0; k < inner; k++ {
+				row[k], col[k] = i*inner+k, k*cols+j
+			}
+			left, right = append(left, row), append(right, col)
+		}
+	}
+	return shape, left, right, nil
+}
+
+// TransposeIndices returns the shape of the transposed array and, for every element of it,
+// the index of the element of the original array. A vector becomes a column matrix.
+func TransposeIndices(shape []int) ([]int, []int, error) {
+	switch len(shape) {
+	case 0:
+		return nil, nil, fmt.Errorf("%s: transpose", constants.ErrArrayRequired)
+	case 1:
+		indices := make([]int, shape[0])
+		for i := range indices {
+			indices[i] = i
+		}
+		return []int{shape[0], 1}, indices, nil
+	}
+	rows, cols := shape[0], shape[1]
+	indices := make([]int, 0, rows*cols)
+	for j := 0; j < cols; j++ {
+		for i := 0; i < rows; i++ {
+			indices = append(indices, i*cols+j)
+		}
+	}
+	return []int{cols, rows}, indices, nil
+}
+
+// DotOperands checks the arguments of dot: two vectors of the same length.
+func DotOperands(a, b []int) error {
+	if len(a) != 1 || len(b) != 1 {
+		return fmt.Errorf("%s: dot expects two vectors", constants.ErrArrayRequired)
+	}
+	if a[0] != b[0] {
+		return fmt.Errorf("%s: %s and %s", constants.ErrShapeMismatch, FormatShape(a), FormatShape(b))
+	}
+	return nil
+}
+
+// SquareSize checks the argument of det: a square matrix, and returns the number of its rows.
+func SquareSize(shape []int) (int, error) {
+	if len(shape) != 2 {
+		return 0, fmt.Errorf("%s: det", constants.ErrArrayRequired)
+	}
+	if shape[0] != shape[1] {
+		return 0, fmt.Errorf("%s: %s", constants.ErrSquareMatrix, FormatShape(shape))
+	}
+	return shape[0], nil
+}
+
+// IsArrayFunction reports whether the built-in function combines whole arrays rather than
+// applying to each element: sum, mean, dot, det, matmul and transpose.
+func IsArrayFunction(name string) bool {
+	switch name {
+	case "sum", "mean", "dot", "det", "matmul", "transpose":
+		return true
+	}
+	return false
+}
+
+// newList builds the array of a list literal from the values of its items.
+func newList(items []Number) (Number, error) {
+	shapes := make([][]int, len(items))
+	var elements []Number
+	for i, item := range items {
+		shapes[i] = shapeOf(item)
+		elements = append(elements, elementsOf(item)...)
+	}
+	shape, err := ListShape(shapes)
+	if err != nil {
+		return nil, err
+	}
+	return &Array{Shape: shape, Elements: elements}, nil
+}
+
+// mapElements applies fn to the elements of the arguments broadcast to a common shape.
+func mapElements(args []Number, fn func([]Number) (Number, error)) (Number, error) {
+	var shape []int
+	for _, arg := range args {
+		var err error
+		if shape, err = BroadcastShape(shape, shapeOf(arg)); err != nil {
+			return nil, err
+		}
+	}
+
+	elements := make([]Number, Size(shape))
+	for i := range elements {
+		xs := make([]Number, len(args))
+		for j, arg := range args {
+			xs[j] = elementsOf(arg)[BroadcastIndex(shape, shapeOf(arg), i)]
+		}
+		value, err := fn(xs)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = value
+	}
+	return newArray(shape, elements), nil
+}
+
+// callArray invokes a built-in function with array arguments. The array functions are computed through
+// their scalar forms over the flattened elements, the same forms agents execute; other functions
+// are applied element by element.
+func (a Arithmetic) callArray(fn Function, args []Number) (Number, error) {
+	switch fn.Name {
+	case "sum", "mean":
+		var elements []Number
+		for _, arg := range args {
+			elements = append(elements, elementsOf(arg)...)
+		}
+		return a.Call(fn.Name, elements)
+	case "dot":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%s: dot expects two vectors", constants.ErrArrayRequired)
+		}
+		if err := DotOperands(shapeOf(args[0]), shapeOf(args[1])); err != nil {
+			return nil, err
+		}
+		return a.Call("dot", append(append([]Number(nil), elementsOf(args[0])...), elementsOf(args[1])...))
+	case "det":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: det expects one matrix", constants.ErrArrayRequired)
+		}
+		if _, err := SquareSize(shapeOf(args[0])); err != nil {
+			return nil, err
+		}
+		return a.Call("det", elementsOf(args[0]))
+	case "matmul":
+		shape, left, right, err := MatmulOperands(shapeOf(args[0]), shapeOf(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		x, y := elementsOf(args[0]), elementsOf(args[1])
+		cells := make([]Number, len(left))
+		for i := range cells {
+			operands := make([]Number, 0, 2*len(left[i]))
+			for _, index := range left[i] {
+				operands = append(operands, x[index])
+			}
+			for _, index := range right[i] {
+				operands = append(operands, y[index])
+			}
+			if cells[i], err = a.Call("dot", operands); err != nil {
+				return nil, err
+			}
+		}
+		return newArray(shape, cells), nil
+	case "transpose":
+		shape, indices, err := TransposeIndices(shapeOf(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]Number, len(indices))
+		for i, index := range indices {
+			elements[i] = elementsOf(args[0])[index]
+		}
+		return newArray(shape, elements), nil
+	default:
+		return mapElements(args, func(xs []Number) (Number, error) {
+			return a.Call(fn.Name, xs)
+		})
+	}
+}
+
+// matrixSize returns the number of rows of a square matrix given by its elements row by row.
+func matrixSize(count int) (int, error) {
+	n := int(math.Round(math.Sqrt(float64(count))))
+	if n*n != count {
+		return 0, fmt.Errorf("%s: %d elements", constants.ErrSquareMatrix, count)
+	}
+	return n, nil
+}
+
+// determinant computes the determinant of a square matrix given by its elements row by row
+// with Gaussian elimination and partial pivoting.
+func determinant[T float64 | complex128](cells []T, abs func(T) float64) (T, error) {
+	n, err := matrixSize(len(cells))
+	if err != nil {
+		return 0, err
+	}
+	m := slices.Clone(cells)
+	var det T = 1
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if abs(m[row*n+col]) > abs(m[pivot*n+col]) {
+				pivot = row
+			}
+		}
+		if m[pivot*n+col] == 0 {
+			return 0, nil
+		}
+		if pivot != col {
+			for k := 0; k < n; k++ {
+				m[pivot*n+k], m[col*n+k] = m[col*n+k], m[pivot*n+k]
+			}
+			det = -det
+		}
+		det *= m[col*n+col]
+		for row := col + 1; row < n; row++ {
+			factor := m[row*n+col] / m[col*n+col]
+			for k := col; k < n; k++ {
+				m[row*n+k] -= factor * m[col*n+k]
+			}
+		}
+	}
+	return det, nil
+}
+
+// ratDeterminant computes the determinant of a square matrix of exact numbers; any non-zero pivot will do.
+func ratDeterminant(cells []*big.Rat) (*big.Rat, error) {
+	n, err := matrixSize(len(cells))
+	if err != nil {
+		return nil, err
+	}
+	m := make([]*big.Rat, len(cells))
+	for i, cell := range cells {
+		m[i] = new(big.Rat).Set(cell)
+	}
+	det := big.NewRat(1, 1)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot*n+col].Sign() == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return new(big.Rat), nil
+		}
+		if pivot != col {
+			for k := 0; k < n; k++ {
+				m[pivot*n+k], m[col*n+k] = m[col*n+k], m[pivot*n+k]
+			}
+			det.Neg(det)
+		}
+		det.Mul(det, m[col*n+col])
+		for row := col + 1; row < n; row++ {
+			factor := new(big.Rat).Quo(m[row*n+col], m[col*n+col])
+			for k := col; k < n; k++ {
+				m[row*n+k].Sub(m[row*n+k], new(big.Rat).Mul(factor, m[col*n+k]))
+			}
+		}
+	}
+	return det, nil
+}
@@ -0,0 +1,214 @@
+package calculation
+
+import "sort"
+
+// Node is an element of the abstract syntax tree produced by the parser.
+// The same tree is walked by the local evaluator and compiled into tasks by the orchestrator.
+type Node interface {
+	// Pos returns the byte offset of the node in the source expression.
+	Pos() int
+}
+
+// NumberNode is a numeric literal.
+type NumberNode struct {
+	Literal   string  // Source text of the literal.
+	Value     float64 // Parsed value of the literal; for imaginary literals the coefficient of i.
+	Imaginary bool    // Whether the literal has the imaginary suffix, e.g. 4i.
+	Position  int     // Byte offset of the literal.
+}
+
+// UnaryNode is a prefix operation applied to a single operand, e.g. -x.
+type UnaryNode struct {
+	Op       string // Operator symbol.
+	Operand  Node   // Operand of the operation.
+	Position int    // Byte offset of the operator.
+}
+
+// BinaryNode is an infix operation, e.g. a + b.
+type BinaryNode struct {
+	Op       string // Operator symbol.
+	Left     Node   // Left operand.
+	Right    Node   // Right operand.
+	Position int    // Byte offset of the operator.
+}
+
+// GroupNode is a parenthesized subexpression.
+type GroupNode struct {
+	Inner    Node // Expression inside the parentheses.
+	Position int  // Byte offset of the opening parenthesis.
+}
+
+// IdentNode is a reference to a variable bound when the expression is evaluated.
+type IdentNode struct {
+	Name     string // Name of the variable.
+	Position int    // Byte offset of the name.
+}
+
+// CallNode is a call of a built-in or user-defined function, e.g. max(a, 3, 4).
+type CallNode struct {
+	Name     string // Name of the function.
+	Args     []Node // Arguments of the call.
+	Position int    // Byte offset of the function name.
+}
+
+// ConditionalNode is a conditional expression, cond ? then : else or if(cond, then, else).
+// Only the branch selected by the condition is evaluated.
+type ConditionalNode struct {
+	Cond     Node // Condition; any non-zero value is true.
+	Then     Node // Value of the expression when the condition is true.
+	Else     Node // Value of the expression when the condition is false.
+	Position int  // Byte offset of the question mark or of the if keyword.
+}
+
+// ListNode is a list literal: a vector [1, 2, 3] or, when its elements are vectors, a matrix [[1, 2], [3, 4]].
+type ListNode struct {
+	Elements []Node // Elements of the list.
+	Position int    // Byte offset of the opening bracket.
+}
+
+// ProgramNode is a sequence of bindings followed by the expression that produces the result,
+// e.g. a = 2+3; b = a*4; b^2 - a. Every binding may reference the bindings before it.
+type ProgramNode struct {
+	Bindings []Binding // Bindings in the order of the statements.
+	Result   Node      // Final expression of the program.
+	Position int       // Byte offset of the first statement.
+}
+
+// Binding is a statement of a program that names the value of an expression.
+type Binding struct {
+	Name     string // Bound name.
+	Value    Node   // Expression of the value.
+	Position int    // Byte offset of the name.
+}
+
+// Pos returns the byte offset of the literal.
+func (n *NumberNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the operator.
+func (n *UnaryNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the operator.
+func (n *BinaryNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the opening parenthesis.
+func (n *GroupNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the function name.
+func (n *CallNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the variable name.
+func (n *IdentNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the question mark or of the if keyword.
+func (n *ConditionalNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the opening bracket.
+func (n *ListNode) Pos() int { return n.Position }
+
+// Pos returns the byte offset of the first statement.
+func (n *ProgramNode) Pos() int { return n.Position }
+
+// Walk visits the node and its descendants in depth-first order.
+// Children of a node are skipped when visit returns false.
+func Walk(node Node, visit func(Node) bool) {
+	if node == nil || !visit(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *UnaryNode:
+		Walk(n.Operand, visit)
+	case *BinaryNode:
+		Walk(n.Left, visit)
+		Walk(n.Right, visit)
+	case *GroupNode:
+		Walk(n.Inner, visit)
+	case *CallNode:
+		for _, arg := range n.Args {
+			Walk(arg, visit)
+		}
+	case *ListNode:
+		for _, element := range n.Elements {
+			Walk(element, visit)
+		}
+	case *ConditionalNode:
+		Walk(n.Cond, visit)
+		Walk(n.Then, visit)
+		Walk(n.Else, visit)
+	case *ProgramNode:
+		for _, binding := range n.Bindings {
+			Walk(binding.Value, visit)
+		}
+		Walk(n.Result, visit)
+	}
+}
+
+// VariableNames returns the names of all variables referenced by the tree, sorted and without duplicates.
+// Names bound by a program are not variables.
+func VariableNames(node Node) []string {
+	seen := make(map[string]bool)
+	var names []string
+	Walk(node, func(n Node) bool {
+		switch n := n.(type) {
+		case *ProgramNode:
+			for _, binding := range n.Bindings {
+				seen[binding.Name] = true
+			}
+		case *IdentNode:
+			if !seen[n.Name] {
+				seen[n.Name] = true
+				names = append(names, n.Name)
+			}
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Tree is the JSON form of a syntax tree. Parentheses are not kept: the shape of the tree already encodes them.
+type Tree struct {
+	Type  string  `json:"type"`            // number, variable, unary, binary, call, list, conditional, binding or program.
+	Value string  `json:"value,omitempty"` // Literal of a number in plain decimal notation, see StyleCanonical.
+	Name  string  `json:"name,omitempty"`  // Name of a variable, of a called function or of a binding.
+	Op    string  `json:"op,omitempty"`    // Operator of a unary or binary operation.
+	Args  []*Tree `json:"args,omitempty"`  // Operands, arguments of a call, elements of a list, the condition and
+	// the branches, the value of a binding, or the bindings and the result of a program.
+}
+
+// NewTree converts a syntax tree into its JSON form.
+func NewTree(node Node) *Tree {
+	switch n := node.(type) {
+	case *NumberNode:
+		return &Tree{Type: "number", Value: canonicalLiteral(n.Literal)}
+	case *IdentNode:
+		return &Tree{Type: "variable", Name: n.Name}
+	case *GroupNode:
+		return NewTree(n.Inner)
+	case *UnaryNode:
+		return &Tree{Type: "unary", Op: n.Op, Args: []*Tree{NewTree(n.Operand)}}
+	case *BinaryNode:
+		return &Tree{Type: "binary", Op: n.Op, Args: []*Tree{NewTree(n.Left), NewTree(n.Right)}}
+	case *CallNode:
+		args := make([]*Tree, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = NewTree(arg)
+		}
+		return &Tree{Type: "call", Name: n.Name, Args: args}
+	case *ListNode:
+		args := make([]*Tree, len(n.Elements))
+		for i, element := range n.Elements {
+			args[i] = NewTree(element)
+		}
+		return &Tree{Type: "list", Args: args}
+	case *ConditionalNode:
+		return &Tree{Type: "conditional", Args: []*Tree{NewTree(n.Cond), NewTree(n.Then), NewTree(n.Else)}}
+	case *ProgramNode:
+		args := make([]*Tree, 0, len(n.Bindings)+1)
+		for _, binding := range n.Bindings {
+			args = append(args, &Tree{Type: "binding", Name: binding.Name, Args: []*Tree{NewTree(binding.Value)}})
+		}
+		return &Tree{Type: "program", Args: append(args, NewTree(n.Result))}
+	default:
+		return nil
+	}
+}
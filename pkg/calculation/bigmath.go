@@ -0,0 +1,174 @@
+package calculation
+
+import (
+	"math"
+	"math/big"
+)
+
+// builtinConstants are the named constants available in expressions.
+// A variable of the request or a binding with the same name shadows the constant.
+var builtinConstants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// IsConstant reports whether the name is a built-in constant.
+func IsConstant(name string) bool {
+	_, ok := builtinConstants[name]
+	return ok
+}
+
+// bigPi returns π with prec bits of mantissa by Machin's formula π = 16·atan(1/5) − 4·atan(1/239).
+func bigPi(prec uint) *big.Float {
+	pi := new(big.Float).SetPrec(prec).Mul(big.NewFloat(16), bigAtanInverse(5, prec))
+	return pi.Sub(pi, new(big.Float).SetPrec(prec).Mul(big.NewFloat(4), bigAtanInverse(239, prec)))
+}
+
+// bigAtanInverse returns atan(1/n) for an integer n > 1 by its Taylor series.
+func bigAtanInverse(n int64, prec uint) *big.Float {
+	x := new(big.Float).SetPrec(prec).Quo(big.NewFloat(1), new(big.Float).SetInt64(n))
+	square := new(big.Float).SetPrec(prec).Mul(x, x)
+	sum := new(big.Float).SetPrec(prec).Set(x)
+	power := new(big.Float).SetPrec(prec).Set(x)
+	term := new(big.Float).SetPrec(prec)
+	for k := int64(1); ; k++ {
+		power.Mul(power, square)
+		term.Quo(power, new(big.Float).SetInt64(2*k+1))
+		if negligible(term, sum, prec) {
+			return sum
+		}
+		if k%2 == 1 {
+			sum.Sub(sum, term)
+		} else {
+			sum.Add(sum, term)
+		}
+	}
+}
+
+// bigE returns e with prec bits of mantissa by the series Σ 1/k!.
+func bigE(prec uint) *big.Float {
+	sum := new(big.Float).SetPrec(prec).SetInt64(2)
+	term := new(big.Float).SetPrec(prec).SetInt64(1)
+	for k := int64(2); ; k++ {
+		term.Quo(term, new(big.Float).SetInt64(k))
+		if negligible(term, sum, prec) {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// negligible reports whether adding the term no longer changes the sum at the precision.
+func negligible(term, sum *big.Float, prec uint) bool {
+	return term.Sign() == 0 || sum.MantExp(nil)-term.MantExp(nil) > int(prec)
+}
+
+// ratFloat converts x to a big.Float with prec bits after the binary point, so large values keep
+// the same absolute precision as small ones.
+func ratFloat(x *big.Rat, prec uint) *big.Float {
+	intBits := x.Num().BitLen() - x.Denom().BitLen() + 1
+	return new(big.Float).SetPrec(prec + uint(max(intBits, 0))).SetRat(x)
+}
+
+// bigExp returns e^x with prec bits of mantissa. The argument is halved k times until it is small,
+// the series Σ xⁿ/n! is summed, and the sum is squared k times.
+func bigExp(x *big.Float, prec uint) *big.Float {
+	k := max(x.MantExp(nil)+8, 0)
+	work := prec + uint(k) + 32
+	r := new(big.Float).SetPrec(work).SetMantExp(x, -k)
+
+	sum := new(big.Float).SetPrec(work).SetInt64(1)
+	term := new(big.Float).SetPrec(work).SetInt64(1)
+	for n := int64(1); ; n++ {
+		term.Mul(term, r)
+		term.Quo(term, new(big.Float).SetInt64(n))
+		if negligible(term, sum, work) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	for ; k > 0; k-- {
+		sum.Mul(sum, sum)
+	}
+	return sum.SetPrec(prec)
+}
+
+// logReductions is the number of square roots taken before the logarithm series; each one doubles
+// the speed of its convergence.
+const logReductions = 16
+
+// bigLog returns the natural logarithm of x > 0 with prec bits of mantissa:
+// ln x = ln m + k·ln 2 for x = m·2ᵏ, and ln m = 2ʲ·ln m^(1/2ʲ) is summed by the series
+// ln t = 2·atanh((t−1)/(t+1)).
+func bigLog(x *big.Float, prec uint) *big.Float {
+	work := prec + logReductions + 32
+	m := new(big.Float).SetPrec(work)
+	k := x.MantExp(m)
+	m.SetPrec(work)
+	if k == 1 {
+		// Числа от 1 до 2 не раскладываются, чтобы ln m и ln 2 не вычитались друг из друга около единицы.
+		m.SetMantExp(m, 1)
+		k = 0
+	}
+	for i := 0; i < logReductions; i++ {
+		m.Sqrt(m)
+	}
+	one := new(big.Float).SetPrec(work).SetInt64(1)
+	z := new(big.Float).SetPrec(work).Quo(new(big.Float).SetPrec(work).Sub(m, one), new(big.Float).SetPrec(work).Add(m, one))
+	result := bigAtanh(z, work)
+	result.SetMantExp(result, logReductions+1)
+
+	if k != 0 {
+		// ln 2 = 2·atanh(1/3).
+		ln2 := bigAtanh(new(big.Float).SetPrec(work).Quo(one, new(big.Float).SetInt64(3)), work)
+		ln2.SetMantExp(ln2, 1)
+		result.Add(result, ln2.Mul(ln2, new(big.Float).SetInt64(int64(k))))
+	}
+	return result.SetPrec(prec)
+}
+
+// bigAtanh returns atanh(z) for |z| < 1 by the series Σ z^(2n+1)/(2n+1).
+func bigAtanh(z *big.Float, prec uint) *big.Float {
+	square := new(big.Float).SetPrec(prec).Mul(z, z)
+	sum := new(big.Float).SetPrec(prec).Set(z)
+	power := new(big.Float).SetPrec(prec).Set(z)
+	term := new(big.Float).SetPrec(prec)
+	for n := int64(1); ; n++ {
+		power.Mul(power, square)
+		term.Quo(power, new(big.Float).SetInt64(2*n+1))
+		if negligible(term, sum, prec) {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
+
+// bigSinCos returns sin x, or cos x if cos is set, with prec bits after the binary point.
+// The argument is reduced by whole turns and the Taylor series is summed.
+func bigSinCos(x *big.Float, prec uint, cos bool) *big.Float {
+	work := prec + uint(max(x.MantExp(nil), 0)) + 32
+	turn := bigPi(work)
+	turn.SetMantExp(turn, 1)
+	turns, _ := new(big.Float).SetPrec(work).Quo(x, turn).Int(nil)
+	r := new(big.Float).SetPrec(work).Sub(x, turn.Mul(turn, new(big.Float).SetInt(turns)))
+
+	// Σ (−1)ⁿ·r^(2n+s)/(2n+s)!, где s = 1 для синуса и 0 для косинуса.
+	term := new(big.Float).SetPrec(work).Set(r)
+	index := int64(1)
+	if cos {
+		term.SetInt64(1)
+		index = 0
+	}
+	square := new(big.Float).SetPrec(work).Mul(r, r)
+	sum := new(big.Float).SetPrec(work).Set(term)
+	for {
+		term.Mul(term, square)
+		term.Quo(term, new(big.Float).SetInt64((index+1)*(index+2)))
+		term.Neg(term)
+		index += 2
+		if term.Sign() == 0 || term.MantExp(nil) < -int(work) {
+			return sum
+		}
+		sum.Add(sum, term)
+	}
+}
@@ -2,33 +2,102 @@ package calculation
 
 import (
 	"errors"
+	"fmt"
+	"math"
 
+	"distributed_calculator/internal/constants"
 	"go.uber.org/zap"
 )
 
 var logger *zap.Logger
 
+// EvaluateExpression parses an expression and evaluates it locally.
 func EvaluateExpression(expression string) (float64, error) {
-	if expression == "" {
-		return 0, errors.New("expression is empty")
-	}
+	return EvaluateWithVariables(expression, nil)
+}
 
-	tokens := tokenize(expression)
-	if len(tokens) == 0 {
-		return 0, errors.New("invalid expression")
+// EvaluateWithVariables parses an expression and evaluates it locally with the given variable bindings.
+// Expressions with a complex or an array result are rejected; use EvaluateNumber to obtain them.
+func EvaluateWithVariables(expression string, variables map[string]float64) (float64, error) {
+	value, err := EvaluateNumber(expression, variables, Arithmetic{})
+	if err != nil {
+		return 0, err
+	}
+	if isArray(value) {
+		return 0, fmt.Errorf("%s: %s", constants.ErrArrayResult, value)
 	}
+	if isComplex(value) {
+		return 0, fmt.Errorf("%s: %s", constants.ErrComplexResult, value)
+	}
+	return value.Float64(), nil
+}
 
-	if logger != nil {
-		logger.Debug("Tokens generated", zap.Strings("tokens", tokens))
+// EvaluateNumber parses an expression and evaluates it in the number system selected by arith.
+func EvaluateNumber(expression string, variables map[string]float64, arith Arithmetic) (Number, error) {
+	if expression == "" {
+		return nil, errors.New("expression is empty")
+	}
+	if err := arith.Validate(); err != nil {
+		return nil, err
 	}
 
-	parser := &Parser{tokens: tokens, pos: 0}
-	result, err := parser.parse()
+	root, err := Parse(expression)
 	if err != nil {
 		if logger != nil {
 			logger.Error("Parser failed", zap.Error(err), zap.String("expression", expression))
 		}
+		return nil, err
+	}
+
+	return arith.Evaluate(root, variables)
+}
+
+// Evaluate walks an abstract syntax tree and computes its value in float64 mode.
+// Trees with a complex or an array result are rejected; use Arithmetic.Evaluate to obtain them.
+func Evaluate(node Node, variables map[string]float64) (float64, error) {
+	value, err := Arithmetic{}.Evaluate(node, variables)
+	if err != nil {
 		return 0, err
 	}
-	return result, nil
+	if isArray(value) {
+		return 0, fmt.Errorf("%s: %s", constants.ErrArrayResult, value)
+	}
+	if isComplex(value) {
+		return 0, fmt.Errorf("%s: %s", constants.ErrComplexResult, value)
+	}
+	return value.Float64(), nil
+}
+
+// apply performs a single binary operation.
+func apply(op string, left, right float64) (float64, error) {
+	switch op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		return left / right, nil
+	case "//":
+		if right == 0 {
+			return 0, errors.New(constants.ErrDivisionByZero)
+		}
+		return math.Floor(left / right), nil
+	case "%":
+		if right == 0 {
+			return 0, errors.New(constants.ErrModuloByZero)
+		}
+		if left != float64(int(left)) || right != float64(int(right)) {
+			return 0, errors.New(constants.ErrInvalidModulo)
+		}
+		return math.Mod(left, right), nil
+	case "^":
+		return math.Pow(left, right), nil
+	default:
+		return 0, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
 }
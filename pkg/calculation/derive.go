@@ -0,0 +1,549 @@
+package calculation
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"distributed_calculator/internal/constants"
+)
+
+// Derive returns the simplified derivative of the expression with respect to the variable.
+// Calls of user-defined functions are expanded into their bodies. Operations without a derivative,
+// such as %, comparisons or round, are an error only where their operands depend on the variable;
+// the derivative of a conditional is the conditional of the derivatives of its branches.
+// The bindings of a program are substituted into its result, so the derivative is a single expression.
+func Derive(node Node, variable string, functions Functions) (Node, error) {
+	if !isName(variable) || isReserved(variable) {
+		return nil, fmt.Errorf("%s '%s'", constants.ErrInvalidVariable, variable)
+	}
+	d := &deriver{variable: variable, functions: functions}
+	derivative, err := d.derive(node)
+	if err != nil {
+		return nil, err
+	}
+	return Simplify(derivative), nil
+}
+
+// deriver differentiates syntax trees with respect to one variable.
+type deriver struct {
+	variable  string
+	functions Functions
+}
+
+// derive applies the differentiation rules to the node; the result is simplified by the constructors along the way.
+func (d *deriver) derive(node Node) (Node, error) {
+	if !d.dependsOn(node) {
+		return number(0), nil
+	}
+
+	switch n := node.(type) {
+	case *IdentNode:
+		return number(1), nil
+	case *GroupNode:
+		return d.derive(n.Inner)
+	case *UnaryNode:
+		if n.Op != "-" {
+			return nil, notDifferentiable(n.Op)
+		}
+		operand, err := d.derive(n.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return negate(operand), nil
+	case *BinaryNode:
+		return d.deriveBinary(n)
+	case *CallNode:
+		return d.deriveCall(n)
+	case *ConditionalNode:
+		then, err := d.derive(n.Then)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := d.derive(n.Else)
+		if err != nil {
+			return nil, err
+		}
+		return conditional(Simplify(n.Cond), then, otherwise), nil
+	case *ProgramNode:
+		return d.derive(inline(n))
+	case *ListNode:
+		// Поэлементные правила неверны при растяжении скаляров на массивы, поэтому массивы не дифференцируются.
+		return nil, notDifferentiable("array")
+	default:
+		return nil, notDifferentiable(fmt.Sprintf("%T", node))
+	}
+}
+
+// deriveBinary applies the sum, product, quotient and power rules.
+func (d *deriver) deriveBinary(n *BinaryNode) (Node, error) {
+	left, right := Simplify(n.Left), Simplify(n.Right)
+	dl, err := d.derive(left)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := d.derive(right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Op {
+	case "+":
+		return sum(dl, dr), nil
+	case "-":
+		return difference(dl, dr), nil
+	case "*":
+		return sum(product(dl, right), product(left, dr)), nil
+	case "/":
+		if !d.dependsOn(right) {
+			return quotient(dl, right), nil
+		}
+		return quotient(difference(product(dl, right), product(left, dr)), power(right, number(2))), nil
+	case "^":
+		switch {
+		case !d.dependsOn(right):
+			// (u^n)' = n*u^(n-1)*u'
+			return product(product(right, power(left, difference(right, number(1)))), dl), nil
+		case !d.dependsOn(left):
+			// (a^v)' = a^v*log(a)*v'
+			return product(product(power(left, right), call("log", left)), dr), nil
+		default:
+			// (u^v)' = u^v*(v'*log(u) + v*u'/u)
+			return product(power(left, right),
+				sum(product(dr, call("log", left)), quotient(product(right, dl), left))), nil
+		}
+	default:
+		return nil, notDifferentiable(n.Op)
+	}
+}
+
+// deriveCall applies the chain rule to a call of a built-in function and expands a user-defined one.
+func (d *deriver) deriveCall(n *CallNode) (Node, error) {
+	if fn, ok := d.functions[n.Name]; ok {
+		return d.derive(substitute(fn.Body, fn.Params, n.Args))
+	}
+
+	args := make([]Node, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = Simplify(arg)
+	}
+	if n.Name == "min" || n.Name == "max" {
+		return d.deriveExtremum(n.Name, args)
+	}
+	if n.Name == "log" && len(args) == 2 {
+		// Логарифм по основанию b — это частное натуральных логарифмов.
+		return d.derive(quotient(call("log", args[0]), call("log", args[1])))
+	}
+
+	u := args[0]
+	du, err := d.derive(u)
+	if err != nil {
+		return nil, err
+	}
+	switch n.Name {
+	case "sin":
+		return product(call("cos", u), du), nil
+	case "cos":
+		return negate(product(call("sin", u), du)), nil
+	case "sqrt":
+		return quotient(du, product(number(2), call("sqrt", u))), nil
+	case "log":
+		return quotient(du, u), nil
+	case "abs":
+		return product(quotient(u, call("abs", u)), du), nil
+	default:
+		return nil, notDifferentiable(n.Name)
+	}
+}
+
+// deriveExtremum differentiates min or max as a conditional that selects the derivative of the chosen argument:
+// max(a, b, c) is max(max(a, b), c), whose derivative is max(a, b) >= c ? max(a, b)' : c'.
+func (d *deriver) deriveExtremum(name string, args []Node) (Node, error) {
+	if len(args) == 1 {
+		return d.derive(args[0])
+	}
+	rest := args[0]
+	if len(args) > 2 {
+		rest = call(name, args[:len(args)-1]...)
+	}
+	last := args[len(args)-1]
+
+	dRest, err := d.derive(rest)
+	if err != nil {
+		return nil, err
+	}
+	dLast, err := d.derive(last)
+	if err != nil {
+		return nil, err
+	}
+	op := ">="
+	if name == "min" {
+		op = "<="
+	}
+	return conditional(&BinaryNode{Op: op, Left: rest, Right: last}, dRest, dLast), nil
+}
+
+// dependsOn reports whether the value of the node depends on the variable.
+// Bodies of user-defined functions reference only their parameters, so only the arguments of calls matter.
+func (d *deriver) dependsOn(node Node) bool {
+	depends := false
+	Walk(node, func(n Node) bool {
+		if ident, ok := n.(*IdentNode); ok && ident.Name == d.variable {
+			depends = true
+		}
+		return !depends
+	})
+	return depends
+}
+
+// notDifferentiable reports an operation that has no derivative.
+func notDifferentiable(op string) error {
+	return fmt.Errorf("%s: %s", constants.ErrNotDifferentiable, op)
+}
+
+// substitute returns a copy of the body of a user-defined function with its parameters replaced by the arguments.
+func substitute(node Node, params []string, args []Node) Node {
+	switch n := node.(type) {
+	case *IdentNode:
+		for i, param := range params {
+			if param == n.Name {
+				return args[i]
+			}
+		}
+		return n
+	case *GroupNode:
+		return &GroupNode{Inner: substitute(n.Inner, params, args), Position: n.Position}
+	case *UnaryNode:
+		return &UnaryNode{Op: n.Op, Operand: substitute(n.Operand, params, args), Position: n.Position}
+	case *BinaryNode:
+		return &BinaryNode{Op: n.Op, Left: substitute(n.Left, params, args),
+			Right: substitute(n.Right, params, args), Position: n.Position}
+	case *CallNode:
+		callArgs := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			callArgs[i] = substitute(arg, params, args)
+		}
+		return &CallNode{Name: n.Name, Args: callArgs, Position: n.Position}
+	case *ListNode:
+		elements := make([]Node, len(n.Elements))
+		for i, element := range n.Elements {
+			elements[i] = substitute(element, params, args)
+		}
+		return &ListNode{Elements: elements, Position: n.Position}
+	case *ConditionalNode:
+		return &ConditionalNode{Cond: substitute(n.Cond, params, args), Then: substitute(n.Then, params, args),
+			Else: substitute(n.Else, params, args), Position: n.Position}
+	default:
+		return node
+	}
+}
+
+// inline returns the result of the program with the values of the bindings substituted for their names.
+func inline(program *ProgramNode) Node {
+	names := make([]string, 0, len(program.Bindings))
+	values := make([]Node, 0, len(program.Bindings))
+	for _, binding := range program.Bindings {
+		values = append(values, substitute(binding.Value, names, values))
+		names = append(names, binding.Name)
+	}
+	return substitute(program.Result, names, values)
+}
+
+// Simplify rewrites the tree into an equivalent shorter one: parentheses are dropped, operations on real
+// literals are computed where the result is exact, and identities such as x+0, x*1, x^1, x-x and x/x are applied.
+// Like most computer algebra systems it assumes that x/x and 0*x are defined, so the result may be defined
+// at points where the original expression is not.
+func Simplify(node Node) Node {
+	switch n := node.(type) {
+	case *GroupNode:
+		return Simplify(n.Inner)
+	case *UnaryNode:
+		operand := Simplify(n.Operand)
+		if n.Op == "-" {
+			return negate(operand)
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand, Position: n.Position}
+	case *BinaryNode:
+		left, right := Simplify(n.Left), Simplify(n.Right)
+		switch n.Op {
+		case "+":
+			return sum(left, right)
+		case "-":
+			return difference(left, right)
+		case "*":
+			return product(left, right)
+		case "/":
+			return quotient(left, right)
+		case "^":
+			return power(left, right)
+		}
+		return &BinaryNode{Op: n.Op, Left: left, Right: right, Position: n.Position}
+	case *CallNode:
+		args := make([]Node, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = Simplify(arg)
+		}
+		return &CallNode{Name: n.Name, Args: args, Position: n.Position}
+	case *ListNode:
+		elements := make([]Node, len(n.Elements))
+		for i, element := range n.Elements {
+			elements[i] = Simplify(element)
+		}
+		return &ListNode{Elements: elements, Position: n.Position}
+	case *ConditionalNode:
+		return conditional(Simplify(n.Cond), Simplify(n.Then), Simplify(n.Else))
+	case *ProgramNode:
+		program := &ProgramNode{Bindings: make([]Binding, len(n.Bindings)), Position: n.Position}
+		for i, binding := range n.Bindings {
+			program.Bindings[i] = Binding{Name: binding.Name, Value: Simplify(binding.Value), Position: binding.Position}
+		}
+		program.Result = Simplify(n.Result)
+		return program
+	default:
+		return node
+	}
+}
+
+// number builds a literal for a real value; negative values become a negation of a literal.
+func number(value float64) Node {
+	if value < 0 {
+		return &UnaryNode{Op: "-", Operand: number(-value)}
+	}
+	if value == 0 {
+		value = 0 // Отрицательный ноль печатается как 0.
+	}
+	return &NumberNode{Literal: formatNumber(value), Value: value}
+}
+
+// constant returns the value of a real literal or of a negated one.
+func constant(node Node) (float64, bool) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Value, !n.Imaginary
+	case *UnaryNode:
+		if value, ok := constant(n.Operand); ok && n.Op == "-" {
+			return -value, true
+		}
+	}
+	return 0, false
+}
+
+// sameTree reports whether two trees are structurally equal; the factors of a product may be swapped.
+func sameTree(a, b Node) bool {
+	if Format(a) == Format(b) {
+		return true
+	}
+	pa, ok := a.(*BinaryNode)
+	if !ok || pa.Op != "*" {
+		return false
+	}
+	pb, ok := b.(*BinaryNode)
+	return ok && pb.Op == "*" && sameTree(pa.Left, pb.Right) && sameTree(pa.Right, pb.Left)
+}
+
+// negated returns x for the node -x.
+func negated(node Node) (Node, bool) {
+	if unary, ok := node.(*UnaryNode); ok && unary.Op == "-" {
+		return unary.Operand, true
+	}
+	return nil, false
+}
+
+// exactResult checks that a computed value is short enough to be written as a literal:
+// 1.5 and 6 are, while 1/3 or 0.1+0.2 carry a rounding error and the operation is kept.
+func exactResult(value float64) bool {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return false
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'g', 15, 64), 64)
+	return err == nil && rounded == value
+}
+
+// negate builds -x from a simplified operand; the sign of a product goes into its numeric factor, -(2*x) = -2*x.
+func negate(x Node) Node {
+	if c, ok := constant(x); ok {
+		return number(-c)
+	}
+	if inner, ok := negated(x); ok {
+		return inner
+	}
+	if c, rest, ok := coefficient(x); ok {
+		return product(number(-c), rest)
+	}
+	return &UnaryNode{Op: "-", Operand: x}
+}
+
+// coefficient splits a product with a numeric first factor, c*x or c*x*y, into c and the rest of the product.
+func coefficient(node Node) (float64, Node, bool) {
+	p, ok := node.(*BinaryNode)
+	if !ok || p.Op != "*" {
+		return 0, nil, false
+	}
+	if c, ok := constant(p.Left); ok {
+		return c, p.Right, true
+	}
+	if c, rest, ok := coefficient(p.Left); ok {
+		return c, &BinaryNode{Op: "*", Left: rest, Right: p.Right}, true
+	}
+	return 0, nil, false
+}
+
+// sum builds l + r from simplified operands.
+func sum(l, r Node) Node {
+	lc, lok := constant(l)
+	rc, rok := constant(r)
+	switch {
+	case lok && rok && exactResult(lc+rc):
+		return number(lc + rc)
+	case lok && lc == 0:
+		return r
+	case rok && rc == 0:
+		return l
+	case sameTree(l, r):
+		return product(number(2), l)
+	}
+	if inner, ok := negated(r); ok {
+		return difference(l, inner)
+	}
+	if inner, ok := negated(l); ok {
+		return difference(r, inner)
+	}
+	return &BinaryNode{Op: "+", Left: l, Right: r}
+}
+
+// difference builds l - r from simplified operands.
+func difference(l, r Node) Node {
+	lc, lok := constant(l)
+	rc, rok := constant(r)
+	switch {
+	case lok && rok && exactResult(lc-rc):
+		return number(lc - rc)
+	case rok && rc == 0:
+		return l
+	case lok && lc == 0:
+		return negate(r)
+	case sameTree(l, r):
+		return number(0)
+	}
+	if inner, ok := negated(r); ok {
+		return sum(l, inner)
+	}
+	return &BinaryNode{Op: "-", Left: l, Right: r}
+}
+
+// product builds l * r from simplified operands; a constant factor is moved to the front and merged with another one.
+func product(l, r Node) Node {
+	lc, lok := constant(l)
+	rc, rok := constant(r)
+	switch {
+	case lok && rok && exactResult(lc*rc):
+		return number(lc * rc)
+	case lok && lc == 0 || rok && rc == 0:
+		return number(0)
+	case lok && lc == 1:
+		return r
+	case rok && rc == 1:
+		return l
+	case lok && lc == -1:
+		return negate(r)
+	case rok && rc == -1:
+		return negate(l)
+	case rok:
+		return product(r, l)
+	case sameTree(l, r):
+		return power(l, number(2))
+	}
+	if lok {
+		if inner, ok := negated(r); ok {
+			return product(number(-lc), inner)
+		}
+		// Числовые множители собираются в один: 2*(3*x) = 6*x.
+		if c, rest, ok := coefficient(r); ok && exactResult(lc*c) {
+			return product(number(lc*c), rest)
+		}
+		// Множитель ставится в начало цепочки: 2*(a*b) = 2*a*b.
+		if rp, ok := r.(*BinaryNode); ok && rp.Op == "*" {
+			return &BinaryNode{Op: "*", Left: product(l, rp.Left), Right: rp.Right}
+		}
+		return &BinaryNode{Op: "*", Left: l, Right: r}
+	}
+	if inner, ok := negated(l); ok {
+		return negate(product(inner, r))
+	}
+	if inner, ok := negated(r); ok {
+		return negate(product(l, inner))
+	}
+	return &BinaryNode{Op: "*", Left: l, Right: r}
+}
+
+// quotient builds l / r from simplified operands; division by a zero literal is left to the evaluation.
+func quotient(l, r Node) Node {
+	lc, lok := constant(l)
+	rc, rok := constant(r)
+	switch {
+	case rok && rc == 0:
+		return &BinaryNode{Op: "/", Left: l, Right: r}
+	case lok && rok && exactResult(lc/rc):
+		return number(lc / rc)
+	case lok && lc == 0:
+		return number(0)
+	case rok && rc == 1:
+		return l
+	case rok && rc == -1:
+		return negate(l)
+	case sameTree(l, r):
+		return number(1)
+	}
+	// Числовые множители сокращаются: 4*x/(2*y) = 2*x/y.
+	if c, top, ok := coefficient(l); ok {
+		if d, bottom, ok := coefficient(r); ok && exactResult(c/d) {
+			return quotient(product(number(c/d), top), bottom)
+		}
+	}
+	if lok || rok {
+		return &BinaryNode{Op: "/", Left: l, Right: r}
+	}
+	if inner, ok := negated(l); ok {
+		return negate(quotient(inner, r))
+	}
+	if inner, ok := negated(r); ok {
+		return negate(quotient(l, inner))
+	}
+	return &BinaryNode{Op: "/", Left: l, Right: r}
+}
+
+// power builds l ^ r from simplified operands.
+func power(l, r Node) Node {
+	lc, lok := constant(l)
+	rc, rok := constant(r)
+	switch {
+	case lok && rok && exactResult(math.Pow(lc, rc)):
+		return number(math.Pow(lc, rc))
+	case rok && rc == 0:
+		return number(1)
+	case rok && rc == 1:
+		return l
+	case lok && lc == 1:
+		return number(1)
+	}
+	return &BinaryNode{Op: "^", Left: l, Right: r}
+}
+
+// call builds a call of a built-in function.
+func call(name string, args ...Node) Node {
+	return &CallNode{Name: name, Args: args}
+}
+
+// conditional builds cond ? then : otherwise from simplified parts; a literal condition selects its branch.
+func conditional(cond, then, otherwise Node) Node {
+	if c, ok := constant(cond); ok {
+		if c != 0 {
+			return then
+		}
+		return otherwise
+	}
+	if sameTree(then, otherwise) {
+		return then
+	}
+	return &ConditionalNode{Cond: cond, Then: then, Else: otherwise}
+}
@@ -0,0 +1,77 @@
+package calculation
+
+import "strings"
+
+// Classes of tokens listed in the expected set of a parse error.
+var (
+	expectOperand  = []string{"number", "name", "(", "["}
+	expectOperator = []string{"operator"}
+	// expectStatementEnd is expected after a complete statement: an operator continues it, ; ends it.
+	expectStatementEnd = []string{"operator", ";"}
+)
+
+// ParseError is a syntax error located in the source expression.
+// Its message is the same text the parser has always reported; the other fields tell where the error is.
+type ParseError struct {
+	Code       string   // Machine-readable error code, e.g. UNEXPECTED_TOKEN.
+	Message    string   // Human-readable description of the error.
+	Expression string   // Source expression.
+	Position   int      // Byte offset of the error; the length of the expression if the input ended too early.
+	Token      string   // Offending token; empty at the end of the expression.
+	Expected   []string // Tokens or token classes that would have been accepted at the position.
+}
+
+// Error returns the description of the error.
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// Column returns the 1-based column of the error within its line.
+func (e *ParseError) Column() int {
+	return e.Position - e.lineStart() + 1
+}
+
+// Caret returns the line of the expression that contains the error followed by a line
+// with a caret under the error, e.g. "2*(3+)\n     ^".
+func (e *ParseError) Caret() string {
+	start := e.lineStart()
+	end := strings.IndexByte(e.Expression[start:], '\n')
+	if end < 0 {
+		end = len(e.Expression)
+	} else {
+		end += start
+	}
+
+	var marker strings.Builder
+	for i := start; i < e.Position && i < end; i++ {
+		if e.Expression[i] == '\t' {
+			marker.WriteByte('\t') // Табуляция сохраняется, чтобы указатель не сместился.
+		} else {
+			marker.WriteByte(' ')
+		}
+	}
+	return strings.TrimRight(e.Expression[start:end], "\r") + "\n" + marker.String() + "^"
+}
+
+// lineStart returns the byte offset of the line that contains the error.
+func (e *ParseError) lineStart() int {
+	position := min(e.Position, len(e.Expression))
+	return strings.LastIndexByte(e.Expression[:position], '\n') + 1
+}
+
+// newParseError creates a parse error at the given token.
+func newParseError(expression, code, message string, token Token, expected ...string) *ParseError {
+	return &ParseError{
+		Code:       code,
+		Message:    message,
+		Expression: expression,
+		Position:   token.Pos,
+		Token:      token.Text,
+		Expected:   expected,
+	}
+}
+
+// endOfInput is a pseudo token that marks the end of an expression in parse errors.
+func endOfInput(expression string) Token {
+	return Token{Pos: len(expression)}
+}
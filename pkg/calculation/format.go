@@ -0,0 +1,592 @@
+package calculation
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"distributed_calculator/internal/constants"
+)
+
+// Style selects the notation produced by Print.
+type Style string
+
+const (
+	// StyleCanonical writes literals in plain decimal notation, every multiplication explicitly,
+	// single spaces around binary operators except ^ and only the parentheses the tree requires.
+	// Expressions that differ only in spelling, such as 2+3, 2 +  3 and (2)+(3), share the canonical form.
+	StyleCanonical Style = "canonical"
+	// StyleMinimal is the canonical form without optional spaces, e.g. 2+3*4.
+	StyleMinimal Style = "minimal"
+	// StyleLaTeX is LaTeX math markup, e.g. \frac{1}{2} \cdot x^{2}.
+	StyleLaTeX Style = "latex"
+	// StyleMathML is presentation MathML.
+	StyleMathML Style = "mathml"
+)
+
+// ParseStyle validates the name of a notation; an empty name selects the canonical form.
+func ParseStyle(name string) (Style, error) {
+	switch style := Style(name); style {
+	case "":
+		return StyleCanonical, nil
+	case StyleCanonical, StyleMinimal, StyleLaTeX, StyleMathML:
+		return style, nil
+	default:
+		return "", fmt.Errorf("%s '%s'", constants.ErrUnsupportedFormat, name)
+	}
+}
+
+// Print writes the syntax tree in the given notation. The canonical and minimal forms parse back
+// into an equivalent tree; an unknown style falls back to the canonical form.
+func Print(node Node, style Style) string {
+	var b strings.Builder
+	switch style {
+	case StyleLaTeX:
+		printLaTeX(&b, node, conditionalPrecedence)
+	case StyleMathML:
+		b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML">`)
+		printMathML(&b, node, conditionalPrecedence)
+		b.WriteString("</math>")
+	default:
+		p := textPrinter{compact: style == StyleMinimal}
+		p.print(&b, node, conditionalPrecedence)
+	}
+	return b.String()
+}
+
+// Format prints the syntax tree in the canonical form.
+func Format(node Node) string {
+	return Print(node, StyleCanonical)
+}
+
+// Precedences of the printed forms of nodes; a child whose precedence is below the one required
+// by its position is wrapped in parentheses. Binary operators take the levels of binaryLevels shifted by one.
+const conditionalPrecedence = 0
+
+var (
+	powerPrecedence = len(binaryLevels) + 1
+	unaryPrecedence = powerPrecedence + 1
+	atomPrecedence  = unaryPrecedence + 1
+)
+
+// precedence returns the binding strength of the printed form of the node.
+func precedence(node Node) int {
+	switch n := node.(type) {
+	case *GroupNode:
+		return precedence(n.Inner)
+	case *ConditionalNode:
+		return conditionalPrecedence
+	case *UnaryNode:
+		if n.Op == "not" {
+			return notLevel + 1
+		}
+		return unaryPrecedence
+	case *BinaryNode:
+		if n.Op == "^" {
+			return powerPrecedence
+		}
+		for level, ops := range binaryLevels {
+			if slices.Contains(ops, n.Op) {
+				return level + 1
+			}
+		}
+		return conditionalPrecedence
+	default:
+		return atomPrecedence
+	}
+}
+
+// textPrinter writes the canonical and the minimal forms.
+type textPrinter struct {
+	compact bool // Не ставить необязательные пробелы.
+}
+
+// print writes the node, in parentheses if its precedence is lower than minimum.
+func (p textPrinter) print(b *strings.Builder, node Node, minimum int) {
+	if group, ok := node.(*GroupNode); ok {
+		p.print(b, group.Inner, minimum)
+		return
+	}
+	if precedence(node) < minimum {
+		b.WriteByte('(')
+		defer b.WriteByte(')')
+	}
+
+	switch n := node.(type) {
+	case *NumberNode:
+		b.WriteString(canonicalLiteral(n.Literal))
+	case *IdentNode:
+		b.WriteString(n.Name)
+	case *UnaryNode:
+		if n.Op == "not" {
+			b.WriteString("not ")
+			p.print(b, n.Operand, notLevel+1)
+			return
+		}
+		b.WriteString(n.Op)
+		// Два унарных оператора подряд парсер не принимает, поэтому вложенный берётся в скобки.
+		p.print(b, n.Operand, atomPrecedence)
+	case *BinaryNode:
+		if n.Op == "^" {
+			p.print(b, n.Left, atomPrecedence)
+			b.WriteString("^")
+			p.print(b, n.Right, powerPrecedence)
+			return
+		}
+		level := precedence(n)
+		p.print(b, n.Left, level)
+		p.operator(b, n.Op)
+		p.print(b, n.Right, level+1)
+	case *CallNode:
+		b.WriteString(n.Name + "(")
+		for i, arg := range n.Args {
+			if i > 0 {
+				p.separator(b, ",")
+			}
+			p.print(b, arg, conditionalPrecedence)
+		}
+		b.WriteString(")")
+	case *ListNode:
+		b.WriteString("[")
+		for i, element := range n.Elements {
+			if i > 0 {
+				p.separator(b, ",")
+			}
+			p.print(b, element, conditionalPrecedence)
+		}
+		b.WriteString("]")
+	case *ConditionalNode:
+		p.print(b, n.Cond, conditionalPrecedence+1)
+		p.operator(b, "?")
+		p.print(b, n.Then, conditionalPrecedence)
+		p.operator(b, ":")
+		p.print(b, n.Else, conditionalPrecedence)
+	case *ProgramNode:
+		for _, binding := range n.Bindings {
+			b.WriteString(binding.Name)
+			p.operator(b, "=")
+			p.print(b, binding.Value, conditionalPrecedence)
+			p.separator(b, ";")
+		}
+		p.print(b, n.Result, conditionalPrecedence)
+	}
+}
+
+// operator writes a binary operator; word operators such as and are separated by spaces in both forms.
+func (p textPrinter) operator(b *strings.Builder, op string) {
+	if p.compact && !isLetter(op[0]) {
+		b.WriteString(op)
+		return
+	}
+	b.WriteString(" " + op + " ")
+}
+
+// separator writes a separator of function arguments.
+func (p textPrinter) separator(b *strings.Builder, sep string) {
+	b.WriteString(sep)
+	if !p.compact {
+		b.WriteByte(' ')
+	}
+}
+
+// canonicalLiteral rewrites a numeric literal into plain decimal notation without changing its exact value,
+// so it means the same in every arithmetic: 0x1F is 31, 1_000 is 1000, 1.50 is 1.5, .5 is 0.5 and 2E+03 is 2e3.
+func canonicalLiteral(literal string) string {
+	mantissa, exponent, _ := strings.Cut(strings.ToLower(decimalLiteral(literal)), "e")
+	whole, fraction, _ := strings.Cut(mantissa, ".")
+
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	result := whole
+	if fraction = strings.TrimRight(fraction, "0"); fraction != "" {
+		result += "." + fraction
+	}
+
+	sign := ""
+	if exponent != "" && (exponent[0] == '+' || exponent[0] == '-') {
+		sign, exponent = strings.TrimPrefix(exponent[:1], "+"), exponent[1:]
+	}
+	if exponent = strings.TrimLeft(exponent, "0"); exponent != "" && result != "0" {
+		result += "e" + sign + exponent
+	}
+
+	if strings.HasSuffix(literal, "i") {
+		result += "i"
+	}
+	return result
+}
+
+// formatNumber prints a real number as a literal the tokenizer accepts.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// markupPrecedence is the precedence of a node in LaTeX and MathML: fractions and conditionals are drawn
+// as two-dimensional blocks that need no parentheses around them.
+func markupPrecedence(node Node) int {
+	switch n := node.(type) {
+	case *GroupNode:
+		return markupPrecedence(n.Inner)
+	case *ConditionalNode:
+		return atomPrecedence
+	case *BinaryNode:
+		if n.Op == "/" || n.Op == "//" {
+			return atomPrecedence
+		}
+	case *NumberNode:
+		if strings.Contains(canonicalLiteral(n.Literal), "e") {
+			return productLevel + 1 // 2.5e3 рисуется как произведение 2.5·10³.
+		}
+	}
+	return precedence(node)
+}
+
+// greekLetters lists the variable names drawn as Greek letters.
+var greekLetters = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "theta": "θ", "lambda": "λ",
+	"mu": "μ", "pi": "π", "rho": "ρ", "sigma": "σ", "tau": "τ", "phi": "φ", "omega": "ω",
+}
+
+// latexOperators maps operators to LaTeX; operators not listed are written as is.
+var latexOperators = map[string]string{
+	"*": `\cdot`, "%": `\bmod`, "==": "=", "!=": `\neq`, "<=": `\leq`, ">=": `\geq`,
+	"and": `\land`, "or": `\lor`, "not": `\lnot`, "&": `\mathbin{\&}`, "|": `\mathbin{|}`,
+	"xor": `\oplus`, "<<": `\ll`, ">>": `\gg`, "~": `\mathord{\sim}`,
+}
+
+// latexFunctions maps built-in functions that LaTeX has commands for.
+var latexFunctions = map[string]string{
+	"sin": `\sin`, "cos": `\cos`, "log": `\ln`, "min": `\min`, "max": `\max`, "det": `\det`,
+}
+
+// printLaTeX writes the node as LaTeX, in parentheses if its precedence is lower than minimum.
+func printLaTeX(b *strings.Builder, node Node, minimum int) {
+	if group, ok := node.(*GroupNode); ok {
+		printLaTeX(b, group.Inner, minimum)
+		return
+	}
+	if markupPrecedence(node) < minimum {
+		b.WriteString(`\left(`)
+		defer b.WriteString(`\right)`)
+	}
+
+	switch n := node.(type) {
+	case *NumberNode:
+		literal := canonicalLiteral(n.Literal)
+		imaginary := strings.HasSuffix(literal, "i")
+		mantissa, exponent, scientific := strings.Cut(strings.TrimSuffix(literal, "i"), "e")
+		b.WriteString(mantissa)
+		if scientific {
+			b.WriteString(` \cdot 10^{` + exponent + `}`)
+		}
+		if imaginary {
+			b.WriteString(`\,i`)
+		}
+	case *IdentNode:
+		b.WriteString(latexName(n.Name))
+	case *UnaryNode:
+		if op, ok := latexOperators[n.Op]; ok {
+			b.WriteString(op + " ")
+		} else {
+			b.WriteString(n.Op)
+		}
+		if n.Op == "not" {
+			printLaTeX(b, n.Operand, notLevel+1)
+			return
+		}
+		printLaTeX(b, n.Operand, atomPrecedence)
+	case *BinaryNode:
+		switch n.Op {
+		case "/":
+			b.WriteString(`\frac{`)
+			printLaTeX(b, n.Left, conditionalPrecedence)
+			b.WriteString("}{")
+			printLaTeX(b, n.Right, conditionalPrecedence)
+			b.WriteString("}")
+		case "//":
+			b.WriteString(`\left\lfloor \frac{`)
+			printLaTeX(b, n.Left, conditionalPrecedence)
+			b.WriteString("}{")
+			printLaTeX(b, n.Right, conditionalPrecedence)
+			b.WriteString(`} \right\rfloor`)
+		case "^":
+			b.WriteString("{")
+			printLaTeX(b, n.Left, atomPrecedence+latexBaseGuard(n.Left))
+			b.WriteString("}^{")
+			printLaTeX(b, n.Right, conditionalPrecedence)
+			b.WriteString("}")
+		default:
+			level := precedence(n)
+			printLaTeX(b, n.Left, level)
+			op, ok := latexOperators[n.Op]
+			if !ok {
+				op = n.Op
+			}
+			b.WriteString(" " + op + " ")
+			printLaTeX(b, n.Right, level+1)
+		}
+	case *CallNode:
+		printLaTeXCall(b, n)
+	case *ListNode:
+		b.WriteString(`\begin{bmatrix} `)
+		for i, row := range matrixRows(n) {
+			if i > 0 {
+				b.WriteString(` \\ `)
+			}
+			for j, element := range row {
+				if j > 0 {
+					b.WriteString(" & ")
+				}
+				printLaTeX(b, element, conditionalPrecedence)
+			}
+		}
+		b.WriteString(` \end{bmatrix}`)
+	case *ConditionalNode:
+		b.WriteString(`\begin{cases} `)
+		printLaTeX(b, n.Then, conditionalPrecedence)
+		b.WriteString(` & \text{if } `)
+		printLaTeX(b, n.Cond, conditionalPrecedence)
+		b.WriteString(` \\ `)
+		printLaTeX(b, n.Else, conditionalPrecedence)
+		b.WriteString(` & \text{otherwise} \end{cases}`)
+	case *ProgramNode:
+		for _, binding := range n.Bindings {
+			printLaTeX(b, &IdentNode{Name: binding.Name}, atomPrecedence)
+			b.WriteString(" = ")
+			printLaTeX(b, binding.Value, conditionalPrecedence)
+			b.WriteString(`;\quad `)
+		}
+		printLaTeX(b, n.Result, conditionalPrecedence)
+	}
+}
+
+// matrixRows returns the rows a list is drawn with: a list of list literals is a matrix with a row per list,
+// any other list is a single row.
+func matrixRows(list *ListNode) [][]Node {
+	rows := make([][]Node, len(list.Elements))
+	for i, element := range list.Elements {
+		row, ok := element.(*ListNode)
+		if !ok {
+			return [][]Node{list.Elements}
+		}
+		rows[i] = row.Elements
+	}
+	return rows
+}
+
+// latexBaseGuard raises the precedence required from the base of a power for a fraction, so (a/b)^2
+// is not drawn as a fraction with a raised denominator.
+func latexBaseGuard(base Node) int {
+	for {
+		group, ok := base.(*GroupNode)
+		if !ok {
+			break
+		}
+		base = group.Inner
+	}
+	if binary, ok := base.(*BinaryNode); ok && (binary.Op == "/" || binary.Op == "//") {
+		return 1
+	}
+	return 0
+}
+
+// printLaTeXCall writes a function call; functions without a LaTeX command use \operatorname.
+func printLaTeXCall(b *strings.Builder, n *CallNode) {
+	switch {
+	case n.Name == "sqrt":
+		b.WriteString(`\sqrt{`)
+		printLaTeX(b, n.Args[0], conditionalPrecedence)
+		b.WriteString("}")
+		return
+	case n.Name == "abs":
+		b.WriteString(`\left|`)
+		printLaTeX(b, n.Args[0], conditionalPrecedence)
+		b.WriteString(`\right|`)
+		return
+	case n.Name == "log" && len(n.Args) == 2:
+		b.WriteString(`\log_{`)
+		printLaTeX(b, n.Args[1], conditionalPrecedence)
+		b.WriteString(`}\left(`)
+		printLaTeX(b, n.Args[0], conditionalPrecedence)
+		b.WriteString(`\right)`)
+		return
+	}
+
+	if command, ok := latexFunctions[n.Name]; ok {
+		b.WriteString(command)
+	} else {
+		b.WriteString(`\operatorname{` + latexEscape(n.Name) + "}")
+	}
+	b.WriteString(`\left(`)
+	for i, arg := range n.Args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		printLaTeX(b, arg, conditionalPrecedence)
+	}
+	b.WriteString(`\right)`)
+}
+
+// latexName writes a variable name: single letters as is, Greek names as letters and other names upright.
+func latexName(name string) string {
+	switch {
+	case len(name) == 1:
+		return name
+	case greekLetters[name] != "":
+		return `\` + name
+	default:
+		return `\mathrm{` + latexEscape(name) + "}"
+	}
+}
+
+// latexEscape escapes the underscores allowed in names.
+func latexEscape(name string) string {
+	return strings.ReplaceAll(name, "_", `\_`)
+}
+
+// mathMLOperators maps operators to the characters MathML draws; operators not listed are written as is.
+var mathMLOperators = map[string]string{
+	"*": "⋅", "%": "mod", "==": "=", "!=": "≠", "<": "&lt;", "<=": "≤", ">": "&gt;", ">=": "≥",
+	"and": "∧", "or": "∨", "not": "¬", "&": "&amp;", "xor": "⊕", "<<": "≪", ">>": "≫", "~": "∼", "-": "−",
+}
+
+// printMathML writes the node as presentation MathML, in parentheses if its precedence is lower than minimum.
+func printMathML(b *strings.Builder, node Node, minimum int) {
+	if group, ok := node.(*GroupNode); ok {
+		printMathML(b, group.Inner, minimum)
+		return
+	}
+	b.WriteString("<mrow>")
+	defer b.WriteString("</mrow>")
+	if markupPrecedence(node) < minimum {
+		b.WriteString("<mo>(</mo>")
+		defer b.WriteString("<mo>)</mo>")
+	}
+
+	switch n := node.(type) {
+	case *NumberNode:
+		literal := canonicalLiteral(n.Literal)
+		imaginary := strings.HasSuffix(literal, "i")
+		mantissa, exponent, scientific := strings.Cut(strings.TrimSuffix(literal, "i"), "e")
+		b.WriteString("<mn>" + mantissa + "</mn>")
+		if scientific {
+			b.WriteString("<mo>×</mo><msup><mn>10</mn><mn>" + exponent + "</mn></msup>")
+		}
+		if imaginary {
+			b.WriteString("<mi>i</mi>")
+		}
+	case *IdentNode:
+		if letter, ok := greekLetters[n.Name]; ok {
+			b.WriteString("<mi>" + letter + "</mi>")
+		} else {
+			b.WriteString("<mi>" + n.Name + "</mi>")
+		}
+	case *UnaryNode:
+		b.WriteString("<mo>" + mathMLOperator(n.Op) + "</mo>")
+		if n.Op == "not" {
+			printMathML(b, n.Operand, notLevel+1)
+			return
+		}
+		printMathML(b, n.Operand, atomPrecedence)
+	case *BinaryNode:
+		switch n.Op {
+		case "/", "//":
+			if n.Op == "//" {
+				b.WriteString("<mo>⌊</mo>")
+			}
+			b.WriteString("<mfrac>")
+			printMathML(b, n.Left, conditionalPrecedence)
+			printMathML(b, n.Right, conditionalPrecedence)
+			b.WriteString("</mfrac>")
+			if n.Op == "//" {
+				b.WriteString("<mo>⌋</mo>")
+			}
+		case "^":
+			b.WriteString("<msup>")
+			printMathML(b, n.Left, atomPrecedence+latexBaseGuard(n.Left))
+			printMathML(b, n.Right, conditionalPrecedence)
+			b.WriteString("</msup>")
+		default:
+			level := precedence(n)
+			printMathML(b, n.Left, level)
+			b.WriteString("<mo>" + mathMLOperator(n.Op) + "</mo>")
+			printMathML(b, n.Right, level+1)
+		}
+	case *CallNode:
+		printMathMLCall(b, n)
+	case *ListNode:
+		b.WriteString("<mo>[</mo><mtable>")
+		for _, row := range matrixRows(n) {
+			b.WriteString("<mtr>")
+			for _, element := range row {
+				b.WriteString("<mtd>")
+				printMathML(b, element, conditionalPrecedence)
+				b.WriteString("</mtd>")
+			}
+			b.WriteString("</mtr>")
+		}
+		b.WriteString("</mtable><mo>]</mo>")
+	case *ConditionalNode:
+		b.WriteString("<mo>{</mo><mtable><mtr><mtd>")
+		printMathML(b, n.Then, conditionalPrecedence)
+		b.WriteString("</mtd><mtd><mtext>if </mtext>")
+		printMathML(b, n.Cond, conditionalPrecedence)
+		b.WriteString("</mtd></mtr><mtr><mtd>")
+		printMathML(b, n.Else, conditionalPrecedence)
+		b.WriteString("</mtd><mtd><mtext>otherwise</mtext></mtd></mtr></mtable>")
+	case *ProgramNode:
+		for _, binding := range n.Bindings {
+			b.WriteString("<mrow>")
+			printMathML(b, &IdentNode{Name: binding.Name}, atomPrecedence)
+			b.WriteString("<mo>=</mo>")
+			printMathML(b, binding.Value, conditionalPrecedence)
+			b.WriteString(`</mrow><mo separator="true">;</mo>`)
+		}
+		printMathML(b, n.Result, conditionalPrecedence)
+	}
+}
+
+// printMathMLCall writes a function call; the square root and the absolute value are drawn with their symbols.
+func printMathMLCall(b *strings.Builder, n *CallNode) {
+	switch {
+	case n.Name == "sqrt":
+		b.WriteString("<msqrt>")
+		printMathML(b, n.Args[0], conditionalPrecedence)
+		b.WriteString("</msqrt>")
+		return
+	case n.Name == "abs":
+		b.WriteString("<mo>|</mo>")
+		printMathML(b, n.Args[0], conditionalPrecedence)
+		b.WriteString("<mo>|</mo>")
+		return
+	case n.Name == "log" && len(n.Args) == 2:
+		b.WriteString("<msub><mi>log</mi>")
+		printMathML(b, n.Args[1], conditionalPrecedence)
+		b.WriteString("</msub>")
+		n = &CallNode{Name: n.Name, Args: n.Args[:1]}
+	case n.Name == "log":
+		b.WriteString("<mi>ln</mi>")
+	default:
+		b.WriteString("<mi>" + n.Name + "</mi>")
+	}
+
+	b.WriteString("<mo>&#x2061;</mo><mo>(</mo>")
+	for i, arg := range n.Args {
+		if i > 0 {
+			b.WriteString("<mo>,</mo>")
+		}
+		printMathML(b, arg, conditionalPrecedence)
+	}
+	b.WriteString("<mo>)</mo>")
+}
+
+// mathMLOperator returns the character drawn for an operator.
+func mathMLOperator(op string) string {
+	if symbol, ok := mathMLOperators[op]; ok {
+		return symbol
+	}
+	return op
+}
@@ -0,0 +1,233 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+
+	"distributed_calculator/internal/constants"
+)
+
+// Variadic marks a function that accepts any number of arguments above MinArgs.
+const Variadic = -1
+
+// Function describes a built-in function available in expressions.
+// The registry is shared by the local evaluator and the agents, so a call is computed the same way on both paths.
+type Function struct {
+	Name    string                                // Name used in expressions.
+	MinArgs int                                   // Minimum number of arguments.
+	MaxArgs int                                   // Maximum number of arguments or Variadic.
+	Cost    int64                                 // Relative cost of the call used to simulate computation time.
+	Eval    func(args []float64) (float64, error) // Implementation of the function; nil for the functions that only rearrange arrays.
+	// Complex is the implementation for complex arguments, also used when a real argument is
+	// outside the real domain, e.g. sqrt(-4). Nil means the function is defined only for real numbers.
+	Complex func(args []complex128) (complex128, error)
+}
+
+var functions = map[string]Function{
+	"sqrt":  {Name: "sqrt", MinArgs: 1, MaxArgs: 1, Cost: 2, Eval: evalSqrt, Complex: complexUnary(cmplx.Sqrt)},
+	"sin":   {Name: "sin", MinArgs: 1, MaxArgs: 1, Cost: 3, Eval: unary(math.Sin), Complex: complexUnary(cmplx.Sin)},
+	"cos":   {Name: "cos", MinArgs: 1, MaxArgs: 1, Cost: 3, Eval: unary(math.Cos), Complex: complexUnary(cmplx.Cos)},
+	"log":   {Name: "log", MinArgs: 1, MaxArgs: 2, Cost: 3, Eval: evalLog, Complex: evalComplexLog},
+	"abs":   {Name: "abs", MinArgs: 1, MaxArgs: 1, Cost: 1, Eval: unary(math.Abs), Complex: evalComplexAbs},
+	"min":   {Name: "min", MinArgs: 1, MaxArgs: Variadic, Cost: 1, Eval: evalMin},
+	"max":   {Name: "max", MinArgs: 1, MaxArgs: Variadic, Cost: 1, Eval: evalMax},
+	"round": {Name: "round", MinArgs: 1, MaxArgs: 2, Cost: 1, Eval: evalRound},
+
+	// Функции массивов. Агенты получают sum, mean, dot и det с развёрнутыми в список элементами массивов:
+	// dot — элементы обоих векторов подряд, det — элементы матрицы построчно.
+	"sum":       {Name: "sum", MinArgs: 1, MaxArgs: Variadic, Cost: 1, Eval: evalSum, Complex: complexSum},
+	"mean":      {Name: "mean", MinArgs: 1, MaxArgs: Variadic, Cost: 1, Eval: evalMean, Complex: complexMean},
+	"dot":       {Name: "dot", MinArgs: 2, MaxArgs: Variadic, Cost: 2, Eval: evalDot, Complex: complexDot},
+	"det":       {Name: "det", MinArgs: 1, MaxArgs: Variadic, Cost: 4, Eval: evalDet, Complex: complexDet},
+	"matmul":    {Name: "matmul", MinArgs: 2, MaxArgs: 2, Cost: 1},
+	"transpose": {Name: "transpose", MinArgs: 1, MaxArgs: 1, Cost: 1},
+}
+
+// LookupFunction returns the built-in function with the given name.
+func LookupFunction(name string) (Function, bool) {
+	fn, ok := functions[name]
+	return fn, ok
+}
+
+// FunctionNames returns the names of all built-in functions in alphabetical order.
+func FunctionNames() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckArity validates the number of arguments passed to the function.
+func (f Function) CheckArity(count int) error {
+	if count < f.MinArgs || (f.MaxArgs != Variadic && count > f.MaxArgs) {
+		return fmt.Errorf("%s: %s expects %s, got %d",
+			constants.ErrWrongArgumentCount, f.Name, f.arityString(), count)
+	}
+	return nil
+}
+
+// Call validates the arguments and invokes the function.
+func (f Function) Call(args []float64) (float64, error) {
+	if err := f.CheckArity(len(args)); err != nil {
+		return 0, err
+	}
+	return f.Eval(args)
+}
+
+// arityString describes the accepted number of arguments.
+func (f Function) arityString() string {
+	switch {
+	case f.MaxArgs == Variadic:
+		return fmt.Sprintf("at least %d argument(s)", f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("%d argument(s)", f.MinArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", f.MinArgs, f.MaxArgs)
+	}
+}
+
+// unary adapts a single-argument math function to the registry signature.
+func unary(fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}
+}
+
+// complexUnary adapts a single-argument complex function to the registry signature.
+func complexUnary(fn func(complex128) complex128) func([]complex128) (complex128, error) {
+	return func(args []complex128) (complex128, error) {
+		return fn(args[0]), nil
+	}
+}
+
+func evalSqrt(args []float64) (float64, error) {
+	if args[0] < 0 {
+		return 0, errors.New(constants.ErrNegativeSqrt)
+	}
+	return math.Sqrt(args[0]), nil
+}
+
+// evalLog computes the natural logarithm, or the logarithm to the base given as the second argument.
+func evalLog(args []float64) (float64, error) {
+	if args[0] <= 0 {
+		return 0, errors.New(constants.ErrInvalidLogarithm)
+	}
+	if len(args) == 1 {
+		return math.Log(args[0]), nil
+	}
+	if args[1] <= 0 || args[1] == 1 {
+		return 0, errors.New(constants.ErrInvalidLogarithmBase)
+	}
+	return math.Log(args[0]) / math.Log(args[1]), nil
+}
+
+// evalComplexAbs computes the modulus of a complex number.
+func evalComplexAbs(args []complex128) (complex128, error) {
+	return complex(cmplx.Abs(args[0]), 0), nil
+}
+
+// evalComplexLog computes the principal value of the logarithm of a complex number.
+func evalComplexLog(args []complex128) (complex128, error) {
+	if args[0] == 0 {
+		return 0, errors.New(constants.ErrInvalidLogarithm)
+	}
+	if len(args) == 1 {
+		return cmplx.Log(args[0]), nil
+	}
+	if args[1] == 0 || args[1] == 1 {
+		return 0, errors.New(constants.ErrInvalidLogarithmBase)
+	}
+	return cmplx.Log(args[0]) / cmplx.Log(args[1]), nil
+}
+
+func evalMin(args []float64) (float64, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Min(result, arg)
+	}
+	return result, nil
+}
+
+func evalMax(args []float64) (float64, error) {
+	result := args[0]
+	for _, arg := range args[1:] {
+		result = math.Max(result, arg)
+	}
+	return result, nil
+}
+
+// evalRound rounds to the nearest integer, or to the number of decimal places given as the second argument.
+func evalRound(args []float64) (float64, error) {
+	if len(args) == 1 {
+		return math.Round(args[0]), nil
+	}
+	if args[1] != math.Trunc(args[1]) {
+		return 0, errors.New(constants.ErrInvalidRoundDigits)
+	}
+	scale := math.Pow(10, args[1])
+	return math.Round(args[0]*scale) / scale, nil
+}
+
+func evalSum(args []float64) (float64, error) {
+	total := 0.0
+	for _, arg := range args {
+		total += arg
+	}
+	return total, nil
+}
+
+func evalMean(args []float64) (float64, error) {
+	total, _ := evalSum(args)
+	return total / float64(len(args)), nil
+}
+
+// evalDot computes the dot product of two vectors whose elements are passed one after the other.
+func evalDot(args []float64) (float64, error) {
+	if len(args)%2 != 0 {
+		return 0, fmt.Errorf("%s: dot expects two vectors of the same length", constants.ErrShapeMismatch)
+	}
+	half, total := len(args)/2, 0.0
+	for i := 0; i < half; i++ {
+		total += args[i] * args[half+i]
+	}
+	return total, nil
+}
+
+// evalDet computes the determinant of a square matrix whose elements are passed row by row.
+func evalDet(args []float64) (float64, error) {
+	return determinant(args, math.Abs)
+}
+
+func complexSum(args []complex128) (complex128, error) {
+	var total complex128
+	for _, arg := range args {
+		total += arg
+	}
+	return total, nil
+}
+
+func complexMean(args []complex128) (complex128, error) {
+	total, _ := complexSum(args)
+	return total / complex(float64(len(args)), 0), nil
+}
+
+func complexDot(args []complex128) (complex128, error) {
+	if len(args)%2 != 0 {
+		return 0, fmt.Errorf("%s: dot expects two vectors of the same length", constants.ErrShapeMismatch)
+	}
+	half := len(args) / 2
+	var total complex128
+	for i := 0; i < half; i++ {
+		total += args[i] * args[half+i]
+	}
+	return total, nil
+}
+
+func complexDet(args []complex128) (complex128, error) {
+	return determinant(args, cmplx.Abs)
+}
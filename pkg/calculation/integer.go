@@ -0,0 +1,176 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"distributed_calculator/internal/constants"
+)
+
+// intNumber is a value in int64 mode.
+type intNumber int64
+
+func (n intNumber) String() string {
+	return strconv.FormatInt(int64(n), 10)
+}
+
+func (n intNumber) Float64() float64 {
+	return float64(n)
+}
+
+// isBitwise reports whether the operator is defined only for integers.
+func isBitwise(op string) bool {
+	switch op {
+	case "&", "|", "xor", "<<", ">>", "~":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseInt converts a literal or a serialized value into an int64.
+// Integral decimals such as 2.0 are accepted; fractions and out-of-range values are rejected.
+func parseInt(text string) (Number, error) {
+	rat, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", constants.ErrInvalidNumberFormat, text)
+	}
+	return ratToInt(rat)
+}
+
+// ratToInt converts an exact value into an int64.
+func ratToInt(rat *big.Rat) (Number, error) {
+	if !rat.IsInt() {
+		return nil, fmt.Errorf("%s: %s", constants.ErrIntegerRequired, rat.RatString())
+	}
+	if !rat.Num().IsInt64() {
+		return nil, fmt.Errorf("%s: %s", constants.ErrIntegerOverflow, rat.RatString())
+	}
+	return intNumber(rat.Num().Int64()), nil
+}
+
+// floatToInt converts a float64, e.g. a variable binding, into an int64.
+func floatToInt(value float64) (Number, error) {
+	if value != math.Trunc(value) {
+		return nil, fmt.Errorf("%s: %s", constants.ErrIntegerRequired, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	if value < math.MinInt64 || value >= math.MaxInt64 {
+		return nil, fmt.Errorf("%s: %s", constants.ErrIntegerOverflow, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	return intNumber(int64(value)), nil
+}
+
+// unaryInt applies a prefix operator to an int64.
+func unaryInt(op string, x int64) (Number, error) {
+	switch op {
+	case "-":
+		if x == math.MinInt64 {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		return intNumber(-x), nil
+	case "~":
+		return intNumber(^x), nil
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// applyInt performs a single binary operation on int64 values, reporting overflow instead of wrapping.
+// Division truncates towards zero; // rounds towards negative infinity.
+func applyInt(op string, x, y int64) (Number, error) {
+	switch op {
+	case "+":
+		sum := x + y
+		if (x > 0 && y > 0 && sum < 0) || (x < 0 && y < 0 && sum >= 0) {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		return intNumber(sum), nil
+	case "-":
+		diff := x - y
+		if (x >= 0 && y < 0 && diff < 0) || (x < 0 && y > 0 && diff >= 0) {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		return intNumber(diff), nil
+	case "*":
+		if x == 0 || y == 0 {
+			return intNumber(0), nil
+		}
+		product := x * y
+		if product/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		return intNumber(product), nil
+	case "/", "//":
+		if y == 0 {
+			return nil, errors.New(constants.ErrDivisionByZero)
+		}
+		if x == math.MinInt64 && y == -1 {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		quotient := x / y
+		if op == "//" && x%y != 0 && (x < 0) != (y < 0) {
+			quotient--
+		}
+		return intNumber(quotient), nil
+	case "%":
+		if y == 0 {
+			return nil, errors.New(constants.ErrModuloByZero)
+		}
+		return intNumber(x % y), nil
+	case "^":
+		return powInt(x, y)
+	case "&":
+		return intNumber(x & y), nil
+	case "|":
+		return intNumber(x | y), nil
+	case "xor":
+		return intNumber(x ^ y), nil
+	case "<<":
+		if y < 0 || y > 63 {
+			return nil, fmt.Errorf("%s: %d", constants.ErrInvalidShift, y)
+		}
+		shifted := x << y
+		if shifted>>y != x {
+			return nil, errors.New(constants.ErrIntegerOverflow)
+		}
+		return intNumber(shifted), nil
+	case ">>":
+		if y < 0 || y > 63 {
+			return nil, fmt.Errorf("%s: %d", constants.ErrInvalidShift, y)
+		}
+		return intNumber(x >> y), nil
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// powInt raises x to the power y; negative exponents are allowed only when the result is an integer.
+func powInt(x, y int64) (Number, error) {
+	if y < 0 {
+		switch x {
+		case 0:
+			return nil, errors.New(constants.ErrDivisionByZero)
+		case 1:
+			return intNumber(1), nil
+		case -1:
+			if y%2 == 0 {
+				return intNumber(1), nil
+			}
+			return intNumber(-1), nil
+		default:
+			return nil, fmt.Errorf("%s: ^", constants.ErrInexactResult)
+		}
+	}
+	// При |x| >= 2 степень больше 63 заведомо переполняет int64.
+	if y > 64 && x != 0 && x != 1 && x != -1 {
+		return nil, errors.New(constants.ErrIntegerOverflow)
+	}
+	result := new(big.Int).Exp(big.NewInt(x), big.NewInt(y), nil)
+	if !result.IsInt64() {
+		return nil, errors.New(constants.ErrIntegerOverflow)
+	}
+	return intNumber(result.Int64()), nil
+}
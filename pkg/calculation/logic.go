@@ -0,0 +1,125 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"distributed_calculator/internal/constants"
+)
+
+// isComparison reports whether the operator compares its operands and yields 1 or 0.
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// isLogical reports whether the operator combines the truth values of its operands.
+func isLogical(op string) bool {
+	return op == "and" || op == "or"
+}
+
+// IsTrue reports whether a number is true as a condition: any non-zero value is true.
+func (a Arithmetic) IsTrue(x Number) bool {
+	switch n := x.(type) {
+	case intNumber:
+		return n != 0
+	case complexNumber:
+		return n != 0
+	case floatNumber:
+		return n != 0
+	default:
+		return a.rat(x).Sign() != 0
+	}
+}
+
+// boolean converts a truth value into the number 1 or 0 of the mode.
+func (a Arithmetic) boolean(value bool) Number {
+	var n int64
+	if value {
+		n = 1
+	}
+	switch {
+	case a.mode() == ModeInt64:
+		return intNumber(n)
+	case !a.IsExact():
+		return floatNumber(n)
+	default:
+		return a.wrap(new(big.Rat).SetInt64(n))
+	}
+}
+
+// logic applies the logical operators and and or. Both operands are always evaluated.
+func (a Arithmetic) logic(op string, left, right Number) Number {
+	if op == "and" {
+		return a.boolean(a.IsTrue(left) && a.IsTrue(right))
+	}
+	return a.boolean(a.IsTrue(left) || a.IsTrue(right))
+}
+
+// compare applies a comparison operator. Complex numbers can only be tested for equality.
+func (a Arithmetic) compare(op string, left, right Number) (Number, error) {
+	var cmp int
+	switch {
+	case a.mode() == ModeInt64:
+		x, err := a.integer(left)
+		if err != nil {
+			return nil, err
+		}
+		y, err := a.integer(right)
+		if err != nil {
+			return nil, err
+		}
+		cmp = big.NewInt(x).Cmp(big.NewInt(y))
+	case !a.IsExact():
+		x, y := ToComplex(left), ToComplex(right)
+		if imag(x) != 0 || imag(y) != 0 {
+			if op != "==" && op != "!=" {
+				return nil, errors.New(constants.ErrComplexComparison)
+			}
+			return a.boolean((x == y) == (op == "==")), nil
+		}
+		// Сравнение float64 выполняется напрямую, чтобы NaN не был равен ничему.
+		return a.boolean(compareFloat(op, real(x), real(y))), nil
+	default:
+		cmp = a.rat(left).Cmp(a.rat(right))
+	}
+
+	switch op {
+	case "==":
+		return a.boolean(cmp == 0), nil
+	case "!=":
+		return a.boolean(cmp != 0), nil
+	case "<":
+		return a.boolean(cmp < 0), nil
+	case "<=":
+		return a.boolean(cmp <= 0), nil
+	case ">":
+		return a.boolean(cmp > 0), nil
+	case ">=":
+		return a.boolean(cmp >= 0), nil
+	default:
+		return nil, fmt.Errorf("%s: %s", constants.ErrUnexpectedToken, op)
+	}
+}
+
+// compareFloat applies a comparison operator to float64 values.
+func compareFloat(op string, x, y float64) bool {
+	switch op {
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
@@ -0,0 +1,131 @@
+package calculation
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Mode selects the number system used to evaluate an expression.
+type Mode string
+
+const (
+	ModeFloat64  Mode = "float64"  // IEEE-754 double precision, the default.
+	ModeDecimal  Mode = "decimal"  // Exact decimal fractions rounded to a fixed number of digits.
+	ModeRational Mode = "rational" // Exact fractions of arbitrary size.
+	ModeInt64    Mode = "int64"    // 64-bit integers with overflow detection and bitwise operators.
+)
+
+const (
+	DefaultDecimalPrecision = 20   // Fractional digits in decimal mode when none are requested.
+	MaxDecimalPrecision     = 1000 // Upper limit of fractional digits in decimal mode.
+)
+
+// Number is a value of one of the supported number systems.
+type Number interface {
+	// String returns the textual form used on the wire and in responses.
+	String() string
+	// Float64 returns the nearest float64 approximation.
+	Float64() float64
+}
+
+// floatNumber is a value in float64 mode.
+type floatNumber float64
+
+// complexNumber is a value with a non-zero imaginary part in float64 mode.
+type complexNumber complex128
+
+// decimalNumber is a value in decimal mode, already rounded to digits fractional digits.
+type decimalNumber struct {
+	rat    *big.Rat
+	digits int
+}
+
+func (n floatNumber) String() string {
+	return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
+func (n floatNumber) Float64() float64 {
+	return float64(n)
+}
+
+// String formats the value as re+imi, e.g. 3-4i, omitting a zero real part.
+func (n complexNumber) String() string {
+	re, im := real(n), imag(n)
+	imText := strconv.FormatFloat(im, 'g', -1, 64) + "i"
+	if re == 0 {
+		return imText
+	}
+	if im >= 0 {
+		imText = "+" + imText
+	}
+	return strconv.FormatFloat(re, 'g', -1, 64) + imText
+}
+
+// Float64 returns the real part.
+func (n complexNumber) Float64() float64 {
+	return real(n)
+}
+
+// ToComplex returns the value of a number as complex128; numbers of all modes except complex ones are real.
+func ToComplex(n Number) complex128 {
+	if c, ok := n.(complexNumber); ok {
+		return complex128(c)
+	}
+	return complex(n.Float64(), 0)
+}
+
+// newComplex returns a complex number, or a real one when the imaginary part is zero.
+func newComplex(value complex128) Number {
+	if imag(value) == 0 {
+		return floatNumber(real(value))
+	}
+	return complexNumber(value)
+}
+
+// String formats the value without trailing zeros, e.g. 0.3 rather than 0.30000.
+func (n decimalNumber) String() string {
+	text := n.rat.FloatString(n.digits)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(text, "0")
+		text = strings.TrimSuffix(text, ".")
+	}
+	if text == "-0" {
+		return "0"
+	}
+	return text
+}
+
+func (n decimalNumber) Float64() float64 {
+	f, _ := n.rat.Float64()
+	return f
+}
+
+// roundRat rounds x to the given number of fractional digits, halves away from zero.
+// Negative digits round to tens, hundreds and so on.
+func roundRat(x *big.Rat, digits int) *big.Rat {
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(digits))), nil))
+	if digits < 0 {
+		scale.Inv(scale)
+	}
+	scaled := new(big.Rat).Mul(x, scale)
+
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// |rem| * 2 >= denom означает, что дробная часть не меньше половины.
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom()) >= 0 {
+		if scaled.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+
+	return new(big.Rat).Quo(new(big.Rat).SetInt(quo), scale)
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -1,190 +1,520 @@
 package calculation
 
 import (
-	"errors"
 	"fmt"
-	"math"
-	"strconv"
+	"slices"
+	"strings"
 
 	"distributed_calculator/internal/constants"
 	"go.uber.org/zap"
 )
 
 // Parser represents a mathematical expression parser.
+// It builds an abstract syntax tree from the tokens of an expression.
 type Parser struct {
-	tokens []string // Tokens of the expression to be parsed.
-	pos    int      // Current position in the tokens slice.
+	source    string    // Source expression, used to locate errors.
+	tokens    []Token   // Tokens of the expression to be parsed.
+	pos       int       // Current position in the tokens slice.
+	functions Functions // User-defined functions that the expression may call.
 }
 
-// parse evaluates the entire expression and returns the result.
-// It ensures that all tokens are consumed and returns an error if unexpected tokens remain.
-func (p *Parser) parse() (float64, error) {
-	result, err := p.parseExpression()
+// Parse tokenizes and parses an expression into an abstract syntax tree.
+func Parse(expression string) (Node, error) {
+	return ParseWithFunctions(expression, nil)
+}
+
+// ParseWithFunctions parses an expression that may call the given user-defined functions
+// in addition to the built-in ones; only the names and parameters of the functions are used.
+func ParseWithFunctions(expression string, functions Functions) (Node, error) {
+	tokens, err := Tokenize(expression)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if p.pos < len(p.tokens) {
-		return 0, errors.New(constants.ErrUnexpectedToken)
+	if len(tokens) == 0 {
+		return nil, newParseError(expression, constants.CodeEmptyExpression, constants.ErrEmptyExpression,
+			endOfInput(expression), expectOperand...)
 	}
-	return result, nil
+
+	parser := &Parser{source: expression, tokens: tokens, pos: 0, functions: functions}
+	return parser.parse()
 }
 
-// parseExpression parses addition and subtraction operations.
-func (p *Parser) parseExpression() (float64, error) {
-	left, err := p.parseTerm()
-	if err != nil {
-		return 0, err
+// parse parses the entire input: a single expression or a program of bindings ending with an expression,
+// e.g. a = 2+3; b = a*4; b^2 - a. A trailing semicolon is allowed.
+// It ensures that all tokens are consumed and returns an error if unexpected tokens remain.
+func (p *Parser) parse() (Node, error) {
+	program := &ProgramNode{}
+	for {
+		statement := p.tokens[p.pos]
+		var node Node
+		if p.startsBinding() {
+			binding, err := p.parseBinding(program)
+			if err != nil {
+				return nil, err
+			}
+			program.Bindings = append(program.Bindings, binding)
+		} else {
+			var err error
+			if node, err = p.parseExpression(); err != nil {
+				return nil, err
+			}
+		}
+
+		if p.pos < len(p.tokens) && p.tokens[p.pos].Kind == TokenSemicolon {
+			p.pos++
+		} else if p.pos < len(p.tokens) {
+			if p.tokens[p.pos].Kind == TokenRightParen {
+				return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedParentheses,
+					expectStatementEnd...)
+			}
+			if p.tokens[p.pos].Kind == TokenRightBracket {
+				return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedBrackets,
+					expectStatementEnd...)
+			}
+			return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnexpectedToken, constants.ErrInvalidStructure,
+				expectStatementEnd...)
+		}
+
+		if p.pos == len(p.tokens) {
+			if node == nil {
+				return nil, p.errorAtEnd(constants.CodeInvalidStatement, constants.ErrMissingResult, expectOperand...)
+			}
+			if len(program.Bindings) == 0 {
+				return node, nil // Одиночное выражение остаётся выражением, а не программой.
+			}
+			program.Result = node
+			program.Position = p.tokens[0].Pos
+			return program, nil
+		}
+		if node != nil {
+			// Значение промежуточного выражения никуда не попадает.
+			return nil, p.errorAt(statement, constants.CodeInvalidStatement, constants.ErrUnusedStatement)
+		}
 	}
+}
 
-	for p.pos < len(p.tokens) {
-		op := p.tokens[p.pos]
-		if op != "+" && op != "-" {
-			break
+// startsBinding checks if the current statement is a binding: a name followed by =.
+func (p *Parser) startsBinding() bool {
+	return p.pos+1 < len(p.tokens) && p.tokens[p.pos].Kind == TokenIdentifier && p.tokens[p.pos+1].Kind == TokenAssign
+}
+
+// parseBinding parses a binding name = expression of the program.
+// A name may be bound once and only after its value is bound: names of later bindings
+// may not be referenced, because they would silently refer to a variable of the request instead.
+func (p *Parser) parseBinding(program *ProgramNode) (Binding, error) {
+	name := p.tokens[p.pos]
+	if isReserved(name.Text) || p.functions[name.Text] != nil {
+		return Binding{}, p.errorAt(name, constants.CodeInvalidBinding,
+			fmt.Sprintf("%s '%s'", constants.ErrInvalidBinding, name.Text))
+	}
+	for _, binding := range program.Bindings {
+		if binding.Name == name.Text {
+			return Binding{}, p.errorAt(name, constants.CodeInvalidBinding,
+				fmt.Sprintf("%s '%s'", constants.ErrDuplicateBinding, name.Text))
 		}
-		p.pos++
+	}
+	p.pos += 2
 
-		right, err := p.parseTerm()
-		if err != nil {
-			return 0, err
+	value, err := p.parseExpression()
+	if err != nil {
+		return Binding{}, err
+	}
+	// Имя, которое будет связано позже, нельзя использовать раньше, в том числе в собственном значении.
+	later := p.laterBindings()
+	var unbound *IdentNode
+	Walk(value, func(n Node) bool {
+		if ident, ok := n.(*IdentNode); ok && unbound == nil && (ident.Name == name.Text || later[ident.Name]) {
+			unbound = ident
 		}
+		return unbound == nil
+	})
+	if unbound != nil {
+		return Binding{}, p.errorAt(Token{Kind: TokenIdentifier, Text: unbound.Name, Pos: unbound.Position},
+			constants.CodeInvalidBinding, fmt.Sprintf("%s '%s'", constants.ErrBindingBeforeAssignment, unbound.Name))
+	}
+	return Binding{Name: name.Text, Value: value, Position: name.Pos}, nil
+}
 
-		if op == "+" {
-			left += right
-		} else {
-			left -= right
+// laterBindings returns the names bound by the statements after the current position.
+func (p *Parser) laterBindings() map[string]bool {
+	names := make(map[string]bool)
+	for i := max(p.pos, 1); i+1 < len(p.tokens); i++ {
+		if p.tokens[i-1].Kind == TokenSemicolon && p.tokens[i].Kind == TokenIdentifier && p.tokens[i+1].Kind == TokenAssign {
+			names[p.tokens[i].Text] = true
 		}
 	}
+	return names
+}
+
+// binaryLevels lists the left-associative binary operators from the lowest precedence to the highest.
+// The conditional operator binds looser and exponentiation tighter than all of them;
+// both are parsed separately because they are right-associative.
+var binaryLevels = [][]string{
+	{"or"},
+	{"and"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"|"},
+	{"xor"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "//", "%"},
+}
+
+// notLevel is the precedence level that logical negation applies to, so not a < b means not (a < b).
+const notLevel = 2
+
+// productLevel is the precedence level of multiplication; juxtaposed operands such as 2(3+4) or 3x
+// are multiplied at this level, so 6/2(1+2) means (6/2)*(1+2).
+const productLevel = 8
+
+// parseExpression parses a complete expression starting from the lowest precedence level.
+func (p *Parser) parseExpression() (Node, error) {
+	return p.parseConditional()
+}
+
+// parseConditional parses a right-associative conditional expression cond ? then : else.
+func (p *Parser) parseConditional() (Node, error) {
+	cond, err := p.parseLevel(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].Text != "?" {
+		return cond, nil
+	}
+	question := p.tokens[p.pos]
+	p.pos++
 
-	return left, nil
+	then, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorAtEnd(constants.CodeUnexpectedEnd, constants.ErrTrailingOperator, ":")
+	}
+	if p.tokens[p.pos].Text != ":" {
+		return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnexpectedToken, constants.ErrInvalidStructure, ":")
+	}
+	p.pos++
+
+	otherwise, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+	return &ConditionalNode{Cond: cond, Then: then, Else: otherwise, Position: question.Pos}, nil
 }
 
-// parseTerm parses multiplication, division, and modulo operations.
-func (p *Parser) parseTerm() (float64, error) {
-	left, err := p.parsePower()
+// parseLevel parses a chain of left-associative operators of the given precedence level.
+func (p *Parser) parseLevel(level int) (Node, error) {
+	if level == len(binaryLevels) {
+		return p.parsePower()
+	}
+	if level == notLevel && p.pos < len(p.tokens) && p.tokens[p.pos].Text == "not" {
+		op := p.tokens[p.pos]
+		p.pos++
+		operand, err := p.parseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: op.Text, Operand: operand, Position: op.Pos}, nil
+	}
+
+	left, err := p.parseLevel(level + 1)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	for p.pos < len(p.tokens) {
 		op := p.tokens[p.pos]
-		if op != "*" && op != "/" && op != "%" {
+		if level == productLevel && startsImplicitFactor(op) {
+			right, err := p.parseLevel(level + 1)
+			if err != nil {
+				return nil, err
+			}
+			left = &BinaryNode{Op: "*", Left: left, Right: right, Position: op.Pos}
+			continue
+		}
+		if op.Kind != TokenOperator || !slices.Contains(binaryLevels[level], op.Text) {
 			break
 		}
 		p.pos++
 
-		right, err := p.parsePower()
+		right, err := p.parseLevel(level + 1)
 		if err != nil {
-			return 0, err
-		}
-
-		switch op {
-		case "*":
-			left *= right
-		case "/":
-			if right == 0 {
-				return 0, errors.New(constants.ErrDivisionByZero)
-			}
-			left /= right
-		case "%":
-			if right == 0 {
-				return 0, errors.New(constants.ErrModuloByZero)
-			}
-			if left != float64(int(left)) || right != float64(int(right)) {
-				return 0, errors.New(constants.ErrInvalidModulo)
-			}
-			left = math.Mod(left, right)
+			return nil, err
 		}
+		left = &BinaryNode{Op: op.Text, Left: left, Right: right, Position: op.Pos}
 	}
 
 	return left, nil
 }
 
-// parsePower parses exponentiation operations.
-func (p *Parser) parsePower() (float64, error) {
-	result, err := p.parseFactor()
+// startsImplicitFactor checks if a token right after an operand starts an implicit multiplication.
+// Only parentheses and names qualify: a number after an operand, as in 2 3, remains an error.
+func startsImplicitFactor(token Token) bool {
+	return token.Kind == TokenLeftParen || token.Kind == TokenIdentifier
+}
+
+// parsePower parses right-associative exponentiation operations.
+func (p *Parser) parsePower() (Node, error) {
+	base, err := p.parseFactor()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	if p.pos < len(p.tokens) && p.tokens[p.pos] == "^" {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].Text == "^" {
+		op := p.tokens[p.pos]
 		p.pos++
 
 		exponent, err := p.parsePower()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		result = math.Pow(result, exponent)
+		return &BinaryNode{Op: op.Text, Left: base, Right: exponent, Position: op.Pos}, nil
 	}
 
-	return result, nil
+	return base, nil
 }
 
-// parseFactor parses individual factors, including numbers, parentheses, and negative signs.
-func (p *Parser) parseFactor() (float64, error) {
+// parseFactor parses individual factors, including numbers, parentheses, lists, unary plus, negation and bitwise complement.
+func (p *Parser) parseFactor() (Node, error) {
 	if p.pos >= len(p.tokens) {
 		if logger != nil {
 			logger.Error(constants.LogUnexpectedEndExpr,
-				zap.Strings(constants.FieldTokens, p.tokens),
 				zap.Int(constants.FieldPosition, p.pos))
 		}
-		return 0, errors.New(constants.ErrUnexpectedEndExpr)
+		if p.pos > 0 && p.tokens[p.pos-1].Kind == TokenLeftParen {
+			return nil, p.errorAtEnd(constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedParentheses,
+				expectOperand...)
+		}
+		if p.pos > 0 && p.tokens[p.pos-1].Kind == TokenOperator {
+			return nil, p.errorAtEnd(constants.CodeUnexpectedEnd, constants.ErrTrailingOperator, expectOperand...)
+		}
+		return nil, p.errorAtEnd(constants.CodeUnexpectedEnd, constants.ErrUnexpectedEndExpr, expectOperand...)
 	}
 
 	token := p.tokens[p.pos]
 	p.pos++
 
 	switch {
-	case token == "(":
-		result, err := p.parseExpression()
+	case token.Kind == TokenLeftParen:
+		inner, err := p.parseExpression()
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogFailedParseParentheses,
 					zap.Error(err),
-					zap.Strings(constants.FieldTokens, p.tokens),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, err
+			return nil, err
 		}
-		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+		if p.pos >= len(p.tokens) {
 			if logger != nil {
 				logger.Error(constants.LogMissingCloseParen,
-					zap.Strings(constants.FieldTokens, p.tokens),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, errors.New(constants.ErrMissingCloseParen)
+			return nil, p.errorAtEnd(constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedParentheses, ")")
+		}
+		if p.tokens[p.pos].Kind != TokenRightParen {
+			return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnexpectedToken, constants.ErrInvalidStructure, ")")
 		}
 		p.pos++
-		return result, nil
-	case token == "-":
-		factor, err := p.parseFactor()
+		return &GroupNode{Inner: inner, Position: token.Pos}, nil
+	case token.Kind == TokenLeftBracket:
+		return p.parseList(token)
+	case token.Text == "+" || token.Text == "-" || token.Text == "~":
+		if p.pos < len(p.tokens) && p.tokens[p.pos].Kind == TokenOperator {
+			return nil, p.errorAt(p.tokens[p.pos], constants.CodeUnexpectedToken, constants.ErrInvalidStructure,
+				expectOperand...)
+		}
+		operand, err := p.parseFactor()
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogFailedParseNegative,
 					zap.Error(err),
-					zap.Strings(constants.FieldTokens, p.tokens),
 					zap.Int(constants.FieldPosition, p.pos))
 			}
-			return 0, err
+			return nil, err
 		}
-		return -factor, nil
-	case isNumber(token):
-		num, err := strconv.ParseFloat(token, 64)
+		if token.Text == "+" {
+			return operand, nil // Унарный плюс не меняет значение.
+		}
+		return &UnaryNode{Op: token.Text, Operand: operand, Position: token.Pos}, nil
+	case token.Kind == TokenNumber:
+		value, err := parseNumber(token.Text)
 		if err != nil {
 			if logger != nil {
 				logger.Error(constants.LogInvalidNumberFormat,
-					zap.String(constants.FieldToken, token),
+					zap.String(constants.FieldToken, token.Text),
 					zap.Error(err))
 			}
-			return 0, fmt.Errorf("invalid number: %s", token)
+			return nil, newParseError(p.source, constants.CodeInvalidNumber, err.Error(), token)
+		}
+		return &NumberNode{
+			Literal:   token.Text,
+			Value:     value,
+			Imaginary: strings.HasSuffix(token.Text, "i"),
+			Position:  token.Pos,
+		}, nil
+	case token.Kind == TokenIdentifier:
+		return p.parseIdentifier(token)
+	case token.Kind == TokenRightParen:
+		if p.pos < 2 {
+			return nil, p.errorAt(token, constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedParentheses,
+				expectOperand...)
 		}
-		return num, nil
+		if p.tokens[p.pos-2].Kind == TokenLeftParen {
+			return nil, p.errorAt(token, constants.CodeEmptyExpression, constants.ErrEmptyExpression, expectOperand...)
+		}
+		return nil, p.errorAt(token, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, expectOperand...)
+	case token.Kind == TokenRightBracket:
+		if p.pos >= 2 && p.tokens[p.pos-2].Kind == TokenLeftBracket {
+			return nil, p.errorAt(token, constants.CodeEmptyExpression, constants.ErrEmptyExpression, expectOperand...)
+		}
+		return nil, p.errorAt(token, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, expectOperand...)
 	default:
-		if logger != nil {
-			logger.Error(constants.LogUnexpectedToken,
-				zap.String(constants.FieldToken, token),
-				zap.Strings(constants.FieldTokens, p.tokens),
-				zap.Int(constants.FieldPosition, p.pos))
+		return nil, p.errorAt(token, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, expectOperand...)
+	}
+}
+
+// parseIdentifier parses a function call or a variable reference; the name token has already been consumed.
+func (p *Parser) parseIdentifier(name Token) (Node, error) {
+	hasParen := p.pos < len(p.tokens) && p.tokens[p.pos].Kind == TokenLeftParen
+	if name.Text == "if" {
+		return p.parseIf(name, hasParen)
+	}
+	fn, ok := LookupFunction(name.Text)
+	if userFn, isUser := p.functions[name.Text]; isUser && !ok {
+		return p.parseUserCall(name, userFn, hasParen)
+	}
+	switch {
+	case !ok && hasParen:
+		return nil, p.errorAt(name, constants.CodeUnknownFunction,
+			fmt.Sprintf("%s '%s'", constants.ErrUnknownFunction, name.Text))
+	case !ok:
+		return &IdentNode{Name: name.Text, Position: name.Pos}, nil
+	case !hasParen:
+		// Имена функций зарезервированы и не могут использоваться как переменные.
+		return nil, p.errorAt(name, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, "(")
+	}
+	p.pos++
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn.CheckArity(len(args)); err != nil {
+		return nil, p.errorAt(name, constants.CodeWrongArgumentCount, err.Error())
+	}
+	return &CallNode{Name: fn.Name, Args: args, Position: name.Pos}, nil
+}
+
+// parseUserCall parses a call of a user-defined function.
+func (p *Parser) parseUserCall(name Token, fn *UserFunction, hasParen bool) (Node, error) {
+	if !hasParen {
+		return nil, p.errorAt(name, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, "(")
+	}
+	p.pos++
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(fn.Params) {
+		return nil, p.errorAt(name, constants.CodeWrongArgumentCount, fmt.Sprintf("%s: %s expects %d, got %d",
+			constants.ErrWrongArgumentCount, fn.Name, len(fn.Params), len(args)))
+	}
+	return &CallNode{Name: fn.Name, Args: args, Position: name.Pos}, nil
+}
+
+// parseIf parses the functional form of the conditional operator, if(cond, then, else).
+func (p *Parser) parseIf(name Token, hasParen bool) (Node, error) {
+	if !hasParen {
+		// if — ключевое слово и не может использоваться как переменная.
+		return nil, p.errorAt(name, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, "(")
+	}
+	p.pos++
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 3 {
+		return nil, p.errorAt(name, constants.CodeWrongArgumentCount,
+			fmt.Sprintf("%s: if expects 3, got %d", constants.ErrWrongArgumentCount, len(args)))
+	}
+	return &ConditionalNode{Cond: args[0], Then: args[1], Else: args[2], Position: name.Pos}, nil
+}
+
+// parseArguments parses a comma-separated argument list; the opening parenthesis has already been consumed.
+func (p *Parser) parseArguments() ([]Node, error) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].Kind == TokenRightParen {
+		p.pos++
+		return nil, nil
+	}
+
+	var args []Node
+	for {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.pos >= len(p.tokens) {
+			return nil, p.errorAtEnd(constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedParentheses, ",", ")")
+		}
+		next := p.tokens[p.pos]
+		p.pos++
+		if next.Kind == TokenRightParen {
+			return args, nil
+		}
+		if next.Kind != TokenComma {
+			return nil, p.errorAt(next, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, ",", ")")
 		}
-		return 0, fmt.Errorf("unexpected token: %s", token)
+	}
+}
+
+// parseList parses the comma-separated elements of a list literal; the opening bracket has already been consumed.
+// Shapes are checked when the list is evaluated, because an element may be a name bound to an array.
+func (p *Parser) parseList(open Token) (Node, error) {
+	list := &ListNode{Position: open.Pos}
+	for {
+		element, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		list.Elements = append(list.Elements, element)
+
+		if p.pos >= len(p.tokens) {
+			return nil, p.errorAtEnd(constants.CodeUnmatchedParenthesis, constants.ErrUnmatchedBrackets, ",", "]")
+		}
+		next := p.tokens[p.pos]
+		p.pos++
+		if next.Kind == TokenRightBracket {
+			return list, nil
+		}
+		if next.Kind != TokenComma {
+			return nil, p.errorAt(next, constants.CodeUnexpectedToken, constants.ErrInvalidStructure, ",", "]")
+		}
+	}
+}
+
+// errorAt creates a parse error at the given token and logs the token.
+func (p *Parser) errorAt(token Token, code, message string, expected ...string) error {
+	p.logUnexpectedToken(token)
+	return newParseError(p.source, code, message, token, expected...)
+}
+
+// errorAtEnd creates a parse error for an expression that ended too early.
+func (p *Parser) errorAtEnd(code, message string, expected ...string) error {
+	return newParseError(p.source, code, message, endOfInput(p.source), expected...)
+}
+
+// logUnexpectedToken reports a token that does not fit the grammar at the current position.
+func (p *Parser) logUnexpectedToken(token Token) {
+	if logger != nil {
+		logger.Error(constants.LogUnexpectedToken,
+			zap.String(constants.FieldToken, token.Text),
+			zap.Int(constants.FieldPosition, token.Pos))
 	}
 }
@@ -0,0 +1,138 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"distributed_calculator/internal/constants"
+)
+
+// maxExpansionDigits limits the fractional digits searched for a repeating period.
+const maxExpansionDigits = 1000
+
+// Rational is an exact fraction kept in lowest terms with a positive denominator.
+// It is the value type of rational mode; the zero value is 0.
+type Rational struct {
+	rat *big.Rat
+}
+
+// NewRational returns the fraction num/den.
+func NewRational(num, den int64) (Rational, error) {
+	if den == 0 {
+		return Rational{}, errors.New(constants.ErrDivisionByZero)
+	}
+	return Rational{rat: big.NewRat(num, den)}, nil
+}
+
+// ParseRational parses a fraction such as 3/4, an integer or a decimal literal such as 0.75.
+func ParseRational(text string) (Rational, error) {
+	rat, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return Rational{}, fmt.Errorf("%s: %s", constants.ErrInvalidNumberFormat, text)
+	}
+	return Rational{rat: rat}, nil
+}
+
+// EvaluateRational parses an expression and evaluates it exactly.
+func EvaluateRational(expression string, variables map[string]float64) (Rational, error) {
+	value, err := EvaluateNumber(expression, variables, Arithmetic{Mode: ModeRational})
+	if err != nil {
+		return Rational{}, err
+	}
+	return value.(Rational), nil
+}
+
+// Num returns the numerator; its sign is the sign of the fraction.
+func (r Rational) Num() *big.Int {
+	return new(big.Int).Set(r.value().Num())
+}
+
+// Denom returns the denominator, which is always positive.
+func (r Rational) Denom() *big.Int {
+	return new(big.Int).Set(r.value().Denom())
+}
+
+// String formats the fraction in lowest terms, e.g. 1/2, or as an integer when the denominator is 1.
+func (r Rational) String() string {
+	return r.value().RatString()
+}
+
+// Float64 returns the nearest float64 approximation.
+func (r Rational) Float64() float64 {
+	f, _ := r.value().Float64()
+	return f
+}
+
+// Mixed formats the fraction as a mixed number, e.g. 7/2 as 3 1/2 and -7/2 as -3 1/2.
+func (r Rational) Mixed() string {
+	rat := r.value()
+	if rat.IsInt() {
+		return rat.RatString()
+	}
+
+	whole, rem := new(big.Int).QuoRem(new(big.Int).Abs(rat.Num()), rat.Denom(), new(big.Int))
+	sign := ""
+	if rat.Sign() < 0 {
+		sign = "-"
+	}
+	if whole.Sign() == 0 {
+		return fmt.Sprintf("%s%s/%s", sign, rem, rat.Denom())
+	}
+	return fmt.Sprintf("%s%s %s/%s", sign, whole, rem, rat.Denom())
+}
+
+// DecimalExpansion formats the fraction as a decimal with the repeating period in parentheses,
+// e.g. 1/6 as 0.1(6) and 22/7 as 3.(142857). Periods longer than maxExpansionDigits are cut off with "...".
+func (r Rational) DecimalExpansion() string {
+	rat := r.value()
+	whole, rem := new(big.Int).QuoRem(new(big.Int).Abs(rat.Num()), rat.Denom(), new(big.Int))
+
+	var b strings.Builder
+	if rat.Sign() < 0 {
+		b.WriteByte('-')
+	}
+	b.WriteString(whole.String())
+	if rem.Sign() == 0 {
+		return b.String()
+	}
+
+	// Деление столбиком: период начинается там, где остаток встретился впервые.
+	var digits []byte
+	seen := make(map[string]int)
+	ten := big.NewInt(10)
+	digit := new(big.Int)
+	for rem.Sign() != 0 {
+		if start, ok := seen[rem.String()]; ok {
+			b.WriteByte('.')
+			b.Write(digits[:start])
+			b.WriteByte('(')
+			b.Write(digits[start:])
+			b.WriteByte(')')
+			return b.String()
+		}
+		if len(digits) == maxExpansionDigits {
+			b.WriteByte('.')
+			b.Write(digits)
+			b.WriteString("...")
+			return b.String()
+		}
+		seen[rem.String()] = len(digits)
+		rem.Mul(rem, ten)
+		digit.QuoRem(rem, rat.Denom(), rem)
+		digits = append(digits, byte('0'+digit.Int64()))
+	}
+
+	b.WriteByte('.')
+	b.Write(digits)
+	return b.String()
+}
+
+// value returns the underlying fraction, treating the zero value as 0.
+func (r Rational) value() *big.Rat {
+	if r.rat == nil {
+		return new(big.Rat)
+	}
+	return r.rat
+}
@@ -1,84 +1,250 @@
-// Package calculation provides functions to tokenize mathematical expressions.
+// Package calculation provides functions to tokenize, parse and evaluate mathematical expressions.
 package calculation
 
 import (
+	"errors"
+	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
+
+	"distributed_calculator/internal/constants"
+)
+
+// TokenKind identifies the lexical class of a token.
+type TokenKind int
+
+const (
+	TokenNumber       TokenKind = iota // Numeric literal, e.g. 2 or 3.14, or an imaginary literal, e.g. 4i.
+	TokenOperator                      // Operator: + - * / // % ^, bitwise & | xor ~ << >>, comparison, and or not, ? and :.
+	TokenLeftParen                     // Opening parenthesis.
+	TokenRightParen                    // Closing parenthesis.
+	TokenIdentifier                    // Name of a function, e.g. sqrt.
+	TokenComma                         // Separator of function arguments.
+	TokenAssign                        // = of a binding, e.g. a = 2+3.
+	TokenSemicolon                     // Separator of the statements of a program.
+	TokenLeftBracket                   // Opening bracket of a list, e.g. [1, 2].
+	TokenRightBracket                  // Closing bracket of a list.
 )
 
-// tokenize splits an expression string into tokens.
-func tokenize(expression string) []string {
-	var tokens []string
-	var number strings.Builder
-	var lastWasNumber bool
+// Token is a single lexical unit of an expression.
+type Token struct {
+	Kind TokenKind // Lexical class of the token.
+	Text string    // Source text of the token.
+	Pos  int       // Byte offset of the token in the expression.
+}
+
+// Tokenize splits an expression string into tokens.
+// It is shared by the local evaluator and the orchestrator, so both accept exactly the same input.
+func Tokenize(expression string) ([]Token, error) {
+	var tokens []Token
 
 	for i := 0; i < len(expression); i++ {
-		char := rune(expression[i])
-		switch char {
-		case ' ', '\t':
-			if number.Len() > 0 {
-				tokens = append(tokens, number.String())
-				number.Reset()
-				lastWasNumber = true
-			}
+		char := expression[i]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
 			continue
-		case '+', '-', '*', '/', '%', '^', '(', ')':
-			if number.Len() > 0 {
-				tokens = append(tokens, number.String())
-				number.Reset()
-				lastWasNumber = true
+		case char == '(':
+			tokens = append(tokens, Token{Kind: TokenLeftParen, Text: "(", Pos: i})
+		case char == ')':
+			tokens = append(tokens, Token{Kind: TokenRightParen, Text: ")", Pos: i})
+		case char == '[':
+			tokens = append(tokens, Token{Kind: TokenLeftBracket, Text: "[", Pos: i})
+		case char == ']':
+			tokens = append(tokens, Token{Kind: TokenRightBracket, Text: "]", Pos: i})
+		case char == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Pos: i})
+		case char == ';':
+			tokens = append(tokens, Token{Kind: TokenSemicolon, Text: ";", Pos: i})
+		case isLetter(char):
+			j := i
+			for j < len(expression) && (isLetter(expression[j]) || isDigit(rune(expression[j]))) {
+				j++
 			}
-			if char == '-' {
-				if i == 0 || expression[i-1] == '(' || isOperator(string(expression[i-1])) {
-					tokens = append(tokens, "-")
-					continue
-				}
+			kind := TokenIdentifier
+			if isOperator(expression[i:j]) {
+				kind = TokenOperator // Ключевые слова xor, and, or, not.
 			}
-			if lastWasNumber && char == '(' {
-				return nil
+			tokens = append(tokens, Token{Kind: kind, Text: expression[i:j], Pos: i})
+			i = j - 1
+		case i+1 < len(expression) && isOperator(expression[i:i+2]):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: expression[i : i+2], Pos: i})
+			i++
+		case isOperator(string(char)):
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: string(char), Pos: i})
+		case char == '=': // Сравнение == уже распознано выше.
+			tokens = append(tokens, Token{Kind: TokenAssign, Text: "=", Pos: i})
+		case isDigit(rune(char)) || char == '.':
+			j, err := scanNumber(expression, i)
+			if err != nil {
+				return nil, err
 			}
-			tokens = append(tokens, string(char))
-			lastWasNumber = false
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: expression[i:j], Pos: i})
+			i = j - 1
 		default:
-			if lastWasNumber && number.Len() == 0 {
-				return nil
-			}
-			if char == '.' {
-				if strings.Contains(number.String(), ".") {
-					return nil
-				}
-			}
-			if !isDigit(char) && char != '.' {
-				return nil
-			}
-			number.WriteRune(char)
-			lastWasNumber = false
+			return nil, newParseError(expression, constants.CodeUnexpectedCharacter,
+				fmt.Sprintf("%s '%c'", constants.ErrUnexpectedCharacter, char), Token{Text: string(char), Pos: i})
 		}
 	}
 
-	if number.Len() > 0 {
-		tokens = append(tokens, number.String())
-	}
-
-	return tokens
+	return tokens, nil
 }
 
 // isOperator checks if a token is a valid operator.
 func isOperator(token string) bool {
 	switch token {
-	case "+", "-", "*", "/", "%", "^":
+	case "+", "-", "*", "/", "%", "^", "//", "&", "|", "~", "xor", "<<", ">>",
+		"==", "!=", "<", "<=", ">", ">=", "and", "or", "not", "?", ":":
 		return true
 	}
 	return false
 }
 
-// isNumber checks if a string represents a valid number.
-func isNumber(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
-	return err == nil
+// scanNumber reads the numeric literal that starts at offset start and returns the offset just past it.
+// Accepted forms are decimal numbers with an optional fraction and exponent (1.5, .5, 1e-3, 2.5E+10),
+// hexadecimal (0xFF) and binary (0b1010) integers, each with optional _ separators between digits
+// (1_000_000) and an optional imaginary suffix i.
+// A letter right after a decimal literal is left to the parser, so 2e is the number 2 followed by the name e.
+func scanNumber(expression string, start int) (int, error) {
+	i := start
+	prefixed := false
+	var err error
+
+	if hasBasePrefix(expression[i:]) {
+		prefixed = true
+		digit := isHexDigit
+		if expression[i+1] == 'b' || expression[i+1] == 'B' {
+			digit = isBinaryDigit
+		}
+		i, err = scanDigits(expression, i+2, digit)
+		if err != nil || i == start+2 {
+			return 0, invalidNumber(expression, start)
+		}
+	} else {
+		if i, err = scanDigits(expression, i, isDecimalDigit); err != nil {
+			return 0, invalidNumber(expression, start)
+		}
+		mantissa := i > start
+		if i < len(expression) && expression[i] == '.' {
+			fraction := i + 1
+			if i, err = scanDigits(expression, fraction, isDecimalDigit); err != nil {
+				return 0, invalidNumber(expression, start)
+			}
+			mantissa = mantissa || i > fraction
+		}
+		if !mantissa {
+			return 0, invalidNumber(expression, start)
+		}
+		// Экспонента входит в литерал, только если за e следуют цифры.
+		if i < len(expression) && (expression[i] == 'e' || expression[i] == 'E') {
+			j := i + 1
+			if j < len(expression) && (expression[j] == '+' || expression[j] == '-') {
+				j++
+			}
+			if j < len(expression) && isDigit(rune(expression[j])) {
+				if i, err = scanDigits(expression, j, isDecimalDigit); err != nil {
+					return 0, invalidNumber(expression, start)
+				}
+			}
+		}
+	}
+
+	// Суффикс i превращает число в мнимое, если за ним не продолжается имя.
+	if i < len(expression) && expression[i] == 'i' &&
+		(i+1 == len(expression) || !isLetter(expression[i+1]) && !isDigit(rune(expression[i+1]))) {
+		return i + 1, nil
+	}
+	if i < len(expression) {
+		next := expression[i]
+		if next == '.' || next == '_' || isDigit(rune(next)) || prefixed && isLetter(next) {
+			return 0, invalidNumber(expression, start)
+		}
+	}
+	return i, nil
+}
+
+// scanDigits reads a run of digits starting at offset start in which single _ separators may stand between digits.
+// It returns the offset just past the run; a separator that does not sit between two digits is an error.
+func scanDigits(expression string, start int, digit func(byte) bool) (int, error) {
+	i := start
+	for i < len(expression) {
+		switch {
+		case digit(expression[i]):
+			i++
+		case expression[i] == '_':
+			if i == start || i+1 == len(expression) || !digit(expression[i+1]) {
+				return 0, errors.New(constants.ErrInvalidNumberFormat)
+			}
+			i++
+		default:
+			return i, nil
+		}
+	}
+	return i, nil
+}
+
+// invalidNumber reports the malformed literal that starts at offset start together with its column.
+// The literal is taken up to the first character that cannot belong to a number, e.g. 1.2.3 or 0xFG.
+func invalidNumber(expression string, start int) error {
+	end := start
+	for end < len(expression) && (isLetter(expression[end]) || isDigit(rune(expression[end])) || expression[end] == '.') {
+		end++
+	}
+	err := newParseError(expression, constants.CodeInvalidNumber, constants.ErrInvalidNumberFormat,
+		Token{Kind: TokenNumber, Text: expression[start:end], Pos: start})
+	err.Message = fmt.Sprintf("%s '%s' at column %d", constants.ErrInvalidNumberFormat, err.Token, err.Column())
+	return err
+}
+
+// hasBasePrefix checks if a literal starts with a hexadecimal or binary prefix.
+func hasBasePrefix(literal string) bool {
+	return len(literal) > 1 && literal[0] == '0' && strings.ContainsRune("xXbB", rune(literal[1]))
+}
+
+// isDecimalDigit checks if a byte is a decimal digit.
+func isDecimalDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isHexDigit checks if a byte is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return isDecimalDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// isBinaryDigit checks if a byte is a binary digit.
+func isBinaryDigit(c byte) bool {
+	return c == '0' || c == '1'
 }
 
 // isDigit checks if a rune is a digit.
 func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
+
+// isLetter checks if a byte can start an identifier.
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// parseNumber converts a numeric literal into its float64 approximation; the suffix of an imaginary literal is ignored.
+// A literal beyond the float64 range becomes an infinity: only float64 mode rejects it, while the exact modes
+// read the literal itself, see Arithmetic.CheckLiterals.
+func parseNumber(literal string) (float64, error) {
+	value, err := strconv.ParseFloat(decimalLiteral(literal), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("%s: %s", constants.ErrInvalidNumberFormat, literal)
+	}
+	return value, nil
+}
+
+// decimalLiteral rewrites a numeric literal into plain decimal notation understood by every arithmetic:
+// separators and the imaginary suffix are dropped and hexadecimal and binary integers are converted to decimal.
+func decimalLiteral(literal string) string {
+	text := strings.ReplaceAll(strings.TrimSuffix(literal, "i"), "_", "")
+	if hasBasePrefix(text) {
+		if value, ok := new(big.Int).SetString(text, 0); ok {
+			return value.String()
+		}
+	}
+	return text
+}
@@ -0,0 +1,278 @@
+package calculation
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"distributed_calculator/internal/constants"
+)
+
+// Definition is the source form of a user-defined function, e.g. hyp(a, b) = sqrt(a^2+b^2).
+type Definition struct {
+	Name   string   // Name used to call the function.
+	Params []string // Names of the parameters in the order of the arguments.
+	Body   string   // Expression over the parameters.
+}
+
+// UserFunction is a user-defined function with a parsed body.
+type UserFunction struct {
+	Name   string   // Name used to call the function.
+	Params []string // Names of the parameters in the order of the arguments.
+	Body   Node     // Body of the function; it references only the parameters.
+}
+
+// Functions is a set of user-defined functions available to expressions, keyed by name.
+type Functions map[string]*UserFunction
+
+// CompileFunctions parses the bodies of the definitions and checks that every function is well-formed,
+// calls only known functions with the right number of arguments and is not recursive, directly or indirectly.
+func CompileFunctions(defs []Definition) (Functions, error) {
+	functions := make(Functions, len(defs))
+	for _, def := range defs {
+		if err := checkDefinition(def); err != nil {
+			return nil, err
+		}
+		functions[def.Name] = &UserFunction{Name: def.Name, Params: def.Params}
+	}
+
+	for _, def := range defs {
+		body, err := ParseWithFunctions(def.Body, functions)
+		if err != nil {
+			return nil, fmt.Errorf("function '%s': %w", def.Name, err)
+		}
+		if _, ok := body.(*ProgramNode); ok {
+			return nil, fmt.Errorf("function '%s': %s", def.Name, constants.ErrProgramInFunction)
+		}
+		for _, name := range VariableNames(body) {
+			if !slices.Contains(def.Params, name) && !IsConstant(name) {
+				return nil, fmt.Errorf("function '%s': %s '%s'", def.Name, constants.ErrUnknownVariable, name)
+			}
+		}
+		functions[def.Name].Body = body
+	}
+
+	if cycle := functions.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("%s: %s", constants.ErrRecursiveFunction, strings.Join(cycle, " -> "))
+	}
+	return functions, nil
+}
+
+// checkDefinition validates the name and the parameters of a function.
+func checkDefinition(def Definition) error {
+	if !isName(def.Name) || isReserved(def.Name) {
+		return fmt.Errorf("%s '%s'", constants.ErrInvalidFunctionName, def.Name)
+	}
+	if def.Body == "" {
+		return fmt.Errorf("function '%s': %s", def.Name, constants.ErrEmptyExpression)
+	}
+	for i, param := range def.Params {
+		if !isName(param) || isReserved(param) || slices.Contains(def.Params[:i], param) {
+			return fmt.Errorf("function '%s': %s '%s'", def.Name, constants.ErrInvalidParameter, param)
+		}
+	}
+	return nil
+}
+
+// isName reports whether the text is a valid identifier.
+func isName(text string) bool {
+	if text == "" || !isLetter(text[0]) {
+		return false
+	}
+	for i := 1; i < len(text); i++ {
+		if !isLetter(text[i]) && !isDigit(rune(text[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// isReserved reports whether the identifier is a keyword or the name of a built-in function.
+func isReserved(name string) bool {
+	_, builtin := LookupFunction(name)
+	return builtin || isOperator(name) || name == "if"
+}
+
+// Callees returns the names of the user-defined functions called by the body of the function, sorted.
+func (fs Functions) Callees(name string) []string {
+	fn, ok := fs[name]
+	if !ok || fn.Body == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var callees []string
+	Walk(fn.Body, func(n Node) bool {
+		if call, ok := n.(*CallNode); ok && fs[call.Name] != nil && !seen[call.Name] {
+			seen[call.Name] = true
+			callees = append(callees, call.Name)
+		}
+		return true
+	})
+	sort.Strings(callees)
+	return callees
+}
+
+// Callers returns the names of the user-defined functions whose bodies call the function, sorted.
+func (fs Functions) Callers(name string) []string {
+	var callers []string
+	for _, caller := range fs.names() {
+		if caller != name && slices.Contains(fs.Callees(caller), name) {
+			callers = append(callers, caller)
+		}
+	}
+	return callers
+}
+
+// names returns the names of the functions in alphabetical order.
+func (fs Functions) names() []string {
+	names := make([]string, 0, len(fs))
+	for name := range fs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findCycle returns a chain of calls that leads back to the function it starts from, or nil.
+func (fs Functions) findCycle() []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(fs))
+	var stack []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			start := slices.Index(stack, name)
+			return append(slices.Clone(stack[start:]), name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, callee := range fs.Callees(name) {
+			if cycle := visit(callee); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range fs.names() {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// Expand replaces the calls of user-defined functions with their bodies,
+// substituting the arguments for the parameters, so the tree can be evaluated locally.
+func Expand(node Node, functions Functions) (Node, error) {
+	return expand(node, functions, nil)
+}
+
+// expand rewrites the tree; bindings map the parameters of the function being expanded to its arguments.
+func expand(node Node, functions Functions, bindings map[string]Node) (Node, error) {
+	expandAll := func(nodes []Node) ([]Node, error) {
+		result := make([]Node, len(nodes))
+		for i, n := range nodes {
+			expanded, err := expand(n, functions, bindings)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = expanded
+		}
+		return result, nil
+	}
+
+	switch n := node.(type) {
+	case *IdentNode:
+		if bindings == nil {
+			return n, nil
+		}
+		arg, ok := bindings[n.Name]
+		if !ok && IsConstant(n.Name) {
+			return n, nil
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s '%s'", constants.ErrUnknownVariable, n.Name)
+		}
+		return &GroupNode{Inner: arg, Position: n.Position}, nil
+	case *GroupNode:
+		inner, err := expand(n.Inner, functions, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return &GroupNode{Inner: inner, Position: n.Position}, nil
+	case *UnaryNode:
+		operand, err := expand(n.Operand, functions, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Op: n.Op, Operand: operand, Position: n.Position}, nil
+	case *BinaryNode:
+		operands, err := expandAll([]Node{n.Left, n.Right})
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Op: n.Op, Left: operands[0], Right: operands[1], Position: n.Position}, nil
+	case *ConditionalNode:
+		parts, err := expandAll([]Node{n.Cond, n.Then, n.Else})
+		if err != nil {
+			return nil, err
+		}
+		return &ConditionalNode{Cond: parts[0], Then: parts[1], Else: parts[2], Position: n.Position}, nil
+	case *ListNode:
+		elements, err := expandAll(n.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return &ListNode{Elements: elements, Position: n.Position}, nil
+	case *CallNode:
+		args, err := expandAll(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := functions[n.Name]
+		if !ok {
+			return &CallNode{Name: n.Name, Args: args, Position: n.Position}, nil
+		}
+		if fn.Body == nil || len(args) != len(fn.Params) {
+			return nil, errors.New(constants.ErrWrongArgumentCount)
+		}
+		params := make(map[string]Node, len(args))
+		for i, param := range fn.Params {
+			params[param] = args[i]
+		}
+		body, err := expand(fn.Body, functions, params)
+		if err != nil {
+			return nil, err
+		}
+		return &GroupNode{Inner: body, Position: n.Position}, nil
+	case *ProgramNode:
+		program := &ProgramNode{Bindings: make([]Binding, len(n.Bindings)), Position: n.Position}
+		for i, binding := range n.Bindings {
+			value, err := expand(binding.Value, functions, bindings)
+			if err != nil {
+				return nil, err
+			}
+			program.Bindings[i] = Binding{Name: binding.Name, Value: value, Position: binding.Position}
+		}
+		result, err := expand(n.Result, functions, bindings)
+		if err != nil {
+			return nil, err
+		}
+		program.Result = result
+		return program, nil
+	default:
+		return node, nil
+	}
+}
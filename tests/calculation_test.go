@@ -1,6 +1,8 @@
 package test
 
 import (
+	"math"
+	"strings"
 	"testing"
 
 	"distributed_calculator/pkg/calculation"
@@ -165,7 +167,7 @@ func TestCalculator(t *testing.T) {
 		},
 		{
 			name:    "consecutive operators",
-			expr:    "2 ++ 2",
+			expr:    "2 */ 2",
 			wantErr: true,
 		},
 		{
@@ -218,6 +220,46 @@ func TestCalculator(t *testing.T) {
 			expr:     "-2.5 * -3.2",
 			expected: 8,
 		},
+		{
+			name:     "unary plus",
+			expr:     "+2 * +3",
+			expected: 6,
+		},
+		{
+			name:     "unary plus after operator",
+			expr:     "2 + +3",
+			expected: 5,
+		},
+		{
+			name:     "implicit multiplication by parentheses",
+			expr:     "2(3 + 4)",
+			expected: 14,
+		},
+		{
+			name:     "implicit multiplication of groups",
+			expr:     "(1 + 2)(3 + 4)",
+			expected: 21,
+		},
+		{
+			name:     "implicit multiplication by function",
+			expr:     "2sqrt(16)",
+			expected: 8,
+		},
+		{
+			name:     "implicit multiplication has product precedence",
+			expr:     "6 / 2(1 + 2)",
+			expected: 9,
+		},
+		{
+			name:     "implicit multiplication after power",
+			expr:     "2^2(3)",
+			expected: 12,
+		},
+		{
+			name:    "number after parentheses",
+			expr:    "(1 + 2)3",
+			wantErr: true,
+		},
 		{
 			name:     "very small numbers",
 			expr:     "0.0000001 + 0.0000002",
@@ -233,6 +275,46 @@ func TestCalculator(t *testing.T) {
 			expr:     "((-2.5 + 3.7) * (-2 + 4.2)) / (2 * -0.5)",
 			expected: -2.64,
 		},
+		{
+			name:     "function calls",
+			expr:     "sqrt(16) * max(1, 3, 2)",
+			expected: 12,
+		},
+		{
+			name:     "nested function calls",
+			expr:     "round(log(1000, 10)) + abs(min(-2, 5))",
+			expected: 5,
+		},
+		{
+			name:     "function call with rounding precision",
+			expr:     "round(3.14159, 2)",
+			expected: 3.14,
+		},
+		{
+			name:     "trigonometric functions",
+			expr:     "sin(0) + cos(0)",
+			expected: 1,
+		},
+		{
+			name:    "unknown function",
+			expr:    "foo(1)",
+			wantErr: true,
+		},
+		{
+			name:    "wrong number of arguments",
+			expr:    "sqrt(1, 2)",
+			wantErr: true,
+		},
+		{
+			name:    "square root of negative number",
+			expr:    "sqrt(-4)",
+			wantErr: true,
+		},
+		{
+			name:    "function without parentheses",
+			expr:    "sqrt 4",
+			wantErr: true,
+		},
 		{
 			name:     "deeply nested expression",
 			expr:     "((((1 + 2) * 3) - 4) / 5) * (-2)",
@@ -255,3 +337,868 @@ func TestCalculator(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluateWithVariables(t *testing.T) {
+	t.Parallel()
+
+	result, err := calculation.EvaluateWithVariables("a*x+b", map[string]float64{"a": 2, "x": 3.5, "b": 1})
+	require.NoError(t, err)
+	assert.Equal(t, 8.0, result)
+
+	result, err = calculation.EvaluateWithVariables("max(rate_1, rate_2) * 2", map[string]float64{"rate_1": 1.5, "rate_2": 0.5})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, result)
+
+	_, err = calculation.EvaluateWithVariables("a*x+b", map[string]float64{"a": 2, "x": 3.5})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown variable 'b'")
+
+	result, err = calculation.EvaluateWithVariables("3pi + 2(x+1)x", map[string]float64{"pi": 3, "x": 2})
+	require.NoError(t, err)
+	assert.Equal(t, 21.0, result)
+
+	root, err := calculation.Parse("x*y + x - sqrt(z)")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y", "z"}, calculation.VariableNames(root))
+}
+
+func TestEvaluateNumber(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		expr      string
+		mode      calculation.Mode
+		precision int
+		expected  string
+		err       string
+	}{
+		{"Float64 keeps binary rounding", "0.1+0.2", calculation.ModeFloat64, 0, "0.30000000000000004", ""},
+		{"Decimal sum", "0.1+0.2", calculation.ModeDecimal, 0, "0.3", ""},
+		{"Decimal rounds every operation", "2/3", calculation.ModeDecimal, 4, "0.6667", ""},
+		{"Decimal default precision", "1/3", calculation.ModeDecimal, 0, "0.33333333333333333333", ""},
+		{"Decimal large integers", "2^100+1", calculation.ModeDecimal, 2, "1267650600228229401496703205377", ""},
+		{"Decimal square root", "sqrt(2)", calculation.ModeDecimal, 30, "1.41421356237309504880168872421", ""},
+		{"Decimal rounding function", "round(2.675, 2)", calculation.ModeDecimal, 0, "2.68", ""},
+		{"Decimal sine", "sin(1)", calculation.ModeDecimal, 40, "0.8414709848078965066525023216302989996226", ""},
+		{"Decimal cosine of large argument", "cos(1000000)", calculation.ModeDecimal, 30, "0.936752127533144786938532535075", ""},
+		{"Decimal logarithm", "log(2)", calculation.ModeDecimal, 40, "0.6931471805599453094172321214581765680755", ""},
+		{"Decimal logarithm with base", "log(8, 2)", calculation.ModeDecimal, 40, "3", ""},
+		{"Decimal fractional power", "2^0.5", calculation.ModeDecimal, 40, "1.4142135623730950488016887242096980785697", ""},
+		{"Decimal perfect root", "10000^0.25", calculation.ModeDecimal, 40, "10", ""},
+		{"Decimal large fractional power", "10^30.5", calculation.ModeDecimal, 10, "3162277660168379331998893544432.7185337196", ""},
+		{"Decimal pi", "pi", calculation.ModeDecimal, 40, "3.1415926535897932384626433832795028841972", ""},
+		{"Decimal e", "e", calculation.ModeDecimal, 30, "2.718281828459045235360287471353", ""},
+		{"Float64 constant", "3pi", calculation.ModeFloat64, 0, "9.42477796076938", ""},
+		{"Rational sum", "1/3+1/6", calculation.ModeRational, 0, "1/2", ""},
+		{"Rational negative power", "(2/3)^-2", calculation.ModeRational, 0, "9/4", ""},
+		{"Rational modulo", "-7%3", calculation.ModeRational, 0, "-1", ""},
+		{"Rational perfect square", "sqrt(9/4)", calculation.ModeRational, 0, "3/2", ""},
+		{"Rational irrational root", "sqrt(2)", calculation.ModeRational, 0, "", "result cannot be represented exactly"},
+		{"Rational transcendental", "sin(1)", calculation.ModeRational, 0, "", "result cannot be represented exactly"},
+		{"Rational constant", "2pi", calculation.ModeRational, 0, "", "result cannot be represented exactly: pi"},
+		{"Rational division by zero", "1/(3-3)", calculation.ModeRational, 0, "", "division by zero"},
+		{"Exponent too large", "2^100000", calculation.ModeRational, 0, "", "exponent is too large"},
+		{"Unknown mode", "1+2", "binary", 0, "", "invalid mode 'binary'"},
+		{"Negative precision", "1+2", calculation.ModeDecimal, -1, "", "invalid precision"},
+		{"Float64 overflow", "10^400", calculation.ModeFloat64, 0, "", "result is not a finite number"},
+		{"Float64 infinite power", "0^-1", calculation.ModeFloat64, 0, "", "result is not a finite number"},
+		{"Float64 function overflow", "sum(1e308, 1e308)", calculation.ModeFloat64, 0, "", "result is not a finite number"},
+		{"Decimal fractional power too large", "10^30000.5", calculation.ModeDecimal, 0, "", "exponent is too large"},
+		{"Decimal negative root", "(-4)^0.5", calculation.ModeDecimal, 0, "", "complex numbers are supported only in float64 mode"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := calculation.EvaluateNumber(tt.expr, nil, calculation.Arithmetic{Mode: tt.mode, Precision: tt.precision})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.String())
+		})
+	}
+
+	result, err := calculation.EvaluateNumber("x*3", map[string]float64{"x": 0.1}, calculation.Arithmetic{Mode: calculation.ModeRational})
+	require.NoError(t, err)
+	assert.Equal(t, "3/10", result.String(), "variables must be converted from their shortest decimal form")
+
+	result, err = calculation.EvaluateNumber("e*2", map[string]float64{"e": 5}, calculation.Arithmetic{})
+	require.NoError(t, err)
+	assert.Equal(t, "10", result.String(), "a variable shadows the constant of the same name")
+}
+
+func TestRational(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		fraction string
+		mixed    string
+		decimal  string
+	}{
+		{"1/3+1/6", "1/2", "1/2", "0.5"},
+		{"7/2", "7/2", "3 1/2", "3.5"},
+		{"-7/2", "-7/2", "-3 1/2", "-3.5"},
+		{"-1/3", "-1/3", "-1/3", "-0.(3)"},
+		{"1/6", "1/6", "1/6", "0.1(6)"},
+		{"22/7", "22/7", "3 1/7", "3.(142857)"},
+		{"1/12+2", "25/12", "2 1/12", "2.08(3)"},
+		{"6/3", "2", "2", "2"},
+		{"0.1*3", "3/10", "3/10", "0.3"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := calculation.EvaluateRational(tt.expr, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.fraction, result.String())
+			assert.Equal(t, tt.mixed, result.Mixed())
+			assert.Equal(t, tt.decimal, result.DecimalExpansion())
+		})
+	}
+
+	half, err := calculation.NewRational(-2, -4)
+	require.NoError(t, err)
+	assert.Equal(t, "1", half.Num().String())
+	assert.Equal(t, "2", half.Denom().String())
+	assert.Equal(t, 0.5, half.Float64())
+
+	_, err = calculation.NewRational(1, 0)
+	assert.EqualError(t, err, "division by zero")
+
+	parsed, err := calculation.ParseRational("0.75")
+	require.NoError(t, err)
+	assert.Equal(t, "3/4", parsed.String())
+
+	var zero calculation.Rational
+	assert.Equal(t, "0", zero.String())
+	assert.Equal(t, "0", zero.DecimalExpansion())
+
+	long, err := calculation.EvaluateRational("1/1019", nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(long.DecimalExpansion(), "..."), "period of 1/1019 exceeds the expansion limit")
+}
+
+func TestComplex(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		expected string
+		err      string
+	}{
+		{"(3+4i)*(1-2i)", "11-2i", ""},
+		{"(3+4i)/(1-2i)", "-1+2i", ""},
+		{"(1+2i)*(1-2i)", "5", ""},
+		{"sqrt(-4)", "2i", ""},
+		{"-sqrt(-4)+1", "1-2i", ""},
+		{"2i^2", "-4", ""},
+		{"(1+1i)^-2", "-0.5i", ""},
+		{"(-4)^0.5", "2i", ""},
+		{"(-4)^1.5", "-8i", ""},
+		{"(-8)^(1/3)", "1+1.732050807568877i", ""},
+		{"abs(3+4i)", "5", ""},
+		{"log(-1)", "3.141592653589793i", ""},
+		{"1.5i-1.5i", "0", ""},
+		{"max(1i, 2)", "", "function does not accept complex arguments"},
+		{"4i%2", "", "modulo operation requires integer operands"},
+		{"1/(2i-2i)", "", "division by zero"},
+		{"log(0)", "", "logarithm of non-positive number"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := calculation.EvaluateNumber(tt.expr, nil, calculation.Arithmetic{})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.String())
+		})
+	}
+
+	_, err := calculation.EvaluateExpression("sqrt(-4)")
+	assert.EqualError(t, err, "result is a complex number: 2i")
+
+	_, err = calculation.EvaluateNumber("2i+1", nil, calculation.Arithmetic{Mode: calculation.ModeRational})
+	assert.EqualError(t, err, "complex numbers are supported only in float64 mode")
+
+	parsed, err := calculation.Arithmetic{}.Parse("3-4i")
+	require.NoError(t, err)
+	assert.Equal(t, complex(3, -4), calculation.ToComplex(parsed))
+
+	tokens, err := calculation.Tokenize("2i*x+1.5i")
+	require.NoError(t, err)
+	require.Len(t, tokens, 5)
+	assert.Equal(t, "2i", tokens[0].Text)
+	assert.Equal(t, "1.5i", tokens[4].Text)
+
+	_, err = calculation.Parse("2if")
+	assert.Error(t, err, "a name right after a number is not an imaginary literal")
+}
+
+func TestInt64Mode(t *testing.T) {
+	t.Parallel()
+
+	int64Mode := calculation.Arithmetic{Mode: calculation.ModeInt64}
+	tests := []struct {
+		expr     string
+		expected string
+		err      string
+	}{
+		{"7/2", "3", ""},
+		{"-7/2", "-3", ""},
+		{"-7//2", "-4", ""},
+		{"7%3", "1", ""},
+		{"2^62", "4611686018427387904", ""},
+		{"1 << 4 + 1", "32", ""},
+		{"6 & 3 | 8", "10", ""},
+		{"6 xor 3", "5", ""},
+		{"1 | 6 xor 3 & 1", "7", ""},
+		{"~5", "-6", ""},
+		{"-(2+3)*~0", "5", ""},
+		{"-8 >> 1", "-4", ""},
+		{"abs(-5) + sqrt(16)", "9", ""},
+		{"9223372036854775807+1", "", "integer overflow"},
+		{"-9223372036854775807-2", "", "integer overflow"},
+		{"2^63", "", "integer overflow"},
+		{"(-9223372036854775807-1)/-1", "", "integer overflow"},
+		{"1 << 63", "", "integer overflow"},
+		{"1 << 64", "", "shift count must be between 0 and 63"},
+		{"2^-1", "", "result cannot be represented exactly"},
+		{"1.5+1", "", "int64 mode requires integer operands"},
+		{"sqrt(2)", "", "result cannot be represented exactly"},
+		{"1/0", "", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := calculation.EvaluateNumber(tt.expr, nil, int64Mode)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.String())
+		})
+	}
+
+	result, err := calculation.EvaluateNumber("-7//2", nil, calculation.Arithmetic{})
+	require.NoError(t, err)
+	assert.Equal(t, "-4", result.String())
+
+	result, err = calculation.EvaluateNumber("7//2", nil, calculation.Arithmetic{Mode: calculation.ModeRational})
+	require.NoError(t, err)
+	assert.Equal(t, "3", result.String())
+
+	_, err = calculation.EvaluateNumber("5 & 3", nil, calculation.Arithmetic{})
+	assert.EqualError(t, err, "bitwise operations require int64 mode: &")
+
+	_, err = calculation.EvaluateNumber("~5", nil, calculation.Arithmetic{Mode: calculation.ModeRational})
+	assert.EqualError(t, err, "bitwise operations require int64 mode: ~")
+
+	_, err = calculation.EvaluateNumber("x+1", map[string]float64{"x": 0.5}, int64Mode)
+	assert.EqualError(t, err, "int64 mode requires integer operands: 0.5")
+}
+
+func TestNumericLiterals(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		mode     calculation.Mode
+		expected string
+		err      string
+	}{
+		{"1e-3+0", calculation.ModeFloat64, "0.001", ""},
+		{"2.5E+2*2", calculation.ModeFloat64, "500", ""},
+		{".5+1.", calculation.ModeFloat64, "1.5", ""},
+		{"0xFF+0b1010", calculation.ModeFloat64, "265", ""},
+		{"1_000_000+0x_ff", calculation.ModeFloat64, "", "invalid number format '0x_ff' at column 11"},
+		{"1_000_000/4", calculation.ModeRational, "250000", ""},
+		{"1e-3*3", calculation.ModeRational, "3/1000", ""},
+		{"0xFFFF_FFFF+1", calculation.ModeInt64, "4294967296", ""},
+		{"0x7FFFFFFFFFFFFFFF+0", calculation.ModeInt64, "9223372036854775807", ""},
+		{"0xFFFFFFFFFFFFFFFF+0", calculation.ModeInt64, "", "integer overflow"},
+		{"2e3i*1i", calculation.ModeFloat64, "-2000", ""},
+		{"1.2.3+4", calculation.ModeFloat64, "", "invalid number format '1.2.3' at column 1"},
+		{"2 + 1__0", calculation.ModeFloat64, "", "invalid number format '1__0' at column 5"},
+		{"1_+2", calculation.ModeFloat64, "", "invalid number format '1_' at column 1"},
+		{"3*0x", calculation.ModeFloat64, "", "invalid number format '0x' at column 3"},
+		{"0b102+1", calculation.ModeFloat64, "", "invalid number format '0b102' at column 1"},
+		{"0xFG+1", calculation.ModeFloat64, "", "invalid number format '0xFG' at column 1"},
+		{"1e5.3+1", calculation.ModeFloat64, "", "invalid number format '1e5.3' at column 1"},
+		{"1e999+1", calculation.ModeFloat64, "", "number out of range: 1e999"},
+		{"1e400i*1", calculation.ModeFloat64, "", "number out of range: 1e400i"},
+		{"1e400/1e399", calculation.ModeRational, "10", ""},
+		{"1e400*0", calculation.ModeDecimal, "0", ""},
+		{"1e400+0", calculation.ModeInt64, "", "integer overflow"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := calculation.EvaluateNumber(tt.expr, nil, calculation.Arithmetic{Mode: tt.mode})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.String())
+		})
+	}
+
+	tokens, err := calculation.Tokenize("2e+x")
+	require.NoError(t, err)
+	require.Len(t, tokens, 4, "an exponent without digits is not part of the literal")
+	assert.Equal(t, "2", tokens[0].Text)
+	assert.Equal(t, "e", tokens[1].Text)
+}
+
+func TestConditionals(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		mode     calculation.Mode
+		expected string
+		err      string
+	}{
+		{"1 < 2", "", "1", ""},
+		{"2 <= 1", "", "0", ""},
+		{"0.1+0.2 == 0.3", "", "0", ""},
+		{"0.1+0.2 == 0.3", calculation.ModeDecimal, "1", ""},
+		{"1/3 > 0.333", calculation.ModeRational, "1", ""},
+		{"7 >= 8 or 9 != 9", calculation.ModeInt64, "0", ""},
+		{"2+2 == 4 and not 3 > 4", "", "1", ""},
+		{"not 0 and 1", "", "1", ""},
+		{"1 | 2 == 3", calculation.ModeInt64, "1", ""},
+		{"x > 1 ? x*2 : x-1", "", "6", ""},
+		{"x < 1 ? x*2 : x-1", "", "2", ""},
+		{"if(x == 3, 10, 20)", "", "10", ""},
+		{"0 ? 1/0 : 5", "", "5", ""},
+		{"if(1, 5, 1/0)", calculation.ModeRational, "5", ""},
+		{"1 ? 0 ? 1 : 2 : 3", "", "2", ""},
+		{"0 ? 1 : 0 ? 2 : 3", "", "3", ""},
+		{"(1 ? 2 : 3) + 1", "", "3", ""},
+		{"1+1i == 1+1i", "", "1", ""},
+		{"1i < 2", "", "", "complex numbers cannot be ordered"},
+		{"1 ? 2", "", "", "invalid expression"},
+		{"1 ? 2 , 3", "", "", "invalid expression: invalid structure"},
+		{"if(1, 2)", "", "", "invalid expression: wrong number of arguments: if expects 3, got 2"},
+		{"if + 1", "", "", "invalid expression: invalid structure"},
+		{"1 + not 0", "", "", "invalid expression: invalid structure"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.mode)+" "+tt.expr, func(t *testing.T) {
+			result, err := calculation.EvaluateNumber(tt.expr, map[string]float64{"x": 3},
+				calculation.Arithmetic{Mode: tt.mode})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.String())
+		})
+	}
+
+	node, err := calculation.Parse("not a < b")
+	require.NoError(t, err)
+	unary, ok := node.(*calculation.UnaryNode)
+	require.True(t, ok, "not applies to the whole comparison")
+	assert.IsType(t, &calculation.BinaryNode{}, unary.Operand)
+
+	node, err = calculation.Parse("a ? b : c ? d : e")
+	require.NoError(t, err)
+	cond, ok := node.(*calculation.ConditionalNode)
+	require.True(t, ok)
+	assert.IsType(t, &calculation.ConditionalNode{}, cond.Else, "the conditional operator is right-associative")
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, calculation.VariableNames(node))
+}
+
+func TestUserFunctions(t *testing.T) {
+	t.Parallel()
+
+	functions, err := calculation.CompileFunctions([]calculation.Definition{
+		{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(sq(a)+sq(b))"},
+		{Name: "sq", Params: []string{"x"}, Body: "x*x"},
+		{Name: "sign", Params: []string{"x"}, Body: "x > 0 ? 1 : x < 0 ? -1 : 0"},
+		{Name: "two", Body: "2"},
+		{Name: "circle", Params: []string{"r"}, Body: "pi*r^2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sq"}, functions.Callees("hyp"))
+	assert.Equal(t, []string{"hyp"}, functions.Callers("sq"))
+	assert.Empty(t, functions.Callers("hyp"))
+
+	for expr, expected := range map[string]float64{
+		"hyp(3, 4)*two()":  10,
+		"hyp(x, sq(2))":    5,
+		"sign(-x) + sq(x)": 8,
+		"sq(sq(x) - 1)":    64,
+		"circle(x)/pi":     9,
+	} {
+		root, err := calculation.ParseWithFunctions(expr, functions)
+		require.NoError(t, err, expr)
+		expanded, err := calculation.Expand(root, functions)
+		require.NoError(t, err, expr)
+		result, err := calculation.Arithmetic{}.Evaluate(expanded, map[string]float64{"x": 3})
+		require.NoError(t, err, expr)
+		assert.Equal(t, expected, result.Float64(), expr)
+	}
+
+	_, err = calculation.Parse("hyp(3, 4)")
+	assert.EqualError(t, err, "invalid expression: unknown function 'hyp'")
+	_, err = calculation.ParseWithFunctions("hyp(3)", functions)
+	assert.EqualError(t, err, "invalid expression: wrong number of arguments: hyp expects 2, got 1")
+	_, err = calculation.ParseWithFunctions("hyp + 1", functions)
+	assert.EqualError(t, err, "invalid expression: invalid structure")
+
+	invalid := []struct {
+		defs []calculation.Definition
+		err  string
+	}{
+		{[]calculation.Definition{{Name: "f", Params: []string{"x"}, Body: "f(x-1)"}}, "recursive function: f -> f"},
+		{[]calculation.Definition{
+			{Name: "f", Params: []string{"x"}, Body: "g(x)+1"},
+			{Name: "g", Params: []string{"x"}, Body: "h(x)*2"},
+			{Name: "h", Params: []string{"x"}, Body: "x > 0 ? f(x) : 0"},
+		}, "recursive function: f -> g -> h -> f"},
+		{[]calculation.Definition{{Name: "f", Params: []string{"x"}, Body: "x+y"}}, "function 'f': invalid expression: unknown variable 'y'"},
+		{[]calculation.Definition{{Name: "f", Params: []string{"x"}, Body: "g(x)"}}, "function 'f': invalid expression: unknown function 'g'"},
+		{[]calculation.Definition{{Name: "f", Params: []string{"x"}, Body: "x+"}}, "function 'f': invalid expression: trailing operator"},
+		{[]calculation.Definition{{Name: "f", Params: []string{"x", "x"}, Body: "x"}}, "function 'f': invalid parameter name 'x'"},
+		{[]calculation.Definition{{Name: "f", Params: []string{"max"}, Body: "1"}}, "function 'f': invalid parameter name 'max'"},
+		{[]calculation.Definition{{Name: "sqrt", Params: []string{"x"}, Body: "x"}}, "invalid function name 'sqrt'"},
+		{[]calculation.Definition{{Name: "if", Body: "1"}}, "invalid function name 'if'"},
+		{[]calculation.Definition{{Name: "and", Body: "1"}}, "invalid function name 'and'"},
+		{[]calculation.Definition{{Name: "2f", Body: "1"}}, "invalid function name '2f'"},
+		{[]calculation.Definition{{Name: "f"}}, "function 'f': invalid expression: empty expression"},
+	}
+	for _, tt := range invalid {
+		_, err := calculation.CompileFunctions(tt.defs)
+		assert.EqualError(t, err, tt.err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	root, err := calculation.Parse("2 + 2 * (3 - 1)")
+	require.NoError(t, err)
+
+	add, ok := root.(*calculation.BinaryNode)
+	require.True(t, ok, "root should be a binary node")
+	assert.Equal(t, "+", add.Op)
+	assert.Equal(t, 2, add.Pos())
+
+	mul, ok := add.Right.(*calculation.BinaryNode)
+	require.True(t, ok, "multiplication should bind tighter than addition")
+	assert.Equal(t, "*", mul.Op)
+
+	group, ok := mul.Right.(*calculation.GroupNode)
+	require.True(t, ok, "parenthesized operand should be a group node")
+	assert.Equal(t, 8, group.Pos())
+
+	power, err := calculation.Parse("2 ^ 3 ^ 2")
+	require.NoError(t, err)
+	pow, ok := power.(*calculation.BinaryNode)
+	require.True(t, ok)
+	_, rightAssoc := pow.Right.(*calculation.BinaryNode)
+	assert.True(t, rightAssoc, "exponentiation should be right-associative")
+
+	neg, err := calculation.Parse("-(1 + 2)")
+	require.NoError(t, err)
+	unary, ok := neg.(*calculation.UnaryNode)
+	require.True(t, ok)
+	assert.Equal(t, "-", unary.Op)
+
+	for _, expr := range []string{"", "1 +", "(1", "1)", "()", "1 2", "1..2", "2 $ 3"} {
+		_, err := calculation.Parse(expr)
+		assert.Error(t, err, "Expected error for expression: %s", expr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr     string
+		code     string
+		position int
+		token    string
+		expected []string
+		caret    string
+	}{
+		{"2*(3+)", "UNEXPECTED_TOKEN", 5, ")", []string{"number", "name", "(", "["}, "2*(3+)\n     ^"},
+		{"1 + 2 3", "UNEXPECTED_TOKEN", 6, "3", []string{"operator", ";"}, "1 + 2 3\n      ^"},
+		{"(1+2", "UNMATCHED_PARENTHESIS", 4, "", []string{")"}, "(1+2\n    ^"},
+		{"1+2)", "UNMATCHED_PARENTHESIS", 3, ")", []string{"operator", ";"}, "1+2)\n   ^"},
+		{"1 ? 2", "UNEXPECTED_END", 5, "", []string{":"}, "1 ? 2\n     ^"},
+		{"2 +", "UNEXPECTED_END", 3, "", []string{"number", "name", "(", "["}, "2 +\n   ^"},
+		{"()", "EMPTY_EXPRESSION", 1, ")", []string{"number", "name", "(", "["}, "()\n ^"},
+		{"1 # 2", "UNEXPECTED_CHARACTER", 2, "#", nil, "1 # 2\n  ^"},
+		{"1 +\n\t1.2.3", "INVALID_NUMBER", 5, "1.2.3", nil, "\t1.2.3\n\t^"},
+		{"foo(1)", "UNKNOWN_FUNCTION", 0, "foo", nil, "foo(1)\n^"},
+		{"2 * sqrt(1, 2)", "WRONG_ARGUMENT_COUNT", 4, "sqrt", nil, "2 * sqrt(1, 2)\n    ^"},
+		{"max(1; 2)", "UNEXPECTED_TOKEN", 5, ";", []string{",", ")"}, "max(1; 2)\n     ^"},
+		{"a = 1; 2; a", "INVALID_STATEMENT", 7, "2", nil, "a = 1; 2; a\n       ^"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := calculation.Parse(tt.expr)
+			var parseErr *calculation.ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.code, parseErr.Code)
+			assert.Equal(t, tt.position, parseErr.Position)
+			assert.Equal(t, tt.token, parseErr.Token)
+			assert.Equal(t, tt.expected, parseErr.Expected)
+			assert.Equal(t, tt.caret, parseErr.Caret())
+		})
+	}
+
+	_, err := calculation.Parse("1 +\n\t1.2.3")
+	assert.EqualError(t, err, "invalid expression: invalid number format '1.2.3' at column 2",
+		"the column is counted within the line of the literal")
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		expr     string
+		expected string
+	}{
+		{"(2)+(3*4)", "2 + 3 * 4"},
+		{"(2+3)*4", "(2 + 3) * 4"},
+		{"2-(3-4)", "2 - (3 - 4)"},
+		{"(2-3)-4", "2 - 3 - 4"},
+		{"2^(3^2)", "2^3^2"},
+		{"(2^3)^2", "(2^3)^2"},
+		{"-(x^2)", "-(x^2)"},
+		{"-x^2", "(-x)^2"},
+		{"2^-x", "2^-x"},
+		{"-(-x)", "-(-x)"},
+		{"3(x+1)x", "3 * (x + 1) * x"},
+		{"not (a < b) and (c or d)", "not a < b and (c or d)"},
+		{"(a ? b : c) ? d : (e ? f : g)", "(a ? b : c) ? d : e ? f : g"},
+		{"max(1, (2), 3+4)", "max(1, 2, 3 + 4)"},
+		{"0x1F + 0b11 + 1_000 + 2.5E+03i", "31 + 3 + 1000 + 2.5e3i"},
+		{"007.50 + .5 + 5. + 1e-0 + 0e7", "7.5 + 0.5 + 5 + 1 + 0"},
+		{"2  +3", "2 + 3"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			root, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			formatted := calculation.Format(root)
+			assert.Equal(t, tt.expected, formatted)
+
+			// Напечатанное выражение разбирается в то же дерево.
+			reparsed, err := calculation.Parse(formatted)
+			require.NoError(t, err)
+			assert.Equal(t, formatted, calculation.Format(reparsed))
+			assert.Equal(t, calculation.NewTree(root), calculation.NewTree(reparsed))
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		expr   string
+		style  calculation.Style
+		output string
+	}{
+		{"(2) + (3 * 4)", calculation.StyleMinimal, "2+3*4"},
+		{"a and not b < -c", calculation.StyleMinimal, "a and not b<-c"},
+		{"max(1, (x ? 2 : 3))", calculation.StyleMinimal, "max(1,x?2:3)"},
+		{"1 - -x", calculation.StyleMinimal, "1--x"},
+		{"x^2*sin(x)/2", calculation.StyleLaTeX, `\frac{{x}^{2} \cdot \sin\left(x\right)}{2}`},
+		{"(a/b)^2 + sqrt(x+1)", calculation.StyleLaTeX, `{\left(\frac{a}{b}\right)}^{2} + \sqrt{x + 1}`},
+		{"-(x+1) * abs(alpha) // 3", calculation.StyleLaTeX,
+			`\left\lfloor \frac{-\left(x + 1\right) \cdot \left|\alpha\right|}{3} \right\rfloor`},
+		{"log(x, 2) != rate_1 and 2.5e-3 <= 1", calculation.StyleLaTeX,
+			`\log_{2}\left(x\right) \neq \mathrm{rate\_1} \land 2.5 \cdot 10^{-3} \leq 1`},
+		{"x > 0 ? x : -x", calculation.StyleLaTeX, `\begin{cases} x & \text{if } x > 0 \\ -x & \text{otherwise} \end{cases}`},
+		{"x^2/2", calculation.StyleMathML, `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow><mfrac>` +
+			`<mrow><msup><mrow><mi>x</mi></mrow><mrow><mn>2</mn></mrow></msup></mrow><mrow><mn>2</mn></mrow>` +
+			`</mfrac></mrow></math>`},
+		{"(a < b) * -3i", calculation.StyleMathML, `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>` +
+			`<mrow><mo>(</mo><mrow><mi>a</mi></mrow><mo>&lt;</mo><mrow><mi>b</mi></mrow><mo>)</mo></mrow><mo>⋅</mo>` +
+			`<mrow><mo>−</mo><mrow><mn>3</mn><mi>i</mi></mrow></mrow></mrow></math>`},
+		{"sin(pi)", calculation.StyleMathML, `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow>` +
+			`<mi>sin</mi><mo>&#x2061;</mo><mo>(</mo><mrow><mi>π</mi></mrow><mo>)</mo></mrow></math>`},
+	} {
+		t.Run(string(tt.style)+" "+tt.expr, func(t *testing.T) {
+			root, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.output, calculation.Print(root, tt.style))
+		})
+	}
+
+	// Выражения, различающиеся только записью, имеют одну каноническую форму.
+	for _, group := range [][]string{
+		{"2+3", "2 +  3", "(2)+(3)", "((2 + 3))", "0x2 + 3.0"},
+		{"2(y+1)x", "2*(y+1)*x", "(2 * (y + 1)) * x"},
+	} {
+		root, err := calculation.Parse(group[0])
+		require.NoError(t, err)
+		canonical := calculation.Format(root)
+		for _, expr := range group[1:] {
+			root, err := calculation.Parse(expr)
+			require.NoError(t, err)
+			assert.Equal(t, canonical, calculation.Format(root), expr)
+		}
+	}
+
+	for _, name := range []string{"", "canonical", "minimal", "latex", "mathml"} {
+		_, err := calculation.ParseStyle(name)
+		assert.NoError(t, err, name)
+	}
+	_, err := calculation.ParseStyle("html")
+	assert.EqualError(t, err, "unsupported format 'html'")
+}
+
+func TestDerive(t *testing.T) {
+	t.Parallel()
+
+	functions, err := calculation.CompileFunctions([]calculation.Definition{
+		{Name: "sq", Params: []string{"a"}, Body: "a*a"},
+	})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		expr     string
+		expected string
+	}{
+		{"x^2*sin(x)", "2 * x * sin(x) + x^2 * cos(x)"},
+		{"x", "1"},
+		{"42", "0"},
+		{"x*y + y", "y"},
+		{"3x^4 - 2x + 7", "12 * x^3 - 2"},
+		{"sin(x)/x", "(cos(x) * x - sin(x)) / x^2"},
+		{"1/x", "-1 / x^2"},
+		{"cos(2x)", "-2 * sin(2 * x)"},
+		{"sqrt(x^2 + 1)", "x / sqrt(x^2 + 1)"},
+		{"log(x)", "1 / x"},
+		{"log(x, 2)", "1 / x / log(2)"},
+		{"2^x", "2^x * log(2)"},
+		{"x^x", "x^x * (log(x) + 1)"},
+		{"abs(x)", "x / abs(x)"},
+		{"x > 0 ? x^3 : -x", "x > 0 ? 3 * x^2 : -1"},
+		{"max(x, 1)", "x >= 1 ? 1 : 0"},
+		{"sq(sin(x))", "2 * cos(x) * sin(x)"},
+		{"-sq(sin(x)) + 1", "-2 * cos(x) * sin(x)"},
+		{"sqrt(2x^2)", "2 * x / sqrt(2 * x^2)"},
+		{"y % 2 + x", "1"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			root, err := calculation.ParseWithFunctions(tt.expr, functions)
+			require.NoError(t, err)
+			derivative, err := calculation.Derive(root, "x", functions)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, calculation.Format(derivative))
+		})
+	}
+
+	// Производная совпадает с разностной в нескольких точках.
+	for _, expr := range []string{"x^2*sin(x)", "sqrt(x^2+1)/x", "x^x", "log(x, 3)*cos(x)", "max(x, x^2, 1)"} {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		derivative, err := calculation.Derive(root, "x", nil)
+		require.NoError(t, err, expr)
+		for _, x := range []float64{0.7, 1.3, 2.9} {
+			const h = 1e-6
+			plus, err := calculation.Evaluate(root, map[string]float64{"x": x + h})
+			require.NoError(t, err)
+			minus, err := calculation.Evaluate(root, map[string]float64{"x": x - h})
+			require.NoError(t, err)
+			exact, err := calculation.Evaluate(derivative, map[string]float64{"x": x})
+			require.NoError(t, err)
+			assert.InDelta(t, (plus-minus)/(2*h), exact, 1e-5*math.Max(1, math.Abs(exact)), "%s at %g", expr, x)
+		}
+	}
+
+	for _, tt := range []struct {
+		expr     string
+		variable string
+		err      string
+	}{
+		{"x % 2", "x", "expression is not differentiable: %"},
+		{"round(x)", "x", "expression is not differentiable: round"},
+		{"x < 1", "x", "expression is not differentiable: <"},
+		{"x + 1", "sin", "invalid variable name 'sin'"},
+		{"x + 1", "2x", "invalid variable name '2x'"},
+	} {
+		root, err := calculation.Parse(tt.expr)
+		require.NoError(t, err)
+		_, err = calculation.Derive(root, tt.variable, nil)
+		assert.EqualError(t, err, tt.err, tt.expr)
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	t.Parallel()
+
+	root, err := calculation.Parse("a = 2+3; b = a*4; b^2 - a;")
+	require.NoError(t, err)
+	program, ok := root.(*calculation.ProgramNode)
+	require.True(t, ok)
+	values, result, err := calculation.Arithmetic{Mode: calculation.ModeRational}.EvaluateProgram(program, nil)
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	assert.Equal(t, "5", values[0].String())
+	assert.Equal(t, "20", values[1].String())
+	assert.Equal(t, "395", result.String())
+	assert.Equal(t, "a = 2 + 3; b = a * 4; b^2 - a", calculation.Format(root))
+	assert.Equal(t, "a=2+3;b=a*4;b^2-a", calculation.Print(root, calculation.StyleMinimal))
+	assert.Equal(t, `a = 2 + 3;\quad b = a \cdot 4;\quad {b}^{2} - a`, calculation.Print(root, calculation.StyleLaTeX))
+	assert.Equal(t, "program", calculation.NewTree(root).Type)
+	assert.Equal(t, "binding", calculation.NewTree(root).Args[0].Type)
+
+	// Привязка скрывает переменную с тем же именем; свободными остаются только несвязанные имена.
+	root, err = calculation.Parse("y = x * 2; z = y + x; z / y")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x"}, calculation.VariableNames(root))
+	value, err := calculation.EvaluateWithVariables("x = 10; x + y", map[string]float64{"x": 1, "y": 2})
+	require.NoError(t, err)
+	assert.Equal(t, 12.0, value)
+
+	// Одиночное выражение, даже с точкой с запятой в конце, программой не становится.
+	root, err = calculation.Parse("2 + 3;")
+	require.NoError(t, err)
+	assert.IsType(t, &calculation.BinaryNode{}, root)
+
+	// Производная программы — производная её результата с подставленными привязками.
+	root, err = calculation.Parse("u = x^2; v = sin(x); u * v")
+	require.NoError(t, err)
+	derivative, err := calculation.Derive(root, "x", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "2 * x * sin(x) + x^2 * cos(x)", calculation.Format(derivative))
+
+	for _, tt := range []struct {
+		expr     string
+		code     string
+		message  string
+		position int
+	}{
+		{"a = 1; a = 2; a", "INVALID_BINDING", "invalid expression: duplicate binding 'a'", 7},
+		{"a = b + 1; b = 2; a", "INVALID_BINDING", "invalid expression: name used before it is bound 'b'", 4},
+		{"a = a + 1; a", "INVALID_BINDING", "invalid expression: name used before it is bound 'a'", 4},
+		{"sin = 1; sin", "INVALID_BINDING", "invalid expression: invalid binding name 'sin'", 0},
+		{"a = 1;", "INVALID_STATEMENT", "invalid expression: program must end with an expression", 6},
+		{"a = 1; 2; a", "INVALID_STATEMENT", "invalid expression: only the last statement may be an expression", 7},
+		{"2 = 3", "UNEXPECTED_TOKEN", "invalid expression: invalid structure", 2},
+		{"a = 1;; a", "UNEXPECTED_TOKEN", "invalid expression: invalid structure", 6},
+	} {
+		_, err := calculation.Parse(tt.expr)
+		var parseErr *calculation.ParseError
+		require.ErrorAs(t, err, &parseErr, tt.expr)
+		assert.Equal(t, tt.code, parseErr.Code, tt.expr)
+		assert.Equal(t, tt.message, parseErr.Message, tt.expr)
+		assert.Equal(t, tt.position, parseErr.Position, tt.expr)
+	}
+
+	_, err = calculation.CompileFunctions([]calculation.Definition{{Name: "f", Params: []string{"x"}, Body: "y = x*2; y+1"}})
+	assert.EqualError(t, err, "function 'f': function body must be a single expression")
+}
+
+func TestArrays(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		expr     string
+		mode     calculation.Mode
+		expected string
+	}{
+		{"[1, 2, 3] * 2", calculation.ModeFloat64, "[2, 4, 6]"},
+		{"[[1, 2], [3, 4]] + [10, 20]", calculation.ModeFloat64, "[[11, 22], [13, 24]]"},
+		{"[[1, 2], [3, 4]] * [[2], [3]]", calculation.ModeFloat64, "[[2, 4], [9, 12]]"},
+		{"matmul([[1, 2], [3, 4]], [[5, 6], [7, 8]])", calculation.ModeFloat64, "[[19, 22], [43, 50]]"},
+		{"matmul([1, 2], [[1, 0], [0, 1]])", calculation.ModeFloat64, "[1, 2]"},
+		{"matmul([1, 2], [3, 4])", calculation.ModeFloat64, "11"},
+		{"transpose([[1, 2, 3], [4, 5, 6]])", calculation.ModeFloat64, "[[1, 4], [2, 5], [3, 6]]"},
+		{"transpose([1, 2])", calculation.ModeFloat64, "[[1], [2]]"},
+		{"dot([1, 2, 3], [4, 5, 6])", calculation.ModeFloat64, "32"},
+		{"det([[1, 2], [3, 4]])", calculation.ModeFloat64, "-2"},
+		{"det([[2, 0, 1], [1, 3, 2], [1, 1, 2]])", calculation.ModeRational, "6"},
+		{"sum([[1, 2], [3, 4]])", calculation.ModeFloat64, "10"},
+		{"sum(1, 2, 3)", calculation.ModeInt64, "6"},
+		{"mean([1, 2, 3, 4])", calculation.ModeRational, "5/2"},
+		{"sqrt([4, 9])", calculation.ModeFloat64, "[2, 3]"},
+		{"[1, 2, 3] >= 2", calculation.ModeFloat64, "[0, 1, 1]"},
+		{"a = [1, 2]; b = [a, a * 2]; det(b) + sum(b)", calculation.ModeFloat64, "9"},
+		{"x > 0 ? [1, 2] : [3, 4]", calculation.ModeFloat64, "[1, 2]"},
+	} {
+		value, err := calculation.EvaluateNumber(tt.expr, map[string]float64{"x": 1}, calculation.Arithmetic{Mode: tt.mode})
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.expected, value.String(), tt.expr)
+	}
+
+	for _, tt := range []struct {
+		expr    string
+		message string
+	}{
+		{"[1, 2] + [1, 2, 3]", "shapes do not match: [2] and [3]"},
+		{"[[1], [2, 3]]", "invalid expression: invalid array: rows of a matrix have different lengths 1 and 2"},
+		{"[[[1]]]", "invalid expression: invalid array: arrays have at most two dimensions"},
+		{"[1, [2]]", "invalid expression: invalid array: a list mixes numbers and vectors"},
+		{"det([[1, 2, 3], [4, 5, 6]])", "matrix is not square: [2, 3]"},
+		{"dot(1, 2)", "function requires a vector or a matrix: dot expects two vectors"},
+		{"matmul([[1, 2]], [[1, 2]])", "shapes do not match: [1, 2] and [1, 2]"},
+		{"[1, 2] ? 1 : 0", "value must be a scalar: condition"},
+	} {
+		_, err := calculation.EvaluateNumber(tt.expr, nil, calculation.Arithmetic{})
+		assert.EqualError(t, err, tt.message, tt.expr)
+	}
+
+	_, err := calculation.EvaluateExpression("[1, 2] * 2")
+	assert.EqualError(t, err, "result is an array: [2, 4]")
+
+	root, err := calculation.Parse("[[1, 2], [x, 4]]")
+	require.NoError(t, err)
+	assert.Equal(t, "[[1, 2], [x, 4]]", calculation.Format(root))
+	assert.Equal(t, "[[1,2],[x,4]]", calculation.Print(root, calculation.StyleMinimal))
+	assert.Equal(t, `\begin{bmatrix} 1 & 2 \\ x & 4 \end{bmatrix}`, calculation.Print(root, calculation.StyleLaTeX))
+	assert.Equal(t, "list", calculation.NewTree(root).Type)
+	assert.Equal(t, []string{"x"}, calculation.VariableNames(root))
+
+	_, err = calculation.Derive(root, "x", nil)
+	assert.EqualError(t, err, "expression is not differentiable: array")
+
+	for _, tt := range []struct {
+		expr     string
+		code     string
+		position int
+		expected []string
+	}{
+		{"[1, 2", "UNMATCHED_PARENTHESIS", 5, []string{",", "]"}},
+		{"[]", "EMPTY_EXPRESSION", 1, []string{"number", "name", "(", "["}},
+		{"[1, 2)", "UNEXPECTED_TOKEN", 5, []string{",", "]"}},
+		{"1 + 2]", "UNMATCHED_PARENTHESIS", 5, []string{"operator", ";"}},
+	} {
+		_, err := calculation.Parse(tt.expr)
+		var parseErr *calculation.ParseError
+		require.ErrorAs(t, err, &parseErr, tt.expr)
+		assert.Equal(t, tt.code, parseErr.Code, tt.expr)
+		assert.Equal(t, tt.position, parseErr.Position, tt.expr)
+		assert.Equal(t, tt.expected, parseErr.Expected, tt.expr)
+	}
+}
@@ -0,0 +1,670 @@
+package test
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+
+	"distributed_calculator/configs"
+	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/app/planner"
+	"distributed_calculator/internal/logger"
+	"distributed_calculator/internal/worker"
+	"distributed_calculator/pkg/calculation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// executePlan runs the tasks of a plan the way the orchestrator and agents do:
+// every dependency result is written into the argument slot it was planned for,
+// tasks of branches not selected by their conditional task are skipped,
+// and a conditional task takes the value of the selected branch.
+// It returns the result of the last task.
+func executePlan(t *testing.T, agent *worker.Agent, tasks []*models.Task) string {
+	return executeTasks(t, agent, tasks)[tasks[len(tasks)-1].ID]
+}
+
+// executeTasks runs the tasks like executePlan and returns the results of all executed tasks by ID.
+func executeTasks(t *testing.T, agent *worker.Agent, tasks []*models.Task) map[string]string {
+	byID := make(map[string]*models.Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+	}
+	results := make(map[string]string, len(tasks))
+	branches := make(map[string]int)
+
+	for _, task := range tasks {
+		if task.ConditionID != "" && branches[task.ConditionID] != task.Branch {
+			continue
+		}
+		ready := *task
+		ready.Args = append([]string(nil), task.Args...)
+		for i, depID := range task.DependsOnTaskIDs {
+			result, ok := results[depID]
+			if task.Operation == models.OperationCondition && task.DependencySlots[i] != 0 && !ok {
+				continue // Ветвь, которая не была выбрана.
+			}
+			require.True(t, ok, "task %s planned before its dependency %s", task.ID, depID)
+			ready.SetArg(task.DependencySlots[i], result)
+		}
+		if task.Operation == models.OperationCondition {
+			results[task.ID] = ready.Args[branches[task.ID]]
+		} else {
+			result, err := agent.Calculate(&ready)
+			require.NoError(t, err, "task %s failed", task.ID)
+			results[task.ID] = result
+		}
+
+		// Ветвь условной задачи выбирается, как только вычислено её условие.
+		for _, cond := range byID {
+			i := slices.Index(cond.DependsOnTaskIDs, task.ID)
+			if cond.Operation == models.OperationCondition && i >= 0 && cond.DependencySlots[i] == 0 {
+				branches[cond.ID] = 2
+				if results[task.ID] != "0" {
+					branches[cond.ID] = 1
+				}
+			}
+		}
+	}
+	return results
+}
+
+func TestPlanner_Conformance(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+
+	expressions := []string{
+		"2+2*2",
+		"2*2+2",
+		"10-4-3",
+		"100/10/5",
+		"2-3+4",
+		"8/4*2",
+		"(2+3)*4",
+		"2*(3+4)*5",
+		"((2+3)*(4-1))/5",
+		"(1+(2*(3+4)-5))*2",
+		"((((1+2)*3)-4)/5)*(-2)",
+		"-(1+2)*3",
+		"2*-(3-5)",
+		"-2.5*-3.2+1",
+		"1+2*3-4/2+5*(6+7)-8+9",
+		"(0-1)*(0+2)",
+		"10-(2-(3-(4-5)))",
+		"2^3^2",
+		"(2^3)^2",
+		"-2^2",
+		"2^-1",
+		"7%3+2^2",
+		"(17%5)*3^2%4",
+		"sqrt(2)*max(1, 3, 4)",
+		"max(2+3, 2*3, min(7, 8))",
+		"round(2.567, 2)+abs(-3)",
+		"log(8, 2)+sin(0)*cos(0)-log(1)",
+		"-sqrt(16)+round(-2.5)",
+		"(1+1 == 2) + (3 < 2)*10",
+		"2*3 >= 6 and not 1 > 2",
+		"(1 > 2 or 2 > 1)*3 + (not (1+1))",
+		"1+1 > 1 ? 10-1 : 1/0",
+		"if(2*2 != 4, 1, 2+3)",
+		"(1+1 ? 2 : 3) ? (2 > 1 ? 4*2 : 0) : 5",
+		"1-1 ? 1 : 2-2 ? 3 : 4+0",
+		"-(2 > 1 ? 3 : 4) + (not (1 < 2 ? 0 : 1))",
+		"2(3+4)",
+		"(1+2)(3+4)-5",
+		"6/2(1+2)",
+		"-2sqrt(16)max(1, 2)",
+		"+2 - +3*+(1+1)",
+		"3pi",
+		"e^2 - sin(pi/2)",
+	}
+
+	for _, expr := range expressions {
+		expr := expr
+		t.Run(expr, func(t *testing.T) {
+			expected, err := calculation.EvaluateExpression(expr)
+			require.NoError(t, err)
+
+			root, err := calculation.Parse(expr)
+			require.NoError(t, err)
+			tasks, err := planner.Plan("expr", root, planner.Options{})
+			require.NoError(t, err)
+
+			actual, err := strconv.ParseFloat(executePlan(t, agent, tasks), 64)
+			require.NoError(t, err)
+			assert.InDelta(t, expected, actual, 1e-10)
+		})
+	}
+}
+
+func TestPlanner_Conditionals(t *testing.T) {
+	t.Parallel()
+
+	root, err := calculation.Parse("x > 0 ? x*2 : 5")
+	require.NoError(t, err)
+	tasks, err := planner.Plan("expr", root, planner.Options{Variables: map[string]float64{"x": 3}})
+	require.NoError(t, err)
+	require.Len(t, tasks, 3)
+
+	cond, then, choice := tasks[0], tasks[1], tasks[2]
+	assert.Equal(t, ">", cond.Operation)
+	assert.Empty(t, cond.ConditionID, "the condition is always computed")
+	assert.Equal(t, "*", then.Operation)
+	assert.Equal(t, choice.ID, then.ConditionID)
+	assert.Equal(t, 1, then.Branch)
+
+	assert.Equal(t, models.OperationCondition, choice.Operation)
+	assert.Equal(t, []string{cond.ID, then.ID}, choice.DependsOnTaskIDs)
+	assert.Equal(t, []int{0, 1}, choice.DependencySlots)
+	assert.Equal(t, []string{"", "", "5"}, choice.Args, "a constant branch is stored in its slot")
+
+	// Условие, известное при планировании, оставляет в плане только выбранную ветвь.
+	root, err = calculation.Parse("1 ? x+1 : x/0")
+	require.NoError(t, err)
+	tasks, err = planner.Plan("expr", root, planner.Options{Variables: map[string]float64{"x": 3}})
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "+", tasks[0].Operation)
+	assert.Empty(t, tasks[0].ConditionID)
+
+	// Задачи вложенного условия привязаны к ветви внешнего.
+	root, err = calculation.Parse("x-3 ? (x > 1 ? x-1 : x+1) : 0")
+	require.NoError(t, err)
+	tasks, err = planner.Plan("expr", root, planner.Options{Variables: map[string]float64{"x": 3}})
+	require.NoError(t, err)
+	require.Len(t, tasks, 6)
+	inner, outer := tasks[4], tasks[5]
+	assert.Equal(t, models.OperationCondition, outer.Operation)
+	assert.Equal(t, models.OperationCondition, inner.Operation)
+	assert.Empty(t, tasks[0].ConditionID)
+	assert.Equal(t, outer.ID, tasks[1].ConditionID, "the inner condition belongs to the outer branch")
+	assert.Equal(t, outer.ID, inner.ConditionID)
+	assert.Equal(t, inner.ID, tasks[2].ConditionID)
+	assert.Equal(t, 1, tasks[2].Branch)
+	assert.Equal(t, inner.ID, tasks[3].ConditionID)
+	assert.Equal(t, 2, tasks[3].Branch)
+}
+
+func TestPlanner_UserFunctions(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+
+	functions, err := calculation.CompileFunctions([]calculation.Definition{
+		{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(sq(a)+sq(b))"},
+		{Name: "sq", Params: []string{"x"}, Body: "x*x"},
+		{Name: "clamp", Params: []string{"x", "lo", "hi"}, Body: "x < lo ? lo : x > hi ? hi : x"},
+		{Name: "id", Params: []string{"x"}, Body: "x"},
+	})
+	require.NoError(t, err)
+	variables := map[string]float64{"x": 3, "y": 4}
+
+	for _, expr := range []string{
+		"hyp(3, 4)*2",
+		"hyp(x, y) - sq(x+1)",
+		"clamp(x*5, 0, 10) + clamp(-x, 0, 10)",
+		"id(x+1)*id(2)",
+		"sq(sq(id(y)-x))+1",
+	} {
+		root, err := calculation.ParseWithFunctions(expr, functions)
+		require.NoError(t, err, expr)
+		expanded, err := calculation.Expand(root, functions)
+		require.NoError(t, err, expr)
+		expected, err := calculation.Arithmetic{}.Evaluate(expanded, variables)
+		require.NoError(t, err, expr)
+
+		tasks, err := planner.Plan("expr", root, planner.Options{Variables: variables, Functions: functions})
+		require.NoError(t, err, expr)
+		assert.Equal(t, expected.String(), executePlan(t, agent, tasks), expr)
+	}
+
+	// Аргумент вычисляется один раз, даже если параметр используется в теле несколько раз.
+	root, err := calculation.ParseWithFunctions("sq(x+1)", functions)
+	require.NoError(t, err)
+	tasks, err := planner.Plan("expr", root, planner.Options{Variables: variables, Functions: functions})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, []string{tasks[0].ID, tasks[0].ID}, tasks[1].DependsOnTaskIDs)
+
+	// Вложенные вызовы могут разрастись экспоненциально, поэтому размер плана ограничен.
+	// Аргументы вызовов различаются на каждом уровне, так что общих подвыражений не остаётся.
+	defs := []calculation.Definition{{Name: "f0", Params: []string{"x"}, Body: "x+1"}}
+	for i := 1; i <= 16; i++ {
+		defs = append(defs, calculation.Definition{
+			Name:   "f" + strconv.Itoa(i),
+			Params: []string{"x"},
+			Body:   fmt.Sprintf("f%d(x*2) + f%d(x*3)", i-1, i-1),
+		})
+	}
+	functions, err = calculation.CompileFunctions(defs)
+	require.NoError(t, err)
+	root, err = calculation.ParseWithFunctions("f16(1)", functions)
+	require.NoError(t, err)
+	_, err = planner.Plan("expr", root, planner.Options{Functions: functions})
+	assert.EqualError(t, err, "expression is too large")
+}
+
+func TestPlanner_Folding(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+
+	compile := func(expr string, opts planner.Options) *planner.Result {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		result, err := planner.Compile("expr", root, opts)
+		require.NoError(t, err)
+		return result
+	}
+
+	// Свёртка не меняет результат ни в одном режиме.
+	for _, folding := range []planner.Folding{planner.FoldNone, planner.FoldCheap, planner.FoldAll} {
+		for _, tt := range []struct {
+			expr  string
+			arith calculation.Arithmetic
+		}{
+			{"2*3 + sqrt(x)*1 - 0", calculation.Arithmetic{}},
+			{"(x > 1 ? 2^3 : x/0) + max(1, x)^1", calculation.Arithmetic{}},
+			{"1/3 + (abs(x) - 1)*0 + sqrt(x*x)/1", calculation.Arithmetic{Mode: calculation.ModeRational}},
+			{"0.1*3 + round(x, 2)", calculation.Arithmetic{Mode: calculation.ModeDecimal, Precision: 5}},
+			{"(x << 2 | 1) // 2 + 0*(x+1)", calculation.Arithmetic{Mode: calculation.ModeInt64}},
+		} {
+			t.Run(string(folding)+" "+tt.expr, func(t *testing.T) {
+				variables := map[string]float64{"x": 4}
+				expected, err := calculation.EvaluateNumber(tt.expr, variables, tt.arith)
+				require.NoError(t, err)
+
+				result := compile(tt.expr, planner.Options{Variables: variables, Arithmetic: tt.arith, Folding: folding})
+				if result.Value != nil {
+					assert.Empty(t, result.Tasks)
+					assert.Equal(t, expected.String(), result.Value.String())
+					return
+				}
+				assert.Equal(t, expected.String(), executePlan(t, agent, result.Tasks))
+			})
+		}
+	}
+
+	variables := map[string]float64{"x": 2}
+
+	result := compile("2*3 + x", planner.Options{Variables: variables, Folding: planner.FoldCheap})
+	assert.Empty(t, result.Tasks, "cheap operations with known operands never reach agents")
+	assert.Equal(t, "8", result.Value.String())
+	assert.Equal(t, []models.EliminatedTask{
+		{Operation: "*", Position: 1, Reason: models.EliminatedFolded, Result: "6"},
+		{Operation: "+", Position: 4, Reason: models.EliminatedFolded, Result: "8"},
+	}, result.Eliminated)
+
+	result = compile("2*3 + sqrt(x)", planner.Options{Variables: variables, Folding: planner.FoldCheap})
+	require.Len(t, result.Tasks, 2, "function calls are left to agents in cheap mode")
+	assert.Equal(t, "sqrt", result.Tasks[0].Operation)
+	assert.Equal(t, "6", result.Tasks[1].Arg1)
+
+	result = compile("2*3 + sqrt(x)", planner.Options{Variables: variables, Folding: planner.FoldAll})
+	assert.Empty(t, result.Tasks)
+	assert.InDelta(t, 6+1.4142135623730951, result.Value.Float64(), 1e-15)
+
+	result = compile("2*3 + sqrt(x)", planner.Options{Variables: variables})
+	assert.Len(t, result.Tasks, 3, "nothing is folded by default")
+	assert.Empty(t, result.Eliminated)
+
+	result = compile("(sqrt(x)*1 + 0)/1", planner.Options{Variables: variables, Folding: planner.FoldCheap})
+	require.Len(t, result.Tasks, 1)
+	assert.Equal(t, "sqrt", result.Tasks[0].Operation)
+	assert.Len(t, result.Eliminated, 3)
+	for _, eliminated := range result.Eliminated {
+		assert.Equal(t, models.EliminatedIdentity, eliminated.Reason)
+	}
+
+	result = compile("0 - sqrt(x) + 1/x", planner.Options{Variables: variables, Folding: planner.FoldCheap})
+	assert.Len(t, result.Tasks, 4, "0-x and 1/x are not identities")
+
+	rational := calculation.Arithmetic{Mode: calculation.ModeRational}
+	result = compile("(abs(x) + 1)*0 + sqrt(4)", planner.Options{Variables: variables, Arithmetic: rational, Folding: planner.FoldCheap})
+	require.Len(t, result.Tasks, 1, "x*0 drops a subexpression that cannot fail")
+	assert.Equal(t, "sqrt", result.Tasks[0].Operation)
+	assert.Equal(t, []models.EliminatedTask{
+		{Operation: "*", Position: 12, Reason: models.EliminatedIdentity, Result: "0"},
+		{Operation: "+", Position: 15, Reason: models.EliminatedIdentity},
+		{Operation: "abs", Position: 1, Reason: models.EliminatedUnused},
+		{Operation: "+", Position: 8, Reason: models.EliminatedUnused},
+	}, result.Eliminated)
+
+	result = compile("(1/x)*0 + sqrt(4)", planner.Options{Variables: variables, Arithmetic: rational, Folding: planner.FoldCheap})
+	assert.Len(t, result.Tasks, 4, "a division may fail, so x*0 keeps it")
+	result = compile("sqrt(x)*0", planner.Options{Variables: variables, Folding: planner.FoldCheap})
+	assert.Len(t, result.Tasks, 2, "float64 values may be infinite, so x*0 is kept")
+
+	result = compile("x > 1 ? 10 : 1/(x-2)", planner.Options{Variables: variables, Folding: planner.FoldAll})
+	require.NotNil(t, result.Value, "the condition is folded, so only the taken branch is planned")
+	assert.Equal(t, "10", result.Value.String())
+
+	result = compile("sqrt(x) + 1/(x-2)", planner.Options{Variables: variables, Folding: planner.FoldAll})
+	require.Len(t, result.Tasks, 2, "an operation that fails while folding is left to agents")
+	assert.Equal(t, "/", result.Tasks[0].Operation)
+	assert.Equal(t, "0", result.Tasks[0].Arg2)
+
+	root, err := calculation.Parse("1+2")
+	require.NoError(t, err)
+	_, err = planner.Compile("expr", root, planner.Options{Folding: "some"})
+	assert.EqualError(t, err, "invalid folding mode 'some': expected none, cheap or all")
+}
+
+func TestPlanner_SharedSubexpressions(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+	variables := map[string]float64{"x": 3}
+
+	compile := func(expr string) *planner.Result {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		result, err := planner.Compile("expr", root, planner.Options{Variables: variables})
+		require.NoError(t, err)
+		expected, err := calculation.EvaluateNumber(expr, variables, calculation.Arithmetic{})
+		require.NoError(t, err)
+		assert.Equal(t, expected.String(), executePlan(t, agent, result.Tasks), expr)
+		return result
+	}
+
+	// Одинаковые подвыражения вычисляются одной задачей, результат которой получают все зависимые.
+	result := compile("(x+1)*(x+1) + (x+1)/2")
+	require.Len(t, result.Tasks, 4)
+	sum := result.Tasks[0]
+	assert.Equal(t, "+", sum.Operation)
+	assert.Equal(t, []string{sum.ID, sum.ID}, result.Tasks[1].DependsOnTaskIDs)
+	assert.Equal(t, []int{0, 1}, result.Tasks[1].DependencySlots)
+	assert.Equal(t, []string{sum.ID}, result.Tasks[2].DependsOnTaskIDs)
+	assert.Equal(t, []models.EliminatedTask{
+		{Operation: "+", Position: 8, Reason: models.EliminatedShared},
+		{Operation: "+", Position: 16, Reason: models.EliminatedShared},
+	}, result.Eliminated)
+
+	// Операнды коммутативных операций можно переставлять, остальных нельзя.
+	result = compile("sqrt(x*2) + sqrt(2*x) - (x-1)*(1-x)")
+	assert.Len(t, result.Tasks, 7)
+	assert.Len(t, result.Eliminated, 2)
+
+	// Задача вне условия используется внутри ветви, но ветви не делят задачи между собой.
+	result = compile("(x+1) + (x > 1 ? x+1 : 0)")
+	assert.Len(t, result.Tasks, 4)
+	result = compile("x > 1 ? (x+1)*2 : (x+1)/2")
+	assert.Len(t, result.Tasks, 6)
+	assert.Empty(t, result.Eliminated)
+	result = compile("x > 1 ? (x+1) + (x > 2 ? x+1 : 0) : 0")
+	assert.Len(t, result.Eliminated, 1, "a nested branch reuses a task of the enclosing branch")
+}
+
+func TestPlanner_Programs(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+	variables := map[string]float64{"x": 3}
+
+	compile := func(expr string, folding planner.Folding) (*planner.Result, map[string]string) {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		result, err := planner.Compile("expr", root, planner.Options{Variables: variables, Folding: folding})
+		require.NoError(t, err)
+		return result, executeTasks(t, agent, result.Tasks)
+	}
+
+	// Ссылка на привязку — зависимость от задачи её значения, а не повторное вычисление.
+	result, results := compile("a = x+2; b = a*4; b^2 - a", planner.FoldNone)
+	require.Len(t, result.Tasks, 4)
+	require.Len(t, result.Bindings, 2)
+	a, b := result.Bindings[0], result.Bindings[1]
+	assert.Equal(t, "a", a.Name)
+	assert.Equal(t, "b", b.Name)
+	assert.Equal(t, "5", results[a.TaskID])
+	assert.Equal(t, "20", results[b.TaskID])
+	assert.Equal(t, []string{a.TaskID}, result.Tasks[1].DependsOnTaskIDs)
+	assert.Contains(t, result.Tasks[3].DependsOnTaskIDs, a.TaskID)
+	assert.Equal(t, "395", results[result.RootID])
+
+	// Результатом может быть задача привязки, а привязка, которую результат не использует, всё равно вычисляется.
+	result, results = compile("a = x+1; b = a*2; a", planner.FoldNone)
+	require.Len(t, result.Tasks, 2)
+	assert.Equal(t, result.Bindings[0].TaskID, result.RootID)
+	assert.Equal(t, "4", results[result.RootID])
+	assert.Equal(t, "8", results[result.Bindings[1].TaskID])
+	assert.Empty(t, result.Eliminated)
+
+	// Значения, известные при планировании, задач не требуют.
+	result, results = compile("a = 2+3; b = a*x; b - a", planner.FoldAll)
+	assert.Empty(t, result.Tasks)
+	assert.Equal(t, "10", result.Value.String())
+	assert.Equal(t, "5", result.Bindings[0].Value.String())
+	assert.Equal(t, "15", result.Bindings[1].Value.String())
+	assert.Empty(t, results)
+
+	// Одинаковые вычисления разных привязок делят задачу.
+	result, _ = compile("a = sqrt(x); b = sqrt(x); a + b", planner.FoldNone)
+	assert.Len(t, result.Tasks, 2)
+	assert.Equal(t, result.Bindings[0].TaskID, result.Bindings[1].TaskID)
+}
+
+func TestPlanner_Arrays(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+	variables := map[string]float64{"x": 3}
+
+	compile := func(expr string, folding planner.Folding) (*planner.Result, map[string]string) {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		result, err := planner.Compile("expr", root, planner.Options{Variables: variables, Folding: folding})
+		require.NoError(t, err)
+		return result, executeTasks(t, agent, result.Tasks)
+	}
+	elements := func(result *planner.Result, results map[string]string) []string {
+		values := make([]string, len(result.Elements))
+		for i, element := range result.Elements {
+			if element.Value != nil {
+				values[i] = element.Value.String()
+			} else {
+				values[i] = results[element.TaskID]
+			}
+		}
+		return values
+	}
+
+	// Произведение матриц — отдельная задача скалярного произведения для каждой клетки.
+	result, results := compile("matmul([[1,2],[3,4]], [[5,6],[7,8]])", planner.FoldCheap)
+	require.Len(t, result.Tasks, 4)
+	for _, task := range result.Tasks {
+		assert.Equal(t, "dot", task.Operation)
+		assert.Len(t, task.Args, 4)
+		assert.Empty(t, task.DependsOnTaskIDs)
+	}
+	assert.Equal(t, []int{2, 2}, result.Shape)
+	assert.Empty(t, result.RootID)
+	assert.Equal(t, []string{"19", "22", "43", "50"}, elements(result, results))
+
+	// Поэлементные операции растягивают скаляры и векторы на строки матрицы.
+	result, results = compile("[[1,2],[3,4]] * [x, 10] + 1", planner.FoldNone)
+	assert.Len(t, result.Tasks, 8)
+	assert.Equal(t, []string{"4", "21", "10", "41"}, elements(result, results))
+
+	// Свёртки массивов вычисляются одной задачей над элементами, в том числе вычисленными агентами.
+	result, results = compile("a = [x, x^2]; b = transpose(a); det(matmul(b, [[1, 2]])) + sum(a) + dot(a, [1, 1])", planner.FoldCheap)
+	assert.Equal(t, []int{2, 1}, result.Bindings[1].Shape)
+	assert.Equal(t, result.Bindings[0].Elements[1], result.Bindings[1].Elements[1])
+	assert.Equal(t, "24", results[result.RootID])
+
+	// Массивы, известные при планировании, задач не требуют.
+	result, _ = compile("[1, 2] * x > 4", planner.FoldAll)
+	assert.Empty(t, result.Tasks)
+	assert.Equal(t, []string{"0", "1"}, elements(result, nil))
+
+	for _, expr := range []string{
+		"[1, 2] + [1, 2, 3]",
+		"matmul([1, 2], [[1, 2]])",
+		"det([[1, 2, 3], [4, 5, 6]])",
+		"[[1], [2, 3]]",
+		"x > 1 ? [1, x] : [2, x]",
+	} {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err, expr)
+		_, err = planner.Compile("expr", root, planner.Options{Variables: variables})
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestPlanner_DependencyGraph(t *testing.T) {
+	t.Parallel()
+
+	root, err := calculation.Parse("(1+2)*(3+4)")
+	require.NoError(t, err)
+	tasks, err := planner.Plan("expr-1", root, planner.Options{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 3)
+
+	product := tasks[2]
+	assert.Equal(t, "*", product.Operation)
+	assert.Equal(t, []string{tasks[0].ID, tasks[1].ID}, product.DependsOnTaskIDs)
+	assert.Equal(t, []int{0, 1}, product.DependencySlots)
+	for _, task := range tasks {
+		assert.Equal(t, "expr-1", task.ExpressionID)
+	}
+
+	root, err = calculation.Parse("2+3*4")
+	require.NoError(t, err)
+	tasks, err = planner.Plan("expr-2", root, planner.Options{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "*", tasks[0].Operation, "multiplication must be planned before addition")
+	assert.Equal(t, "2", tasks[1].Arg1)
+	assert.Equal(t, []int{1}, tasks[1].DependencySlots)
+
+	root, err = calculation.Parse("max(1, 2+3, 4)")
+	require.NoError(t, err)
+	tasks, err = planner.Plan("expr-4", root, planner.Options{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	call := tasks[1]
+	assert.Equal(t, "max", call.Operation)
+	assert.Equal(t, []string{"1", "", "4"}, call.Args)
+	assert.Equal(t, []string{tasks[0].ID}, call.DependsOnTaskIDs)
+	assert.Equal(t, []int{1}, call.DependencySlots)
+
+	root, err = calculation.Parse("2^3^2")
+	require.NoError(t, err)
+	tasks, err = planner.Plan("expr-3", root, planner.Options{})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "3", tasks[0].Arg1, "exponentiation must be grouped from the right")
+	assert.Equal(t, "2", tasks[0].Arg2)
+	assert.Equal(t, "2", tasks[1].Arg1)
+}
+
+func TestPlanner_Variables(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+
+	tests := []struct {
+		expr      string
+		variables map[string]float64
+	}{
+		{"a*x+b", map[string]float64{"a": 2, "x": 3.5, "b": 1}},
+		{"max(a, x, b)*zero-x", map[string]float64{"a": 2, "x": 3.5, "b": 1, "zero": 0}},
+		{"-(a+b)^x", map[string]float64{"a": 2, "b": 1, "x": 2}},
+		{"a_1*0+a", map[string]float64{"a": 4, "a_1": 7}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.expr, func(t *testing.T) {
+			expected, err := calculation.EvaluateWithVariables(tt.expr, tt.variables)
+			require.NoError(t, err)
+
+			root, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			tasks, err := planner.Plan("expr", root, planner.Options{Variables: tt.variables})
+			require.NoError(t, err)
+
+			actual, err := strconv.ParseFloat(executePlan(t, agent, tasks), 64)
+			require.NoError(t, err)
+			assert.InDelta(t, expected, actual, 1e-10)
+		})
+	}
+
+	root, err := calculation.Parse("a*y")
+	require.NoError(t, err)
+	_, err = planner.Plan("expr", root, planner.Options{Variables: map[string]float64{"a": 1}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown variable 'y'")
+}
+
+func TestPlanner_Modes(t *testing.T) {
+	t.Parallel()
+	log, err := logger.New(logger.DefaultOptions())
+	require.NoError(t, err)
+	agent := worker.New(&configs.WorkerConfig{ComputingPower: 1}, log)
+
+	tests := []struct {
+		expr  string
+		arith calculation.Arithmetic
+	}{
+		{"0.1+0.2*3", calculation.Arithmetic{Mode: calculation.ModeDecimal, Precision: 10}},
+		{"-(2/3)+sqrt(2)", calculation.Arithmetic{Mode: calculation.ModeDecimal, Precision: 25}},
+		{"123456789012345678901234567890*10+x", calculation.Arithmetic{Mode: calculation.ModeDecimal}},
+		{"1/3+1/6-x", calculation.Arithmetic{Mode: calculation.ModeRational}},
+		{"(3+4i)*(1-2i)+sqrt(-4)", calculation.Arithmetic{}},
+		{"-(2i*x)^3/(1+1i)-abs(3-4i)", calculation.Arithmetic{}},
+		{"max(1/3, 2/7)^3*27", calculation.Arithmetic{Mode: calculation.ModeRational}},
+		{"-(7-10)//2 xor ~(1 << 3)", calculation.Arithmetic{Mode: calculation.ModeInt64}},
+		{"(9223372036854775806+1) >> 2 | abs(-3)*x", calculation.Arithmetic{Mode: calculation.ModeInt64}},
+		{"-(7-10)//2", calculation.Arithmetic{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.arith.Mode)+" "+tt.expr, func(t *testing.T) {
+			variables := map[string]float64{"x": 0.1}
+			if tt.arith.Mode == calculation.ModeInt64 {
+				variables["x"] = 4
+			}
+			expected, err := calculation.EvaluateNumber(tt.expr, variables, tt.arith)
+			require.NoError(t, err)
+
+			root, err := calculation.Parse(tt.expr)
+			require.NoError(t, err)
+			tasks, err := planner.Plan("expr", root, planner.Options{Variables: variables, Arithmetic: tt.arith})
+			require.NoError(t, err)
+			for _, task := range tasks {
+				assert.Equal(t, string(tt.arith.Mode), task.Mode)
+			}
+
+			assert.Equal(t, expected.String(), executePlan(t, agent, tasks))
+		})
+	}
+
+	int64Mode := planner.Options{Arithmetic: calculation.Arithmetic{Mode: calculation.ModeInt64}}
+	for expr, expectedErr := range map[string]string{
+		"9223372036854775808+1": "integer overflow",
+		"x+1":                   "variable 'x': int64 mode requires integer operands: 0.5",
+	} {
+		root, err := calculation.Parse(expr)
+		require.NoError(t, err)
+		opts := int64Mode
+		opts.Variables = map[string]float64{"x": 0.5}
+		_, err = planner.Plan("expr", root, opts)
+		assert.ErrorContains(t, err, expectedErr, expr)
+	}
+
+	root, err := calculation.Parse("(1+2) & 3")
+	require.NoError(t, err)
+	_, err = planner.Plan("expr", root, planner.Options{})
+	assert.EqualError(t, err, "bitwise operations require int64 mode: &")
+}
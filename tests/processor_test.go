@@ -49,11 +49,15 @@ func TestExpressionValidation(t *testing.T) {
 		{"Single number", "42", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
 		{"Two numbers", "42 53", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
 		{"Trailing operator", "1+2+", http.StatusUnprocessableEntity, "invalid expression: trailing operator"},
-		{"Leading operator", "+1+2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
+		{"Unary plus", "+1+2", http.StatusCreated, ""},
+		{"Unary plus after operator", "1++2", http.StatusCreated, ""},
+		{"Unary plus before product", "+5*2", http.StatusCreated, ""},
+		{"Only unary plus", "+5", http.StatusUnprocessableEntity, "invalid expression: too few tokens"}, // Унарный плюс не меняет число, и операций не остаётся
+		{"Unary plus before operator", "+-1+2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
 		{"Invalid character", "1+{2", http.StatusUnprocessableEntity, "invalid expression: unexpected character"},
 		{"Curly braces", "{1+*}", http.StatusUnprocessableEntity, "invalid expression: unexpected character"},
 		{"Too few tokens with operator", "2*", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
-		{"Invalid structure", "1++2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
+		{"Consecutive binary operators", "1*/2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
 		{"Division by zero", "5/0", http.StatusCreated, ""}, // Division by zero обрабатывается позже
 
 		{"Subtraction", "5-3", http.StatusCreated, ""},
@@ -74,7 +78,14 @@ func TestExpressionValidation(t *testing.T) {
 		{"Multiple unary minus inside parentheses", "(-1+(2*(3-(-4))))", http.StatusCreated, ""},
 		{"Redundant parentheses", "((1+2))", http.StatusCreated, ""},
 
-		{"Double decimal point", "1.2.3+4", http.StatusUnprocessableEntity, "invalid expression: invalid number format"},
+		{"Double decimal point", "1.2.3+4", http.StatusUnprocessableEntity, "invalid expression: invalid number format '1.2.3' at column 1"},
+		{"Scientific notation", "1e-3+2.5E+10", http.StatusCreated, ""},
+		{"Hexadecimal and binary", "0xFF+0b1010", http.StatusCreated, ""},
+		{"Digit separators", "1_000_000*2", http.StatusCreated, ""},
+		{"Repeated separator", "1+1__000", http.StatusUnprocessableEntity, "invalid expression: invalid number format '1__000' at column 3"},
+		{"Invalid binary digit", "0b102+1", http.StatusUnprocessableEntity, "invalid expression: invalid number format '0b102' at column 1"},
+		{"Empty hexadecimal", "2*0x", http.StatusUnprocessableEntity, "invalid expression: invalid number format '0x' at column 3"},
+		{"Literal beyond float64 range", "2*1e400", http.StatusUnprocessableEntity, "invalid expression: number out of range '1e400' at column 3"},
 		{"Only operator", "+", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
 		{"Missing operand in parentheses", "(1+)", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
 		{"Unmatched opening parenthesis", "(1+2", http.StatusUnprocessableEntity, "invalid expression: unmatched parentheses"},
@@ -83,6 +94,29 @@ func TestExpressionValidation(t *testing.T) {
 		{"Consecutive operators", "1+-+2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
 		{"Empty parentheses", "()", http.StatusUnprocessableEntity, "invalid expression: empty expression"},
 		{"Multiple unary minus", "--1+2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
+		{"Only unary minus", "-5", http.StatusCreated, ""},
+		{"Power", "2^3", http.StatusCreated, ""},
+		{"Right-associative power", "2^3^2", http.StatusCreated, ""},
+		{"Modulo", "7%3", http.StatusCreated, ""},
+		{"Power and modulo with precedence", "1+2^3%5*2", http.StatusCreated, ""},
+		{"Trailing power", "2^", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
+		{"Single function call", "sqrt(16)", http.StatusCreated, ""},
+		{"Function calls with operators", "sqrt(2)*max(1, 3, 4)", http.StatusCreated, ""},
+		{"Nested function calls", "round(log(100, 10) + abs(-2.5))", http.StatusCreated, ""},
+		{"Unknown function", "foo(1)+2", http.StatusUnprocessableEntity, "invalid expression: unknown function 'foo'"},
+		{"Unbound variable", "x+2", http.StatusUnprocessableEntity, "invalid expression: unknown variable 'x'"},
+		{"Function name as variable", "sqrt+2", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
+		{"Wrong argument count", "sqrt(1, 2)+1", http.StatusUnprocessableEntity, "invalid expression: wrong number of arguments"},
+		{"Missing arguments", "max()+1", http.StatusUnprocessableEntity, "invalid expression: wrong number of arguments"},
+		{"Trailing comma", "max(1,)+1", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
+		{"Unclosed call", "max(1, 2", http.StatusUnprocessableEntity, "invalid expression: unmatched parentheses"},
+		{"Implicit multiplication by parentheses", "2(3+4)", http.StatusCreated, ""},
+		{"Implicit multiplication of groups", "(1+2)(3+4)", http.StatusCreated, ""},
+		{"Implicit multiplication by function", "2sqrt(16)", http.StatusCreated, ""},
+		{"Implicit multiplication by constant", "3pi", http.StatusCreated, ""},
+		{"Implicit multiplication by unbound variable", "3k", http.StatusUnprocessableEntity, "invalid expression: unknown variable 'k'"},
+		{"Juxtaposed numbers", "2 3", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
+		{"Number after parentheses", "(1+2)3", http.StatusUnprocessableEntity, "invalid expression: invalid structure"},
 	}
 
 	for _, tc := range tests {
@@ -104,6 +138,9 @@ func TestExpressionValidation(t *testing.T) {
 
 			if tc.expectedStatus != http.StatusCreated {
 				errorMsg, ok := respBody["error"].(string)
+				if details, isParseError := respBody["error"].(map[string]interface{}); isParseError {
+					errorMsg, ok = details["message"].(string)
+				}
 				assert.True(t, ok)
 				assert.Contains(t, errorMsg, tc.expectedError)
 			} else {
@@ -113,3 +150,51 @@ func TestExpressionValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestExpressionValidationWithVariables(t *testing.T) {
+	handler := setupTestServer2(t)
+
+	tests := []struct {
+		name           string
+		expression     string
+		expectedStatus int
+		expectedError  string
+	}{
+		{"Negated variable", "-x", http.StatusCreated, ""},
+		{"Logical negation of variable", "not x", http.StatusCreated, ""},
+		{"Conditional on variable", "x ? [1,2] : [3,4]", http.StatusCreated, ""},
+		{"Conditional on literal", "1 ? [1,2] : [3,4]", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
+		{"Single variable", "x", http.StatusUnprocessableEntity, "invalid expression: too few tokens"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			reqBody, _ := json.Marshal(models.CalculateRequest{
+				Expression: tc.expression,
+				Variables:  map[string]float64{"x": 3},
+			})
+			req, err := http.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(reqBody))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+
+			var respBody map[string]interface{}
+			err = json.Unmarshal(rr.Body.Bytes(), &respBody)
+			assert.NoError(t, err)
+
+			if tc.expectedStatus != http.StatusCreated {
+				details, ok := respBody["error"].(map[string]interface{})
+				assert.True(t, ok)
+				assert.Contains(t, details["message"], tc.expectedError)
+			} else {
+				_, hasID := respBody["id"]
+				assert.True(t, hasID, "Response should contain expression ID")
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,13 +14,15 @@ import (
 	"distributed_calculator/internal/logger"
 	"distributed_calculator/internal/app"
 	"distributed_calculator/internal/app/models"	
+	"distributed_calculator/internal/constants"
+	"distributed_calculator/pkg/calculation"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func setupTestServer(t *testing.T) (*server.Server, *mux.Router) {
+func setupTestServer(t *testing.T, configure ...func(*configs.ServerConfig)) (*server.Server, *mux.Router) {
 	cfg := &configs.ServerConfig{
 		Port:              "8080",
 		TimeAdditionMS:    100,
@@ -26,6 +30,9 @@ func setupTestServer(t *testing.T) (*server.Server, *mux.Router) {
 		TimeMultiplyMS:    200,
 		TimeDivisionMS:    200,
 	}
+	for _, apply := range configure {
+		apply(cfg)
+	}
 
 	log, err := logger.New(logger.Options{
 		Level:       logger.Debug,
@@ -83,14 +90,71 @@ func TestServer_HandleCalculate(t *testing.T) {
 		{
 			name: "invalid expression",
 			request: models.CalculateRequest{
-				Expression: "2 + + 2",
+				Expression: "2 + * 2",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			validateResp: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var resp models.ParseErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&resp)
+				require.NoError(t, err)
+				assert.Equal(t, "UNEXPECTED_TOKEN", resp.Error.Code)
+				assert.Equal(t, "invalid expression: invalid structure", resp.Error.Message)
+				assert.Equal(t, 4, resp.Error.Position)
+				assert.Equal(t, "*", resp.Error.Token)
+			},
+		},
+		{
+			name: "parse error location",
+			request: models.CalculateRequest{
+				Expression: "2*(3+)",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			validateResp: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var resp models.ParseErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&resp)
+				require.NoError(t, err)
+				assert.Equal(t, models.ParseErrorDetails{
+					Code:     "UNEXPECTED_TOKEN",
+					Message:  "invalid expression: invalid structure",
+					Position: 5,
+					Column:   6,
+					Token:    ")",
+					Expected: []string{"number", "name", "(", "["},
+					Caret:    "2*(3+)\n     ^",
+				}, resp.Error)
+			},
+		},
+		{
+			name: "parse error at end of expression",
+			request: models.CalculateRequest{
+				Expression: "max(1,\n  2",
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			validateResp: func(t *testing.T, w *httptest.ResponseRecorder) {
+				var resp models.ParseErrorResponse
+				err := json.NewDecoder(w.Body).Decode(&resp)
+				require.NoError(t, err)
+				assert.Equal(t, models.ParseErrorDetails{
+					Code:     "UNMATCHED_PARENTHESIS",
+					Message:  "invalid expression: unmatched parentheses",
+					Position: 10,
+					Column:   4,
+					Expected: []string{",", ")"},
+					Caret:    "  2\n   ^",
+				}, resp.Error)
+			},
+		},
+		{
+			name: "semantic error stays a message",
+			request: models.CalculateRequest{
+				Expression: "x+1",
 			},
 			expectedStatus: http.StatusUnprocessableEntity,
 			validateResp: func(t *testing.T, w *httptest.ResponseRecorder) {
 				var resp map[string]string
 				err := json.NewDecoder(w.Body).Decode(&resp)
 				require.NoError(t, err)
-				assert.Contains(t, resp["error"], "invalid expression: invalid structure")
+				assert.Equal(t, "invalid expression: unknown variable 'x'", resp["error"])
 			},
 		},
 	}
@@ -263,8 +327,9 @@ func TestServer_HandleSubmitTaskResult(t *testing.T) {
 taskFound:
 
 	result := models.TaskResult{
-		ID:     taskResp.Task.ID,
-		Result: 4.0,
+		ID:      taskResp.Task.ID,
+		Result:  "4",
+		LeaseID: taskResp.Task.LeaseID,
 	}
 	body, err = json.Marshal(result)
 	require.NoError(t, err)
@@ -304,8 +369,9 @@ func TestServer_Integration(t *testing.T) {
 	require.NoError(t, err)
 
 	result := models.TaskResult{
-		ID:     taskResp.Task.ID,
-		Result: 4.0,
+		ID:      taskResp.Task.ID,
+		Result:  "4",
+		LeaseID: taskResp.Task.LeaseID,
 	}
 	body, err = json.Marshal(result)
 	require.NoError(t, err)
@@ -338,9 +404,1128 @@ func TestServer_Integration(t *testing.T) {
 
 			if exprResp.Expression.Status == models.StatusComplete {
 				assert.NotNil(t, exprResp.Expression.Result)
-				assert.Equal(t, 4.0, *exprResp.Expression.Result)
+				assert.Equal(t, 4.0, exprResp.Expression.Result.Re)
 				return
 			}
 		}
 	}
 }
+
+func TestServer_HandleCalculateWithVariables(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	body, err := json.Marshal(models.CalculateRequest{
+		Expression: "a*x+b",
+		Variables:  map[string]float64{"a": 2, "x": 3.5, "b": 1},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, map[string]float64{"a": 2, "x": 3.5, "b": 1}, exprResp.Expression.Variables)
+
+	body, err = json.Marshal(models.CalculateRequest{
+		Expression: "a*x+b",
+		Variables:  map[string]float64{"a": 2, "x": 3.5},
+	})
+	require.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "invalid expression: unknown variable 'b'", errResp["error"])
+}
+
+// calculateSingleTask submits an expression that compiles into one task, answers the task
+// with the given result the way an agent would and returns the completed expression.
+func calculateSingleTask(t *testing.T, router http.Handler, calcReq models.CalculateRequest, result string) (models.Task, models.Expression) {
+	t.Helper()
+
+	body, err := json.Marshal(calcReq)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	var taskResp models.TaskResponse
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code == http.StatusOK && json.NewDecoder(w.Body).Decode(&taskResp) == nil
+	}, 2*time.Second, 50*time.Millisecond)
+
+	body, err = json.Marshal(models.TaskResult{ID: taskResp.Task.ID, Result: result, LeaseID: taskResp.Task.LeaseID})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusComplete, exprResp.Expression.Status)
+	return taskResp.Task, exprResp.Expression
+}
+
+func TestServer_HandleCalculateDecimalMode(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	task, expr := calculateSingleTask(t, router,
+		models.CalculateRequest{Expression: "0.1 + 0.2", Mode: "decimal", Precision: 10}, "0.3")
+
+	assert.Equal(t, "decimal", task.Mode)
+	assert.Equal(t, 10, task.Precision)
+	assert.Equal(t, "0.1", task.Arg1)
+	assert.Equal(t, "0.2", task.Arg2)
+
+	assert.Equal(t, "0.3", expr.ResultExact)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 0.3}, *expr.Result)
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "1 + 2", Mode: "binary"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "invalid mode 'binary'", errResp["error"])
+}
+
+func TestServer_HandleCalculateRationalMode(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	task, expr := calculateSingleTask(t, router,
+		models.CalculateRequest{Expression: "7 / 2", Mode: "rational"}, "7/2")
+
+	assert.Equal(t, "rational", task.Mode)
+	assert.Equal(t, "7/2", expr.ResultExact)
+	assert.Equal(t, "3 1/2", expr.ResultMixed)
+	assert.Equal(t, "3.5", expr.ResultDecimal)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 3.5}, *expr.Result)
+
+	// Точный режим читает литерал сам, поэтому ему не мешает диапазон float64.
+	task, expr = calculateSingleTask(t, router,
+		models.CalculateRequest{Expression: "1e400 / 1e399", Mode: "rational"}, "10")
+	assert.Equal(t, "1"+strings.Repeat("0", 400), task.Arg1)
+	assert.Equal(t, "10", expr.ResultExact)
+
+	_, expr = calculateSingleTask(t, router,
+		models.CalculateRequest{Expression: "2 + 2"}, "4")
+	assert.Empty(t, expr.ResultExact, "float64 results have no exact form")
+	assert.Empty(t, expr.ResultMixed)
+}
+
+func TestServer_HandleCalculateComplexResult(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	task, expr := calculateSingleTask(t, router, models.CalculateRequest{Expression: "sqrt(-4)"}, "2i")
+	assert.Equal(t, []string{"-4"}, task.Args)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 0, Im: 2}, *expr.Result)
+
+	data, err := json.Marshal(models.Value{Re: 3, Im: -4})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"re":3,"im":-4}`, string(data))
+
+	data, err = json.Marshal(models.Value{Re: 2.5})
+	require.NoError(t, err)
+	assert.Equal(t, "2.5", string(data), "real results keep the plain number format")
+}
+
+func TestServer_HandleCalculateNonFiniteResult(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "10 ^ 400"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	var task models.Task
+	require.Eventually(t, func() bool {
+		var ok bool
+		task, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	// Бесконечность не записывается в JSON, поэтому такой результат агента завершает выражение с ошибкой.
+	submitTaskResult(t, router, task, "+Inf")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusError, exprResp.Expression.Status)
+	assert.Equal(t, constants.ErrNonFiniteResult, exprResp.Expression.Error)
+	assert.Nil(t, exprResp.Expression.Result)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listResp models.ExpressionsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listResp))
+	assert.Len(t, listResp.Expressions, 1)
+}
+
+func TestServer_HandleCalculateInt64Mode(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	task, expr := calculateSingleTask(t, router,
+		models.CalculateRequest{Expression: "6 xor 3", Mode: "int64"}, "5")
+	assert.Equal(t, "int64", task.Mode)
+	assert.Equal(t, "xor", task.Operation)
+	assert.Equal(t, "5", expr.ResultExact)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 5}, *expr.Result)
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "5 & 3"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "bitwise operations require int64 mode: &", errResp["error"])
+}
+
+// nextTask requests a task for an agent; ok is false when the queue is empty.
+func nextTask(t *testing.T, router http.Handler) (models.Task, bool) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return models.Task{}, false
+	}
+	var taskResp models.TaskResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&taskResp))
+	return taskResp.Task, true
+}
+
+// submitTaskResult sends the result of a task under its lease as an agent does.
+func submitTaskResult(t *testing.T, router http.Handler, task models.Task, result string) {
+	t.Helper()
+
+	body, err := json.Marshal(models.TaskResult{ID: task.ID, Result: result, LeaseID: task.LeaseID})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServer_HandleCalculateConditional(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	getExpression := func(id string) models.Expression {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+id, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var exprResp models.ExpressionResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		return exprResp.Expression
+	}
+	calculate := func(expression string) string {
+		body, err := json.Marshal(models.CalculateRequest{Expression: expression, Variables: map[string]float64{"x": 3}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var calcResp models.CalculateResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+		return calcResp.ID
+	}
+
+	exprID := calculate("x > 1 ? x * 2 : x - 1")
+	var cond models.Task
+	require.Eventually(t, func() bool {
+		var ok bool
+		cond, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, ">", cond.Operation)
+	_, queued := nextTask(t, router)
+	assert.False(t, queued, "branches wait for the condition")
+
+	submitTaskResult(t, router, cond, "1")
+	then, ok := nextTask(t, router)
+	require.True(t, ok)
+	assert.Equal(t, "*", then.Operation)
+	assert.Equal(t, "3", then.Arg1)
+	_, queued = nextTask(t, router)
+	assert.False(t, queued, "the other branch is never started")
+	assert.Equal(t, models.StatusProgress, getExpression(exprID).Status)
+
+	submitTaskResult(t, router, then, "6")
+	expr := getExpression(exprID)
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 6}, *expr.Result)
+
+	// Постоянная ветвь не требует задач: выражение завершается сразу после условия.
+	exprID = calculate("x > 5 ? x * 2 : 7")
+	require.Eventually(t, func() bool {
+		var ok bool
+		cond, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 50*time.Millisecond)
+	submitTaskResult(t, router, cond, "0")
+	_, queued = nextTask(t, router)
+	assert.False(t, queued)
+	expr = getExpression(exprID)
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 7}, *expr.Result)
+}
+
+func TestServer_HandleCalculateSharedNestedCondition(t *testing.T) {
+	_, router := setupTestServer(t, func(cfg *configs.ServerConfig) { cfg.FoldConstants = "none" })
+
+	// Вложенное условие совпадает с внешним и вычисляется одной задачей; ветви, пропущенные
+	// внешним условием, не запускаются, даже когда результат этой задачи уже известен.
+	tests := []struct {
+		expression string
+		results    map[string]string
+		expected   float64
+	}{
+		{"(x > 10) ? ((x > 10) ? 1 : 1/0) : 7", map[string]string{">": "0"}, 7},
+		{"(2*3 < 10) ? 7 : ((2*3 < 10) ? 1/0 : 1)", map[string]string{"*": "6", "<": "1"}, 7},
+	}
+	for _, tt := range tests {
+		body, err := json.Marshal(models.CalculateRequest{Expression: tt.expression, Variables: map[string]float64{"x": 1}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var calcResp models.CalculateResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+		var expr models.Expression
+		require.Eventually(t, func() bool {
+			if task, ok := nextTask(t, router); ok {
+				result, known := tt.results[task.Operation]
+				require.True(t, known, "%s: task %s of a skipped branch was started", tt.expression, task.Operation)
+				submitTaskResult(t, router, task, result)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var exprResp models.ExpressionResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+			expr = exprResp.Expression
+			return expr.Status != models.StatusPending && expr.Status != models.StatusProgress
+		}, 2*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, models.StatusComplete, expr.Status, tt.expression)
+		assert.Empty(t, expr.Error, tt.expression)
+		require.NotNil(t, expr.Result, tt.expression)
+		assert.Equal(t, tt.expected, expr.Result.Re, tt.expression)
+		_, ok := nextTask(t, router)
+		assert.False(t, ok, tt.expression)
+	}
+}
+
+func TestServer_Functions(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	send := func(method, path string, payload any) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		if payload != nil {
+			require.NoError(t, json.NewEncoder(&body).Encode(payload))
+		}
+		req := httptest.NewRequest(method, path, &body)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	errorOf := func(w *httptest.ResponseRecorder) string {
+		var errResp map[string]string
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+		return errResp["error"]
+	}
+
+	w := send(http.MethodPost, "/api/v1/functions",
+		models.Function{Name: "sq", Params: []string{"x"}, Body: "x*x"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = send(http.MethodPost, "/api/v1/functions",
+		models.Function{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(sq(a)+sq(b))"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created models.FunctionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "hyp", created.Function.Name)
+
+	w = send(http.MethodGet, "/api/v1/functions", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list models.FunctionsResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Functions, 2)
+	assert.Equal(t, "hyp", list.Functions[0].Name)
+	assert.Equal(t, "sq", list.Functions[1].Name)
+
+	w = send(http.MethodGet, "/api/v1/functions/sq", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got models.FunctionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, models.Function{Name: "sq", Params: []string{"x"}, Body: "x*x"}, got.Function)
+
+	// Переопределение sq через hyp замкнуло бы цикл вызовов.
+	w = send(http.MethodPost, "/api/v1/functions",
+		models.Function{Name: "sq", Params: []string{"x"}, Body: "hyp(x, 0)"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "recursive function: hyp -> sq -> hyp", errorOf(w))
+
+	w = send(http.MethodPost, "/api/v1/functions",
+		models.Function{Name: "sq", Params: []string{"x", "y"}, Body: "x*y"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code, "hyp calls sq with one argument")
+
+	w = send(http.MethodPost, "/api/v1/calculate", models.CalculateRequest{Expression: "hyp(3, 4)*2"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = send(http.MethodPost, "/api/v1/calculate", models.CalculateRequest{Expression: "hyp(3)*2"})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var parseErr models.ParseErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&parseErr))
+	assert.Equal(t, "WRONG_ARGUMENT_COUNT", parseErr.Error.Code)
+	assert.Equal(t, "invalid expression: wrong number of arguments: hyp expects 2, got 1", parseErr.Error.Message)
+	assert.Equal(t, "hyp", parseErr.Error.Token)
+
+	w = send(http.MethodDelete, "/api/v1/functions/sq", nil)
+	require.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "function is used by other functions: hyp", errorOf(w))
+
+	w = send(http.MethodDelete, "/api/v1/functions/hyp", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = send(http.MethodDelete, "/api/v1/functions/sq", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = send(http.MethodGet, "/api/v1/functions/sq", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Function not found", errorOf(w))
+	w = send(http.MethodDelete, "/api/v1/functions/sq", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServer_HandleCalculateFolding(t *testing.T) {
+	_, router := setupTestServer(t, func(cfg *configs.ServerConfig) { cfg.FoldConstants = "cheap" })
+
+	calculate := func(expression string) models.Expression {
+		body, err := json.Marshal(models.CalculateRequest{Expression: expression, Variables: map[string]float64{"x": 4}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var calcResp models.CalculateResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+		var expr models.Expression
+		require.Eventually(t, func() bool {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			var exprResp models.ExpressionResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+			expr = exprResp.Expression
+			return expr.Status != models.StatusPending
+		}, 2*time.Second, 10*time.Millisecond)
+		return expr
+	}
+
+	expr := calculate("2*3 + x")
+	assert.Equal(t, models.StatusComplete, expr.Status, "a folded expression completes without agents")
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 10}, *expr.Result)
+	assert.Equal(t, []models.EliminatedTask{
+		{Operation: "*", Position: 1, Reason: models.EliminatedFolded, Result: "6"},
+		{Operation: "+", Position: 4, Reason: models.EliminatedFolded, Result: "10"},
+	}, expr.Eliminated)
+	_, ok := nextTask(t, router)
+	assert.False(t, ok)
+
+	expr = calculate("-x")
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: -4}, *expr.Result)
+
+	expr = calculate("2*3 + sqrt(x)")
+	assert.Equal(t, models.StatusProgress, expr.Status)
+	assert.Len(t, expr.Eliminated, 1)
+	var task models.Task
+	require.Eventually(t, func() bool {
+		task, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond, "heavy operations still go to agents")
+	assert.Equal(t, "sqrt", task.Operation)
+	submitTaskResult(t, router, task, "2")
+	task, ok = nextTask(t, router)
+	require.True(t, ok)
+	assert.Equal(t, "+", task.Operation)
+	assert.Equal(t, "6", task.Arg1)
+}
+
+func TestServer_HandleCalculateSharedTask(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "sqrt(x)*sqrt(x) + sqrt(x)/2", Variables: map[string]float64{"x": 4}})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	// Агент получает задачи, пока выражение не вычислено, и выполняет только те, аргументы которых известны.
+	// Результат общей задачи sqrt(x) должен дойти до всех зависимых, в том числе до обоих аргументов умножения.
+	results := map[string]string{"sqrt": "2", "*": "4", "/": "1", "+": "5"}
+	ready := map[string]func(models.Task) bool{
+		"sqrt": func(task models.Task) bool { return true },
+		"*":    func(task models.Task) bool { return task.Arg1 == "2" && task.Arg2 == "2" },
+		"/":    func(task models.Task) bool { return task.Arg1 == "2" && task.Arg2 == "2" },
+		"+":    func(task models.Task) bool { return task.Arg1 == "4" && task.Arg2 == "1" },
+	}
+	submitted := make(map[string]bool)
+	require.Eventually(t, func() bool {
+		task, ok := nextTask(t, router)
+		if ok && !submitted[task.Operation] && ready[task.Operation](task) {
+			submitTaskResult(t, router, task, results[task.Operation])
+			submitted[task.Operation] = true
+		}
+		return len(submitted) == len(results)
+	}, 2*time.Second, time.Millisecond)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusComplete, exprResp.Expression.Status)
+	require.NotNil(t, exprResp.Expression.Result)
+	assert.Equal(t, models.Value{Re: 5}, *exprResp.Expression.Result)
+	assert.Len(t, exprResp.Expression.Eliminated, 2)
+}
+
+func TestServer_HandleDerive(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	derive := func(req models.DeriveRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/derive", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := derive(models.DeriveRequest{Expression: "x^2*sin(x)", Var: "x"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp models.DeriveResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "2 * x * sin(x) + x^2 * cos(x)", resp.Derivative)
+	require.NotNil(t, resp.AST)
+	assert.Equal(t, "binary", resp.AST.Type)
+	assert.Equal(t, "+", resp.AST.Op)
+	require.Len(t, resp.AST.Args, 2)
+	assert.Equal(t, &calculation.Tree{Type: "call", Name: "cos", Args: []*calculation.Tree{{Type: "variable", Name: "x"}}},
+		resp.AST.Args[1].Args[1])
+	assert.Empty(t, resp.ID)
+
+	// В точке производная вычисляется как обычное выражение.
+	w = derive(models.DeriveRequest{Expression: "x^3 + y*x", Var: "x", At: map[string]float64{"x": 2, "y": 1}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	resp = models.DeriveResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "3 * x^2 + y", resp.Derivative)
+	require.NotEmpty(t, resp.ID)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+resp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, "3 * x^2 + y", exprResp.Expression.Expression)
+	assert.Equal(t, map[string]float64{"x": 2, "y": 1}, exprResp.Expression.Variables)
+
+	// Производная без операций вычисляется сразу.
+	w = derive(models.DeriveRequest{Expression: "x*y + 1", Var: "x", At: map[string]float64{"y": 5}})
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = models.DeriveResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, "y", resp.Derivative)
+	assert.Empty(t, resp.ID)
+	require.NotNil(t, resp.Value)
+	assert.Equal(t, models.Value{Re: 5}, *resp.Value)
+
+	for _, tt := range []struct {
+		name string
+		req  models.DeriveRequest
+		err  string
+	}{
+		{"missing variable", models.DeriveRequest{Expression: "x^2"}, constants.ErrInvalidRequestBody},
+		{"reserved variable", models.DeriveRequest{Expression: "x^2", Var: "sqrt"}, "invalid variable name 'sqrt'"},
+		{"not differentiable", models.DeriveRequest{Expression: "x % 3", Var: "x"}, "expression is not differentiable: %"},
+		{"unknown variable at point", models.DeriveRequest{Expression: "x*y^2", Var: "x", At: map[string]float64{"x": 1}},
+			"invalid expression: unknown variable 'y'"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := derive(tt.req)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			var errResp map[string]string
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+			assert.Equal(t, tt.err, errResp["error"])
+		})
+	}
+
+	w = derive(models.DeriveRequest{Expression: "x^2 +", Var: "x"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var parseResp models.ParseErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&parseResp))
+	assert.Equal(t, constants.CodeUnexpectedEnd, parseResp.Error.Code)
+}
+
+func TestServer_HandleGetExpressionFormat(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	calculate := func(expression string) string {
+		body, err := json.Marshal(models.CalculateRequest{Expression: expression, Variables: map[string]float64{"x": 2}})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		var calcResp models.CalculateResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+		return calcResp.ID
+	}
+	get := func(path string) (*httptest.ResponseRecorder, models.ExpressionResponse) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var exprResp models.ExpressionResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		}
+		return w, exprResp
+	}
+
+	// Выражения, различающиеся только записью, получают одну каноническую форму.
+	first, second := calculate("(x)^2 +  1_000/ (4)"), calculate("x^2+1000/4")
+	_, firstResp := get("/api/v1/expressions/" + first)
+	_, secondResp := get("/api/v1/expressions/" + second)
+	assert.Equal(t, "(x)^2 +  1_000/ (4)", firstResp.Expression.Expression)
+	assert.Equal(t, "x^2 + 1000 / 4", firstResp.Expression.Canonical)
+	assert.Equal(t, firstResp.Expression.Canonical, secondResp.Expression.Canonical)
+	assert.Empty(t, firstResp.Formatted)
+
+	for _, tt := range []struct {
+		format   string
+		expected string
+	}{
+		{"canonical", "x^2 + 1000 / 4"},
+		{"minimal", "x^2+1000/4"},
+		{"latex", `{x}^{2} + \frac{1000}{4}`},
+		{"mathml", `<math xmlns="http://www.w3.org/1998/Math/MathML"><mrow><mrow><msup><mrow><mi>x</mi></mrow>` +
+			`<mrow><mn>2</mn></mrow></msup></mrow><mo>+</mo><mrow><mfrac><mrow><mn>1000</mn></mrow>` +
+			`<mrow><mn>4</mn></mrow></mfrac></mrow></mrow></math>`},
+	} {
+		w, resp := get("/api/v1/expressions/" + first + "?format=" + tt.format)
+		require.Equal(t, http.StatusOK, w.Code, tt.format)
+		assert.Equal(t, tt.expected, resp.Formatted, tt.format)
+		assert.Equal(t, first, resp.Expression.ID)
+	}
+
+	w, _ := get("/api/v1/expressions/" + first + "?format=html")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var errResp map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "unsupported format 'html'", errResp["error"])
+}
+
+func TestServer_HandleCalculateProgram(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	body, err := json.Marshal(models.CalculateRequest{
+		Expression: "a = x+2; b = a*4; c = a % 3; b^2 - a",
+		Variables:  map[string]float64{"x": 3},
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	// Привязку c результат не использует: выражение завершается, только когда вычислена и она.
+	submitted := make(map[string]bool)
+	results := map[string]string{"+": "5", "*": "20", "^": "400", "-": "395", "%": "2"}
+	ready := map[string]func(models.Task) bool{
+		"+": func(task models.Task) bool { return true },
+		"*": func(task models.Task) bool { return task.Arg1 == "5" },
+		"^": func(task models.Task) bool { return task.Arg1 == "20" },
+		"-": func(task models.Task) bool { return task.Arg1 == "400" && task.Arg2 == "5" },
+		"%": func(task models.Task) bool { return task.Arg1 == "5" },
+	}
+	getExpression := func() models.Expression {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var exprResp models.ExpressionResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		return exprResp.Expression
+	}
+	var held *models.Task // Задача привязки c откладывается до вычисления результата.
+	require.Eventually(t, func() bool {
+		task, ok := nextTask(t, router)
+		if ok && task.Operation == "%" && ready["%"](task) {
+			held = &task
+		} else if ok && !submitted[task.Operation] && ready[task.Operation](task) {
+			submitTaskResult(t, router, task, results[task.Operation])
+			submitted[task.Operation] = true
+		}
+		if held != nil && submitted["-"] {
+			assert.Nil(t, getExpression().Result, "the expression completed before all bindings were computed")
+			submitTaskResult(t, router, *held, results["%"])
+			submitted["%"] = true
+			held = nil
+		}
+		return len(submitted) == len(results)
+	}, 2*time.Second, time.Millisecond)
+
+	expr := getExpression()
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, models.Value{Re: 395}, *expr.Result)
+	assert.Equal(t, []models.Binding{
+		{Name: "a", Value: &models.Value{Re: 5}},
+		{Name: "b", Value: &models.Value{Re: 20}},
+		{Name: "c", Value: &models.Value{Re: 2}},
+	}, expr.Bindings)
+	assert.Equal(t, "a = x + 2; b = a * 4; c = a % 3; b^2 - a", expr.Canonical)
+
+	// Ошибки привязок — ошибки разбора с позицией.
+	body, err = json.Marshal(models.CalculateRequest{Expression: "a = b; b = 1; a + b"})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var errResp models.ParseErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "INVALID_BINDING", errResp.Error.Code)
+	assert.Equal(t, 4, errResp.Error.Position)
+}
+
+func TestServer_HandleCalculateArrays(t *testing.T) {
+	_, router := setupTestServer(t)
+
+	body, err := json.Marshal(models.CalculateRequest{
+		Expression: "matmul([[1, 2], [3, 4]], [[x, 6], [7, 8]])",
+		Variables:  map[string]float64{"x": 5},
+	})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	// Каждая клетка произведения — отдельная задача скалярного произведения строки на столбец.
+	computed := 0
+	require.Eventually(t, func() bool {
+		task, ok := nextTask(t, router)
+		if !ok {
+			return false
+		}
+		assert.Equal(t, "dot", task.Operation)
+		require.Len(t, task.Args, 4)
+		args := make([]float64, len(task.Args))
+		for i, arg := range task.Args {
+			args[i], err = strconv.ParseFloat(arg, 64)
+			require.NoError(t, err)
+		}
+		submitTaskResult(t, router, task, strconv.FormatFloat(args[0]*args[2]+args[1]*args[3], 'g', -1, 64))
+		computed++
+		return computed == 4
+	}, 2*time.Second, time.Millisecond)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"result_array":{"shape":[2,2],"values":[[19,22],[43,50]]}`)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &exprResp))
+	expr := exprResp.Expression
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	assert.Nil(t, expr.Result)
+	require.NotNil(t, expr.ResultArray)
+	assert.Equal(t, []int{2, 2}, expr.ResultArray.Shape)
+	assert.Equal(t, []models.Value{{Re: 19}, {Re: 22}, {Re: 43}, {Re: 50}}, expr.ResultArray.Values)
+
+	// Ветвь, выбранная по переменной, возвращается без задач для агентов.
+	body, err = json.Marshal(models.CalculateRequest{
+		Expression: "x ? [1, 2] : [3, 4]",
+		Variables:  map[string]float64{"x": 5},
+	})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var exprResp models.ExpressionResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		expr = exprResp.Expression
+		return expr.Status == models.StatusComplete
+	}, 2*time.Second, time.Millisecond)
+	require.NotNil(t, expr.ResultArray)
+	assert.Equal(t, []models.Value{{Re: 1}, {Re: 2}}, expr.ResultArray.Values)
+
+	// Несовпадение форм обнаруживается при планировании.
+	body, err = json.Marshal(models.CalculateRequest{Expression: "[1, 2] + [1, 2, 3]"})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var exprResp models.ExpressionResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		return exprResp.Expression.Status == models.StatusError &&
+			strings.HasPrefix(exprResp.Expression.Error, "shapes do not match")
+	}, 2*time.Second, time.Millisecond)
+}
+
+func TestServer_ReadyQueue(t *testing.T) {
+	_, router := setupTestServer(t, func(cfg *configs.ServerConfig) { cfg.FoldConstants = "none" })
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "(2-2) * (3+4) + 1"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	// Агенты получают задачи только с вычисленными аргументами и каждую задачу один раз.
+	results := map[string]string{"-": "0", "+": "7", "*": "0"}
+	var received []models.Task
+	require.Eventually(t, func() bool {
+		task, ok := nextTask(t, router)
+		if !ok {
+			return false
+		}
+		received = append(received, task)
+		if task.Operation == "+" && task.Arg1 == "0" {
+			submitTaskResult(t, router, task, "1")
+			return true
+		}
+		submitTaskResult(t, router, task, results[task.Operation])
+		return false
+	}, 2*time.Second, time.Millisecond)
+
+	require.Len(t, received, 4)
+	assert.ElementsMatch(t, []string{"-", "+"}, []string{received[0].Operation, received[1].Operation})
+	assert.Equal(t, "*", received[2].Operation)
+	assert.Equal(t, []string{"0", "7"}, []string{received[2].Arg1, received[2].Arg2})
+	assert.Equal(t, []string{"0", "1"}, []string{received[3].Arg1, received[3].Arg2})
+	_, ok := nextTask(t, router)
+	assert.False(t, ok)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusComplete, exprResp.Expression.Status)
+	assert.Equal(t, &models.Value{Re: 1}, exprResp.Expression.Result)
+}
+
+func TestServer_TaskLeases(t *testing.T) {
+	srv, router := setupTestServer(t, func(cfg *configs.ServerConfig) {
+		cfg.LeaseTimeoutMS = 50
+		cfg.MaxTaskAttempts = 3
+		cfg.RetryBackoffMS = 0
+	})
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "2 + 2"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	var lost models.Task
+	require.Eventually(t, func() bool {
+		var ok bool
+		lost, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NotEmpty(t, lost.LeaseID)
+	_, ok := nextTask(t, router)
+	assert.False(t, ok, "a leased task was handed out twice")
+
+	// Агент не прислал результат вовремя: задача снова выдаётся, уже под другой арендой.
+	time.Sleep(time.Until(lost.LeaseDeadline))
+	assert.Equal(t, 1, srv.ExpireLeases())
+	task, ok := nextTask(t, router)
+	require.True(t, ok)
+	assert.Equal(t, lost.ID, task.ID)
+	assert.NotEqual(t, lost.LeaseID, task.LeaseID)
+
+	body, err = json.Marshal(models.TaskResult{ID: lost.ID, Result: "5", LeaseID: lost.LeaseID})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), constants.ErrLeaseExpired)
+
+	submitTaskResult(t, router, task, "4")
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusComplete, exprResp.Expression.Status)
+	require.NotNil(t, exprResp.Expression.Result)
+	assert.Equal(t, 4.0, exprResp.Expression.Result.Re)
+}
+
+func TestServer_DeadLetter(t *testing.T) {
+	srv, router := setupTestServer(t, func(cfg *configs.ServerConfig) {
+		cfg.LeaseTimeoutMS = 20
+		cfg.MaxTaskAttempts = 1
+	})
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "2 + 2"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+	getExpression := func() models.Expression {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var exprResp models.ExpressionResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+		return exprResp.Expression
+	}
+
+	var lost models.Task
+	require.Eventually(t, func() bool {
+		var ok bool
+		lost, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(time.Until(lost.LeaseDeadline))
+	assert.Equal(t, 1, srv.ExpireLeases())
+
+	// Единственная попытка не удалась: задача в списке недоставленных, выражение завершено с ошибкой.
+	req = httptest.NewRequest(http.MethodGet, "/admin/dead-letter", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var deadResp models.DeadLetterResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&deadResp))
+	require.Len(t, deadResp.Tasks, 1)
+	assert.Equal(t, lost.ID, deadResp.Tasks[0].TaskID)
+	assert.Equal(t, calcResp.ID, deadResp.Tasks[0].ExpressionID)
+	assert.Equal(t, 1, deadResp.Tasks[0].Attempts)
+	assert.Equal(t, constants.ErrLeaseTimeout, deadResp.Tasks[0].Reason)
+	expr := getExpression()
+	assert.Equal(t, models.StatusError, expr.Status)
+	assert.Contains(t, expr.Error, constants.ErrLeaseTimeout)
+	_, ok := nextTask(t, router)
+	assert.False(t, ok)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/dead-letter/unknown/requeue", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/dead-letter/"+lost.ID+"/requeue", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, models.StatusProgress, getExpression().Status)
+
+	task, ok := nextTask(t, router)
+	require.True(t, ok)
+	assert.Equal(t, lost.ID, task.ID)
+	submitTaskResult(t, router, task, "4")
+	expr = getExpression()
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	require.NotNil(t, expr.Result)
+	assert.Equal(t, 4.0, expr.Result.Re)
+}
+
+func TestServer_TaskFailure(t *testing.T) {
+	_, router := setupTestServer(t, func(cfg *configs.ServerConfig) { cfg.FoldConstants = "none" })
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "1/0 + (2+3)"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	leased := make(map[string]models.Task)
+	require.Eventually(t, func() bool {
+		if task, ok := nextTask(t, router); ok {
+			leased[task.Operation] = task
+		}
+		return len(leased) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Агент сообщает ошибку вместо результата: выражение завершается с ней, остальные задачи отменяются.
+	division := leased["/"]
+	body, err = json.Marshal(models.TaskResult{
+		ID:      division.ID,
+		LeaseID: division.LeaseID,
+		Error:   constants.ErrDivisionByZero,
+		Code:    constants.CodeDivisionByZero,
+	})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusError, exprResp.Expression.Status)
+	assert.Equal(t, constants.ErrDivisionByZero, exprResp.Expression.Error)
+	assert.Nil(t, exprResp.Expression.Result)
+
+	addition := leased["+"]
+	body, err = json.Marshal(models.TaskResult{ID: addition.ID, Result: "5", LeaseID: addition.LeaseID})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+	_, ok := nextTask(t, router)
+	assert.False(t, ok, "a task of the failed expression was handed out")
+}
+
+func TestServer_CancelExpression(t *testing.T) {
+	_, router := setupTestServer(t, func(cfg *configs.ServerConfig) { cfg.FoldConstants = "none" })
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/non-existent", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	body, err := json.Marshal(models.CalculateRequest{Expression: "2 + 3 * 4"})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var calcResp models.CalculateResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&calcResp))
+
+	var task models.Task
+	require.Eventually(t, func() bool {
+		var ok bool
+		task, ok = nextTask(t, router)
+		return ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	req = httptest.NewRequest(http.MethodGet, "/internal/task/"+task.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/"+calcResp.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var exprResp models.ExpressionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&exprResp))
+	assert.Equal(t, models.StatusCancelled, exprResp.Expression.Status)
+
+	// Агент узнаёт об отмене, проверив задачу или отправив её результат.
+	req = httptest.NewRequest(http.MethodGet, "/internal/task/"+task.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	body, err = json.Marshal(models.TaskResult{ID: task.ID, Result: "12", LeaseID: task.LeaseID})
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+	_, ok := nextTask(t, router)
+	assert.False(t, ok, "a task of the cancelled expression was handed out")
+
+	_, completed := calculateSingleTask(t, router, models.CalculateRequest{Expression: "1 + 1"}, "2")
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/"+completed.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -9,6 +10,7 @@ import (
 
 	"distributed_calculator/internal/app/models"	
 	"distributed_calculator/internal/app/storage"	
+	"distributed_calculator/internal/constants"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -116,8 +118,8 @@ func TestStorage_SaveAndGetTask(t *testing.T) {
 
 	task := &models.Task{
 		ID:               "task-1",
-		Arg1:             2.0,
-		Arg2:             3.0,
+		Arg1:             "2",
+		Arg2:             "3",
 		Operation:        "+",
 		ExpressionID:     "expr-1",
 		DependsOnTaskIDs: []string{},
@@ -140,20 +142,20 @@ func TestStorage_UpdateTaskResult(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	store := storage.New(logger)
 
-	err := store.UpdateTaskResult("non-existent", 42.0)
+	err := store.UpdateTaskResult("non-existent", "42")
 	assert.Error(t, err)
 
 	task := &models.Task{
 		ID:               "task-1",
-		Arg1:             2.0,
-		Arg2:             3.0,
+		Arg1:             "2",
+		Arg2:             "3",
 		Operation:        "+",
 		ExpressionID:     "expr-1",
 		DependsOnTaskIDs: []string{},
 	}
 	require.NoError(t, store.SaveTask(task))
 
-	result := 5.0
+	result := "5"
 	require.NoError(t, store.UpdateTaskResult(task.ID, result))
 
 	saved, err := store.GetTask(task.ID)
@@ -172,16 +174,16 @@ func TestStorage_GetNextTask(t *testing.T) {
 	tasks := []*models.Task{
 		{
 			ID:               "task-1",
-			Arg1:             2.0,
-			Arg2:             3.0,
+			Arg1:             "2",
+			Arg2:             "3",
 			Operation:        "+",
 			ExpressionID:     "expr-1",
 			DependsOnTaskIDs: []string{},
 		},
 		{
 			ID:               "task-2",
-			Arg1:             4.0,
-			Arg2:             5.0,
+			Arg1:             "4",
+			Arg2:             "5",
 			Operation:        "*",
 			ExpressionID:     "expr-1",
 			DependsOnTaskIDs: []string{},
@@ -246,7 +248,7 @@ func TestStorage_UpdateExpressionResult(t *testing.T) {
 	saved, err := store.GetExpression(expr.ID)
 	require.NoError(t, err)
 	assert.NotNil(t, saved.Result)
-	assert.Equal(t, result, *saved.Result)
+	assert.Equal(t, models.Value{Re: result}, *saved.Result)
 	assert.Equal(t, models.StatusComplete, saved.Status)
 }
 
@@ -315,8 +317,8 @@ func TestStorage_SaveTask_Validation(t *testing.T) {
 			name: "valid task",
 			task: &models.Task{
 				ID:               "task-1",
-				Arg1:             2.0,
-				Arg2:             3.0,
+				Arg1:             "2",
+				Arg2:             "3",
 				Operation:        "+",
 				ExpressionID:     "expr-1",
 				DependsOnTaskIDs: []string{},
@@ -326,8 +328,8 @@ func TestStorage_SaveTask_Validation(t *testing.T) {
 		{
 			name: "empty id",
 			task: &models.Task{
-				Arg1:             2.0,
-				Arg2:             3.0,
+				Arg1:             "2",
+				Arg2:             "3",
 				Operation:        "+",
 				ExpressionID:     "expr-1",
 				DependsOnTaskIDs: []string{},
@@ -338,8 +340,8 @@ func TestStorage_SaveTask_Validation(t *testing.T) {
 			name: "invalid operation",
 			task: &models.Task{
 				ID:               "task-2",
-				Arg1:             2.0,
-				Arg2:             3.0,
+				Arg1:             "2",
+				Arg2:             "3",
 				Operation:        "%",
 				ExpressionID:     "expr-1",
 				DependsOnTaskIDs: []string{},
@@ -375,16 +377,16 @@ func TestStorage_TaskQueue_Behavior(t *testing.T) {
 	tasks := []*models.Task{
 		{
 			ID:               "task-1",
-			Arg1:             2.0,
-			Arg2:             3.0,
+			Arg1:             "2",
+			Arg2:             "3",
 			Operation:        "+",
 			ExpressionID:     "expr-1",
 			DependsOnTaskIDs: []string{},
 		},
 		{
 			ID:               "task-2",
-			Arg1:             4.0,
-			Arg2:             5.0,
+			Arg1:             "4",
+			Arg2:             "5",
 			Operation:        "*",
 			ExpressionID:     "expr-1",
 			DependsOnTaskIDs: []string{},
@@ -413,6 +415,308 @@ func TestStorage_TaskQueue_Behavior(t *testing.T) {
 	}
 }
 
+func TestStorage_BlockedTasks(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+
+	first := &models.Task{ID: "first", Arg1: "2", Arg2: "2", Operation: "-", ExpressionID: "expr-1"}
+	second := &models.Task{ID: "second", Arg1: "3", Arg2: "4", Operation: "+", ExpressionID: "expr-1"}
+	product := &models.Task{
+		ID:               "product",
+		Operation:        "*",
+		ExpressionID:     "expr-1",
+		DependsOnTaskIDs: []string{"first", "second"},
+		DependencySlots:  []int{0, 1},
+	}
+	call := &models.Task{
+		ID:               "call",
+		Operation:        "max",
+		Args:             []string{"", "5"},
+		ExpressionID:     "expr-1",
+		DependsOnTaskIDs: []string{"product"},
+		DependencySlots:  []int{0},
+	}
+	held := &models.Task{ID: "held", Arg1: "1", Arg2: "1", Operation: "+", ExpressionID: "expr-1", ConditionID: "cond"}
+	for _, task := range []*models.Task{first, second, product, call} {
+		require.NoError(t, store.SaveTask(task))
+	}
+	require.NoError(t, store.SaveHeldTask(held))
+
+	// В очереди только задачи без невычисленных зависимостей.
+	for _, expected := range []string{"first", "second"} {
+		task, err := store.GetNextTask()
+		require.NoError(t, err)
+		assert.Equal(t, expected, task.ID)
+	}
+	_, err := store.GetNextTask()
+	assert.Error(t, err)
+
+	// Нулевой результат — настоящий операнд, а не признак незаполненного аргумента.
+	require.NoError(t, store.UpdateTaskResult("first", "0"))
+	_, err = store.GetNextTask()
+	assert.Error(t, err, "a task was queued before all its dependencies were computed")
+
+	require.NoError(t, store.UpdateTaskResult("second", "7"))
+	task, err := store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, "product", task.ID)
+	assert.Equal(t, "0", task.Arg1)
+	assert.Equal(t, "7", task.Arg2)
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+
+	require.NoError(t, store.UpdateTaskResult("product", "0"))
+	task, err = store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, "call", task.ID)
+	assert.Equal(t, []string{"0", "5"}, task.Args)
+
+	// Отложенная задача попадает в очередь только после явного разрешения.
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	require.NoError(t, store.ReleaseTask("held"))
+	task, err = store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, "held", task.ID)
+	assert.Error(t, store.ReleaseTask("non-existent"))
+}
+
+func TestStorage_TaskLeases(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+	store.SetLeaseTimeout(time.Minute)
+	store.SetRetryPolicy(storage.DefaultMaxAttempts, 0)
+
+	require.NoError(t, store.SaveTask(&models.Task{ID: "task-1", Arg1: "2", Arg2: "3", Operation: "+", ExpressionID: "expr-1"}))
+
+	leased, err := store.GetNextTask()
+	require.NoError(t, err)
+	require.NotEmpty(t, leased.LeaseID)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), leased.LeaseDeadline, time.Second)
+
+	// Аренда видна в хранилище, пока агент не прислал результат.
+	saved, err := store.GetTask("task-1")
+	require.NoError(t, err)
+	assert.Equal(t, leased.LeaseID, saved.LeaseID)
+
+	assert.Zero(t, store.ExpireLeases(time.Now()))
+	assert.ErrorIs(t, store.SubmitTaskResult("task-1", "other-lease", "5"), storage.ErrLeaseExpired)
+
+	// Истёкшая аренда возвращает задачу в очередь, а результат по ней отклоняется.
+	assert.Equal(t, 1, store.ExpireLeases(leased.LeaseDeadline))
+	saved, err = store.GetTask("task-1")
+	require.NoError(t, err)
+	assert.Empty(t, saved.LeaseID)
+	assert.ErrorIs(t, store.SubmitTaskResult("task-1", leased.LeaseID, "5"), storage.ErrLeaseExpired)
+
+	redelivered, err := store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, "task-1", redelivered.ID)
+	assert.NotEqual(t, leased.LeaseID, redelivered.LeaseID)
+
+	require.NoError(t, store.SubmitTaskResult("task-1", redelivered.LeaseID, "5"))
+	saved, err = store.GetTask("task-1")
+	require.NoError(t, err)
+	require.NotNil(t, saved.Result)
+	assert.Equal(t, "5", *saved.Result)
+	assert.Empty(t, saved.LeaseID)
+	assert.Zero(t, store.ExpireLeases(time.Now().Add(time.Hour)))
+	assert.ErrorIs(t, store.SubmitTaskResult("task-1", redelivered.LeaseID, "5"), storage.ErrLeaseExpired)
+	assert.Error(t, store.SubmitTaskResult("non-existent", "lease", "5"))
+}
+
+func TestStorage_DeadLetter(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+	store.SetLeaseTimeout(time.Minute)
+	store.SetRetryPolicy(2, 50*time.Millisecond)
+
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-1", Expression: "2 + 3", Status: models.StatusProgress}))
+	require.NoError(t, store.SaveTask(&models.Task{ID: "task-1", Arg1: "2", Arg2: "3", Operation: "+", ExpressionID: "expr-1"}))
+
+	first, err := store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Attempts)
+	assert.Equal(t, 1, store.ExpireLeases(first.LeaseDeadline))
+
+	// Повторная попытка выдаётся только после паузы.
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	var second *models.Task
+	require.Eventually(t, func() bool {
+		second, err = store.GetNextTask()
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, second.Attempts)
+	assert.Equal(t, constants.ErrLeaseTimeout, second.LastError)
+	assert.Empty(t, store.ListDeadLetters())
+
+	// Последняя попытка не удалась: задача в списке недоставленных, выражение завершено с ошибкой.
+	assert.Equal(t, 1, store.ExpireLeases(second.LeaseDeadline))
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	deadLetters := store.ListDeadLetters()
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "task-1", deadLetters[0].TaskID)
+	assert.Equal(t, "expr-1", deadLetters[0].ExpressionID)
+	assert.Equal(t, 2, deadLetters[0].Attempts)
+	assert.Equal(t, constants.ErrLeaseTimeout, deadLetters[0].Reason)
+	expr, err := store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusError, expr.Status)
+	assert.Contains(t, expr.Error, constants.ErrLeaseTimeout)
+
+	// Оператор возвращает задачу в очередь, и выражение вычисляется до конца.
+	require.NoError(t, store.RequeueDeadLetter("task-1"))
+	assert.Empty(t, store.ListDeadLetters())
+	expr, err = store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProgress, expr.Status)
+	assert.Empty(t, expr.Error)
+
+	third, err := store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, 1, third.Attempts)
+	require.NoError(t, store.SubmitTaskResult("task-1", third.LeaseID, "5"))
+	expr, err = store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusComplete, expr.Status)
+	assert.Error(t, store.RequeueDeadLetter("task-1"))
+}
+
+func TestStorage_DeadLetterCancelsSiblings(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+	store.SetRetryPolicy(1, 0)
+
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-1", Expression: "(1+2)*(3+4)", Status: models.StatusProgress}))
+	for _, task := range []*models.Task{
+		{ID: "left", Arg1: "1", Arg2: "2", Operation: "+", ExpressionID: "expr-1"},
+		{ID: "right", Arg1: "3", Arg2: "4", Operation: "+", ExpressionID: "expr-1"},
+		{ID: "product", Operation: "*", ExpressionID: "expr-1", DependsOnTaskIDs: []string{"left", "right"}, DependencySlots: []int{0, 1}},
+	} {
+		require.NoError(t, store.SaveTask(task))
+	}
+	store.SetLeaseTimeout(time.Minute)
+	left, err := store.GetNextTask()
+	require.NoError(t, err)
+	store.SetLeaseTimeout(time.Hour)
+	right, err := store.GetNextTask()
+	require.NoError(t, err)
+
+	// Задача исчерпала попытки: остальные задачи выражения снимаются с выполнения.
+	assert.Equal(t, 1, store.ExpireLeases(left.LeaseDeadline))
+	require.Len(t, store.ListDeadLetters(), 1)
+	assert.ErrorIs(t, store.SubmitTaskResult("right", right.LeaseID, "7"), storage.ErrTaskCancelled)
+	product, err := store.GetTask("product")
+	require.NoError(t, err)
+	assert.True(t, product.Cancelled)
+	dead, err := store.GetTask("left")
+	require.NoError(t, err)
+	assert.False(t, dead.Cancelled, "the dead-lettered task stays available for requeueing")
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	assert.Zero(t, store.ExpireLeases(right.LeaseDeadline), "a cancelled task has no lease to expire")
+
+	// Задача возвращена в очередь: выражение снова вычисляется вместе с отменёнными задачами.
+	require.NoError(t, store.RequeueDeadLetter("left"))
+	expr, err := store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProgress, expr.Status)
+	results := map[string]string{"left": "3", "right": "7", "product": "21"}
+	for range results {
+		task, err := store.GetNextTask()
+		require.NoError(t, err)
+		assert.False(t, task.Cancelled)
+		require.NoError(t, store.SubmitTaskResult(task.ID, task.LeaseID, results[task.ID]))
+	}
+	expr, err = store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusComplete, expr.Status)
+}
+
+func TestStorage_RequeueDeadLetterKeepsOtherError(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+	store.SetRetryPolicy(1, 0)
+
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-1", Expression: "2 + 3", Status: models.StatusProgress}))
+	require.NoError(t, store.SaveTask(&models.Task{ID: "task-1", Arg1: "2", Arg2: "3", Operation: "+", ExpressionID: "expr-1"}))
+	task, err := store.GetNextTask()
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateExpressionError("expr-1", constants.ErrDivisionByZero))
+	assert.Equal(t, 1, store.ExpireLeases(task.LeaseDeadline))
+
+	// Выражение завершилось ошибкой не из-за этой задачи, поэтому ошибка остаётся.
+	require.NoError(t, store.RequeueDeadLetter("task-1"))
+	expr, err := store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusError, expr.Status)
+	assert.Equal(t, constants.ErrDivisionByZero, expr.Error)
+
+	// Ошибка, записанная после попадания задачи в список недоставленных, тоже остаётся.
+	store = storage.New(logger)
+	store.SetRetryPolicy(1, 0)
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-2", Expression: "2 + 3", Status: models.StatusProgress}))
+	require.NoError(t, store.SaveTask(&models.Task{ID: "task-2", Arg1: "2", Arg2: "3", Operation: "+", ExpressionID: "expr-2"}))
+	task, err = store.GetNextTask()
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.ExpireLeases(task.LeaseDeadline))
+	require.NoError(t, store.UpdateExpressionError("expr-2", constants.ErrDivisionByZero))
+	require.NoError(t, store.RequeueDeadLetter("task-2"))
+	expr, err = store.GetExpression("expr-2")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusError, expr.Status)
+	assert.Equal(t, constants.ErrDivisionByZero, expr.Error)
+}
+
+func TestStorage_CancelExpression(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	store := storage.New(logger)
+
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-1", Expression: "(1+2)*(3+4)", Status: models.StatusProgress}))
+	for _, task := range []*models.Task{
+		{ID: "left", Arg1: "1", Arg2: "2", Operation: "+", ExpressionID: "expr-1"},
+		{ID: "right", Arg1: "3", Arg2: "4", Operation: "+", ExpressionID: "expr-1"},
+		{ID: "product", Operation: "*", ExpressionID: "expr-1", DependsOnTaskIDs: []string{"left", "right"}, DependencySlots: []int{0, 1}},
+	} {
+		require.NoError(t, store.SaveTask(task))
+	}
+	inFlight, err := store.GetNextTask()
+	require.NoError(t, err)
+
+	require.NoError(t, store.CancelExpression("expr-1"))
+	expr, err := store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCancelled, expr.Status)
+
+	// Задачи убраны из очереди, а результат задачи, которую уже выполнял агент, отклоняется.
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	assert.ErrorIs(t, store.SubmitTaskResult(inFlight.ID, inFlight.LeaseID, "3"), storage.ErrTaskCancelled)
+	for _, id := range []string{"left", "right", "product"} {
+		task, err := store.GetTask(id)
+		require.NoError(t, err)
+		assert.True(t, task.Cancelled, id)
+		assert.Empty(t, task.LeaseID, id)
+	}
+
+	// Задача, сохранённая после отмены, в очередь не попадает, а результат отменённого выражения не записывается.
+	require.NoError(t, store.SaveTask(&models.Task{ID: "late", Arg1: "1", Arg2: "1", Operation: "+", ExpressionID: "expr-1"}))
+	_, err = store.GetNextTask()
+	assert.Error(t, err)
+	assert.Error(t, store.UpdateExpressionError("expr-1", "failed"))
+	assert.Error(t, store.UpdateExpressionResult("expr-1", 21))
+	expr, err = store.GetExpression("expr-1")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusCancelled, expr.Status)
+	assert.Nil(t, expr.Result)
+
+	require.NoError(t, store.SaveExpression(&models.Expression{ID: "expr-2", Expression: "1", Status: models.StatusComplete}))
+	assert.Error(t, store.CancelExpression("expr-2"))
+	assert.Error(t, store.CancelExpression("non-existent"))
+}
+
 func TestStorage_ExpressionLifecycle(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	store := storage.New(logger)
@@ -448,7 +752,7 @@ func TestStorage_ExpressionLifecycle(t *testing.T) {
 	saved, err = store.GetExpression(expr.ID)
 	require.NoError(t, err)
 	assert.Equal(t, models.StatusComplete, saved.Status)
-	assert.Equal(t, &result, saved.Result)
+	assert.Equal(t, &models.Value{Re: result}, saved.Result)
 }
 
 func TestStorage_ConcurrentTaskProcessing(t *testing.T) {
@@ -461,8 +765,8 @@ func TestStorage_ConcurrentTaskProcessing(t *testing.T) {
 	for i := 0; i < workers*tasksPerWorker; i++ {
 		task := &models.Task{
 			ID:               fmt.Sprintf("task-%d", i),
-			Arg1:             float64(i),
-			Arg2:             float64(i + 1),
+			Arg1:             strconv.Itoa(i),
+			Arg2:             strconv.Itoa(i + 1),
 			Operation:        "+",
 			ExpressionID:     "expr-1",
 			DependsOnTaskIDs: []string{},
@@ -482,7 +786,9 @@ func TestStorage_ConcurrentTaskProcessing(t *testing.T) {
 				}
 
 				time.Sleep(time.Millisecond) // Simulate processing
-				result := task.Arg1 + task.Arg2
+				arg1, _ := strconv.Atoi(task.Arg1)
+				arg2, _ := strconv.Atoi(task.Arg2)
+				result := strconv.Itoa(arg1 + arg2)
 				require.NoError(t, store.UpdateTaskResult(task.ID, result))
 
 				mu.Lock()
@@ -12,6 +12,7 @@ import (
 	"distributed_calculator/internal/logger"
 	"distributed_calculator/internal/worker"
 	"distributed_calculator/internal/app/models"
+	"distributed_calculator/internal/constants"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -33,19 +34,20 @@ func TestAgent_Calculate(t *testing.T) {
 	tests := []struct {
 		name        string
 		task        *models.Task
-		expected    float64
+		expected    string
 		expectError bool
+		code        string // Код ошибки, если он важен для случая.
 	}{
 		{
 			name: "Addition",
 			task: &models.Task{
 				ID:               "1",
 				Operation:        "+",
-				Arg1:             10,
-				Arg2:             5,
+				Arg1:             "10",
+				Arg2:             "5",
 				DependsOnTaskIDs: []string{}, // Добавлено для соответствия новой структуре, но не используется в Calculate
 			},
-			expected:    15,
+			expected:    "15",
 			expectError: false,
 		},
 		{
@@ -53,11 +55,11 @@ func TestAgent_Calculate(t *testing.T) {
 			task: &models.Task{
 				ID:               "2",
 				Operation:        "-",
-				Arg1:             10,
-				Arg2:             5,
+				Arg1:             "10",
+				Arg2:             "5",
 				DependsOnTaskIDs: []string{},
 			},
-			expected:    5,
+			expected:    "5",
 			expectError: false,
 		},
 		{
@@ -65,11 +67,11 @@ func TestAgent_Calculate(t *testing.T) {
 			task: &models.Task{
 				ID:               "3",
 				Operation:        "*",
-				Arg1:             10,
-				Arg2:             5,
+				Arg1:             "10",
+				Arg2:             "5",
 				DependsOnTaskIDs: []string{},
 			},
-			expected:    50,
+			expected:    "50",
 			expectError: false,
 		},
 		{
@@ -77,11 +79,11 @@ func TestAgent_Calculate(t *testing.T) {
 			task: &models.Task{
 				ID:               "4",
 				Operation:        "/",
-				Arg1:             10,
-				Arg2:             5,
+				Arg1:             "10",
+				Arg2:             "5",
 				DependsOnTaskIDs: []string{},
 			},
-			expected:    2,
+			expected:    "2",
 			expectError: false,
 		},
 		{
@@ -89,32 +91,257 @@ func TestAgent_Calculate(t *testing.T) {
 			task: &models.Task{
 				ID:               "5",
 				Operation:        "/",
-				Arg1:             10,
-				Arg2:             0,
+				Arg1:             "10",
+				Arg2:             "0",
 				DependsOnTaskIDs: []string{},
 			},
 			expectError: true,
+			code:        constants.CodeDivisionByZero,
+		},
+		{
+			name: "Power",
+			task: &models.Task{
+				ID:               "7",
+				Operation:        "^",
+				Arg1:             "2",
+				Arg2:             "10",
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "1024",
+			expectError: false,
+		},
+		{
+			name: "Modulo",
+			task: &models.Task{
+				ID:               "8",
+				Operation:        "%",
+				Arg1:             "10",
+				Arg2:             "4",
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "2",
+			expectError: false,
+		},
+		{
+			name: "Modulo by zero",
+			task: &models.Task{
+				ID:               "9",
+				Operation:        "%",
+				Arg1:             "10",
+				Arg2:             "0",
+				DependsOnTaskIDs: []string{},
+			},
+			expectError: true,
+			code:        constants.CodeDivisionByZero,
+		},
+		{
+			name: "Modulo of fractions",
+			task: &models.Task{
+				ID:               "10",
+				Operation:        "%",
+				Arg1:             "2.5",
+				Arg2:             "2",
+				DependsOnTaskIDs: []string{},
+			},
+			expectError: true,
+		},
+		{
+			name: "Function call",
+			task: &models.Task{
+				ID:               "11",
+				Operation:        "max",
+				Args:             []string{"3", "7", "5"},
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "7",
+			expectError: false,
+		},
+		{
+			name: "Function domain error",
+			task: &models.Task{
+				ID:               "12",
+				Operation:        "log",
+				Args:             []string{"0"},
+				DependsOnTaskIDs: []string{},
+			},
+			expectError: true,
+		},
+		{
+			name: "Decimal addition",
+			task: &models.Task{
+				ID:               "13",
+				Operation:        "+",
+				Mode:             "decimal",
+				Precision:        10,
+				Arg1:             "0.1",
+				Arg2:             "0.2",
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "0.3",
+			expectError: false,
+		},
+		{
+			name: "Decimal division rounds to precision",
+			task: &models.Task{
+				ID:               "14",
+				Operation:        "/",
+				Mode:             "decimal",
+				Precision:        5,
+				Arg1:             "2",
+				Arg2:             "3",
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "0.66667",
+			expectError: false,
+		},
+		{
+			name: "Decimal sine to precision",
+			task: &models.Task{
+				ID:        "14a",
+				Operation: "sin",
+				Mode:      "decimal",
+				Precision: 40,
+				Args:      []string{"1"},
+			},
+			expected: "0.8414709848078965066525023216302989996226",
+		},
+		{
+			name: "Decimal fractional power to precision",
+			task: &models.Task{
+				ID:        "14b",
+				Operation: "^",
+				Mode:      "decimal",
+				Precision: 40,
+				Arg1:      "2",
+				Arg2:      "0.5",
+			},
+			expected: "1.4142135623730950488016887242096980785697",
+		},
+		{
+			name: "Rational division",
+			task: &models.Task{
+				ID:               "15",
+				Operation:        "/",
+				Mode:             "rational",
+				Arg1:             "1/3",
+				Arg2:             "2",
+				DependsOnTaskIDs: []string{},
+			},
+			expected:    "1/6",
+			expectError: false,
+		},
+		{
+			name: "Rational irrational root",
+			task: &models.Task{
+				ID:               "16",
+				Operation:        "sqrt",
+				Mode:             "rational",
+				Args:             []string{"2"},
+				DependsOnTaskIDs: []string{},
+			},
+			expectError: true,
+		},
+		{
+			name: "Int64 floor division",
+			task: &models.Task{
+				ID:        "int64-1",
+				Operation: "//",
+				Mode:      "int64",
+				Arg1:      "-7",
+				Arg2:      "2",
+			},
+			expected: "-4",
+		},
+		{
+			name: "Int64 shift",
+			task: &models.Task{
+				ID:        "int64-2",
+				Operation: "<<",
+				Mode:      "int64",
+				Arg1:      "3",
+				Arg2:      "4",
+			},
+			expected: "48",
+		},
+		{
+			name: "Int64 overflow",
+			task: &models.Task{
+				ID:        "int64-3",
+				Operation: "*",
+				Mode:      "int64",
+				Arg1:      "9223372036854775807",
+				Arg2:      "2",
+			},
+			expectError: true,
+		},
+		{
+			name: "Bitwise operation in float64 mode",
+			task: &models.Task{
+				ID:        "int64-4",
+				Operation: "&",
+				Arg1:      "6",
+				Arg2:      "3",
+			},
+			expectError: true,
+		},
+		{
+			name: "Fractional power of negative number",
+			task: &models.Task{
+				ID:        "pow-1",
+				Operation: "^",
+				Arg1:      "-4",
+				Arg2:      "0.5",
+			},
+			expected: "2i",
+		},
+		{
+			name: "Non-finite power",
+			task: &models.Task{
+				ID:        "inf-1",
+				Operation: "^",
+				Arg1:      "10",
+				Arg2:      "400",
+			},
+			expectError: true,
+			code:        constants.CodeCalculationError,
+		},
+		{
+			name: "Infinite argument",
+			task: &models.Task{
+				ID:        "inf-2",
+				Operation: "+",
+				Arg1:      "+Inf",
+				Arg2:      "1",
+			},
+			expectError: true,
+			code:        constants.CodeInvalidArgument,
 		},
 		{
 			name: "Unknown operation",
 			task: &models.Task{
 				ID:               "6",
-				Operation:        "%",
-				Arg1:             10,
-				Arg2:             5,
+				Operation:        "@",
+				Arg1:             "10",
+				Arg2:             "5",
 				DependsOnTaskIDs: []string{},
 			},
 			expectError: true,
+			code:        constants.CodeInvalidOperation,
 		},
 	}
 
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
+			result, err := agent.Calculate(tt.task)
 			if tt.expectError {
-				assert.Panics(t, func() { agent.Calculate(tt.task) }, "Expected panic but got none")
+				var taskErr *worker.TaskError
+				require.ErrorAs(t, err, &taskErr)
+				if tt.code != "" {
+					assert.Equal(t, tt.code, taskErr.Code)
+				}
 			} else {
-				result := agent.Calculate(tt.task)
+				require.NoError(t, err)
 				assert.Equal(t, tt.expected, result)
 			}
 		})
@@ -193,9 +420,10 @@ func TestAgent_Integration(t *testing.T) {
 	task := models.Task{
 		ID:               "test-task",
 		Operation:        "+",
-		Arg1:             10,
-		Arg2:             5,
+		Arg1:             "10",
+		Arg2:             "5",
 		DependsOnTaskIDs: []string{}, // Добавлено для соответствия новой структуре
+		LeaseID:          "test-lease",
 	}
 
 	select {
@@ -207,12 +435,107 @@ func TestAgent_Integration(t *testing.T) {
 	select {
 	case result := <-resultCh:
 		assert.Equal(t, task.ID, result.ID)
-		assert.Equal(t, float64(15), result.Result)
+		assert.Equal(t, "15", result.Result)
+		assert.Equal(t, task.LeaseID, result.LeaseID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for result")
+	}
+
+	// Ошибку вычисления агент сообщает оркестратору и продолжает работу.
+	failing := models.Task{ID: "failing-task", Operation: "/", Arg1: "1", Arg2: "0", LeaseID: "failing-lease"}
+	select {
+	case taskCh <- failing:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout sending task")
+	}
+
+	select {
+	case result := <-resultCh:
+		assert.Equal(t, failing.ID, result.ID)
+		assert.Empty(t, result.Result)
+		assert.Equal(t, constants.ErrDivisionByZero, result.Error)
+		assert.Equal(t, constants.CodeDivisionByZero, result.Code)
+		assert.Equal(t, failing.LeaseID, result.LeaseID)
 	case <-time.After(5 * time.Second):
 		t.Fatal("timeout waiting for result")
 	}
 }
 
+func TestAgent_Cancellation(t *testing.T) {
+	task := models.Task{ID: "cancelled-task", Operation: "+", Arg1: "1", Arg2: "2", LeaseID: "lease"}
+	taskCh := make(chan models.Task, 1)
+	taskCh <- task
+	checked := make(chan struct{}, 1)
+	polled := make(chan struct{}, 1)
+	submitted := make(chan models.TaskResult, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/internal/task":
+			select {
+			case task := <-taskCh:
+				if err := json.NewEncoder(w).Encode(models.TaskResponse{Task: task}); err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			default:
+				select {
+				case polled <- struct{}{}:
+				default:
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodGet && r.URL.Path == "/internal/task/"+task.ID:
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+			w.WriteHeader(http.StatusGone)
+		case r.Method == http.MethodPost:
+			var result models.TaskResult
+			if err := json.NewDecoder(r.Body).Decode(&result); err == nil {
+				submitted <- result
+			}
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer server.Close()
+
+	log, err := logger.New(logger.Options{
+		Level:       logger.Debug,
+		Encoding:    "json",
+		OutputPath:  []string{"stdout"},
+		ErrorPath:   []string{"stderr"},
+		Development: true,
+	})
+	require.NoError(t, err)
+
+	agent := worker.New(&configs.WorkerConfig{
+		ComputingPower:  1,
+		OrchestratorURL: server.URL,
+		AdditionTimeMS:  10000,
+		CancelCheckMS:   10,
+	}, log)
+	require.NoError(t, agent.Start())
+	defer agent.Stop()
+
+	// Агент узнаёт об отмене во время имитации вычисления и не ждёт её окончания.
+	select {
+	case <-checked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("agent did not check the task for cancellation")
+	}
+	select {
+	case <-polled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("agent kept simulating a cancelled task")
+	}
+	select {
+	case result := <-submitted:
+		t.Fatalf("agent submitted a result for a cancelled task: %+v", result)
+	default:
+	}
+}
+
 func TestAgent_Config(t *testing.T) {
 	t.Parallel()
 